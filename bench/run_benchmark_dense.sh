@@ -0,0 +1,30 @@
+#!/bin/sh
+
+echo Test: Map-based vs dense array-backed taxonomy
+
+# taxonkit binaries built before (map-based) and after (dense) the change,
+# e.g., taxonkit-map and taxonkit
+old=${TAXONKIT_OLD:-taxonkit-map}
+new=${TAXONKIT_NEW:-taxonkit}
+
+function check() {
+    md5sum $1
+    # /bin/rm $1
+}
+
+
+for f in taxids.n*.txt; do
+    for app in $old $new; do
+        echo == $app lineage
+        echo data: $f
+        out=$f.$app.lineage
+        memusg -t -H -s " $app lineage --threads 4 < $f > $out "
+        check $out
+
+        echo == $app reformat2
+        echo data: $f
+        out=$f.$app.reformat2
+        memusg -t -H -s " $app reformat2 --threads 4 -I 1 < $f > $out "
+        check $out
+    done
+done
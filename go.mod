@@ -3,7 +3,9 @@ module github.com/shenwei356/taxonkit
 go 1.24.2
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/cespare/xxhash/v2 v2.1.2
+	github.com/edsrzf/mmap-go v1.0.0
 	github.com/mattn/go-colorable v0.1.10
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pkg/errors v0.9.1
@@ -13,15 +15,17 @@ require (
 	github.com/shenwei356/util v0.5.2
 	github.com/shenwei356/xopen v0.3.2
 	github.com/spf13/cobra v1.8.0
+	github.com/spf13/pflag v1.0.5
 	github.com/suggest-go/suggest v0.0.0-20210111224047-3b44145ad0b0
 	github.com/twotwotwo/sorts v0.0.0-20160814051341-bf5c1f2b8553
+	golang.org/x/term v0.35.0
 )
 
 require (
 	github.com/RoaringBitmap/roaring v0.5.5 // indirect
 	github.com/alldroll/cdb v1.0.2 // indirect
 	github.com/dsnet/compress v0.0.1 // indirect
-	github.com/edsrzf/mmap-go v1.0.0 // indirect
+	github.com/elliotwutingfeng/asciiset v0.0.0-20230602022725-51bbb787efab // indirect
 	github.com/glycerine/go-unsnap-stream v0.0.0-20181221182339-f9677308dec2 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
@@ -34,10 +38,9 @@ require (
 	github.com/rogpeppe/go-internal v1.9.0 // indirect
 	github.com/shenwei356/natsort v0.0.0-20190418160752-600d539c017d // indirect
 	github.com/snowballstem/snowball v2.0.0+incompatible // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/tinylib/msgp v1.1.0 // indirect
 	github.com/ulikunitz/xz v0.5.14 // indirect
 	github.com/willf/bitset v1.1.10 // indirect
 	golang.org/x/sync v0.0.0-20200625203802-6e8e738ad208 // indirect
-	golang.org/x/sys v0.6.0 // indirect
+	golang.org/x/sys v0.36.0 // indirect
 )
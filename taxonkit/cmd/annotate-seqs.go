@@ -0,0 +1,334 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// annotateSeqsCmd represents the annotate-seqs command
+var annotateSeqsCmd = &cobra.Command{
+	Use:   "annotate-seqs",
+	Short: "Annotate FASTA/FASTQ headers with taxonomic information",
+	Long: `Annotate FASTA/FASTQ headers with taxonomic information
+
+Input:
+
+  - FASTA/FASTQ sequences, with TaxIds in headers, e.g.,
+      >NC_000913.3 taxid=511145 Escherichia coli str. K-12 substr. MG1655
+  - TaxIds are extracted with a regular expression (--taxid-regex)
+    containing a capture group. Only the first match is used.
+  - Supporting (gzipped) file or STDIN.
+
+Output:
+
+  - Sequences with headers rewritten according to a template (-f/--format).
+  - Records without TaxIds, or with TaxIds kept by --on-deleted/--on-unknown
+    keep, are outputted unchanged, unless --drop-no-taxid is given.
+  - Records with deleted or unknown TaxIds can be removed with the global flags
+    --on-deleted drop and --on-unknown drop.
+  - Sequences are not validated, and FASTA sequences are wrapped with
+    -w/--line-width.
+
+Placeholders of the header template:
+
+  {header}          original header
+  {id}              sequence ID, i.e., the first word of the header
+  {desc}            description, i.e., the header without the ID
+  {taxid}           TaxId, or the new one if it was merged
+  {name}            scientific name
+  {rank}            rank
+  {lineage}         lineage formatted with -F/--lineage-format, like 'reformat2'
+  {lineage_taxids}  TaxIds of the formatted lineage
+
+Examples:
+
+  # append the lineage
+  taxonkit annotate-seqs seqs.fa.gz -o annotated.fa.gz
+
+  # only keep bacterial sequences, with a new header
+  taxonkit annotate-seqs seqs.fa.gz -t 2 -f "{id} {name}|{rank}|{taxid}"
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		reTaxId := getTaxIdRegex(cmd)
+		if reTaxId == nil {
+			checkError(fmt.Errorf("flag --taxid-regex needed"))
+		}
+		template, err := newHeaderTemplate(getFlagString(cmd, "format"))
+		checkError(err)
+		lineageFormat := getFlagString(cmd, "lineage-format")
+		noRanks := getFlagStringSlice(cmd, "no-ranks")
+		subtreesInt := getFlagTaxonIDs(cmd, "subtrees")
+		dropNoTaxid := getFlagBool(cmd, "drop-no-taxid")
+		lineWidth := getFlagNonNegativeInt(cmd, "line-width")
+
+		formatter, err := taxonomy.NewLineageFormatter(lineageFormat, noRanks)
+		checkError(err)
+		formatter.MissRankRepl = getFlagString(cmd, "miss-rank-repl")
+		formatter.MissTaxIdRepl = getFlagString(cmd, "miss-taxid-repl")
+		formatter.Trim = getFlagBool(cmd, "trim")
+		formatter.TaxIds = template.has("lineage_taxids")
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		// --------------------------------------------------------
+		// load data
+
+		parts := taxonomy.PartNodes | taxonomy.PartDelNodes | taxonomy.PartMerged
+		if template.has("name") || template.has("lineage") || template.has("lineage_taxids") {
+			parts |= taxonomy.PartNames
+		}
+		if template.has("rank") || template.has("lineage") || template.has("lineage_taxids") {
+			parts |= taxonomy.PartRanks
+		}
+		taxdb := loadTaxonomy(&config, parts)
+
+		subtrees := make([]uint32, 0, len(subtreesInt))
+		for _, id := range subtreesInt {
+			taxid, status := taxdb.Resolve(uint32(id))
+			switch status {
+			case taxonomy.Merged:
+				log.Warningf("subtree taxid %d was merged into %d", id, taxid)
+			case taxonomy.Deleted, taxonomy.NotFound:
+				checkError(fmt.Errorf("subtree taxid %d not found", id))
+			}
+			subtrees = append(subtrees, taxid)
+		}
+
+		inSubtrees := func(taxid uint32) bool {
+			if len(subtrees) == 0 {
+				return true
+			}
+			for _, sub := range subtrees {
+				if taxdb.LCA(taxid, sub) == sub {
+					return true
+				}
+			}
+			return false
+		}
+
+		// --------------------------------------------------------
+
+		// annotations of TaxIds in use, nil for TaxIds out of the subtrees
+		type annotation struct {
+			values map[string]string
+		}
+		cache := make(map[uint32]*annotation, 1024)
+
+		// annotate returns the annotation of a TaxId, nil for records to output unchanged,
+		// it returns false for dropped TaxIds.
+		// The TaxId is checked with the policy for every occurrence, to count them correctly.
+		annotate := func(taxid uint32) (*annotation, bool) {
+			newtaxid, _, action := config.TaxIdPolicy.Resolve(taxdb, taxid)
+			switch action {
+			case actionDrop:
+				return nil, false
+			case actionKeep:
+				return nil, true
+			}
+
+			if a, ok := cache[newtaxid]; ok {
+				return a, a != nil
+			}
+			if !inSubtrees(newtaxid) {
+				cache[newtaxid] = nil
+				return nil, false
+			}
+
+			a := &annotation{values: map[string]string{
+				"taxid": strconv.FormatUint(uint64(newtaxid), 10),
+			}}
+			if template.has("name") {
+				a.values["name"] = taxdb.Name(newtaxid)
+			}
+			if template.has("rank") {
+				a.values["rank"] = taxdb.Rank(newtaxid)
+			}
+			if template.has("lineage") || template.has("lineage_taxids") {
+				lineage, err := taxdb.Lineage(newtaxid)
+				checkError(err)
+				a.values["lineage"], a.values["lineage_taxids"] = formatter.Format(lineage)
+			}
+
+			cache[newtaxid] = a
+			return a, true
+		}
+
+		var nRecords, nAnnotated, nNoTaxid, nDropped int
+		var reader *fastx.Reader
+		var record *fastx.Record
+		// values of the record, separated from the cached annotations
+		values := make(map[string]string, 3)
+		var header string
+		for _, file := range files {
+			reader, err = fastx.NewReader(seq.Unlimit, file, "")
+			checkError(err)
+
+			for {
+				record, err = reader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+				}
+				nRecords++
+
+				header = string(record.Name)
+				ids := extractTaxIds(reTaxId, header)
+				if len(ids) == 0 {
+					nNoTaxid++
+					if dropNoTaxid {
+						nDropped++
+						continue
+					}
+					record.FormatToWriter(outfh, lineWidth)
+					continue
+				}
+
+				a, ok := annotate(ids[0])
+				if !ok {
+					nDropped++
+					continue
+				}
+				if a == nil {
+					record.FormatToWriter(outfh, lineWidth)
+					continue
+				}
+
+				values["header"] = header
+				values["id"] = string(record.ID)
+				values["desc"] = strings.TrimSpace(string(record.Desc))
+				record.Name = []byte(template.render(values, a.values))
+				record.FormatToWriter(outfh, lineWidth)
+				nAnnotated++
+			}
+
+			reader.Close()
+		}
+
+		if config.Verbose {
+			log.Infof("%d records processed: %d annotated, %d without TaxIds, %d dropped",
+				nRecords, nAnnotated, nNoTaxid, nDropped)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(annotateSeqsCmd)
+
+	annotateSeqsCmd.Flags().StringP("taxid-regex", "", `\btaxid[=:|](\d+)`, `regular expression with a capture group for extracting TaxIds from sequence headers`)
+	annotateSeqsCmd.Flags().StringP("format", "f", "{header} {lineage}", `template of new headers, see the placeholders above`)
+	annotateSeqsCmd.Flags().StringP("lineage-format", "F", "{domain|acellular root|superkingdom};{phylum};{class};{order};{family};{genus};{species}", `format of {lineage}, the same as -f/--format of 'reformat2'`)
+	annotateSeqsCmd.Flags().StringP("miss-rank-repl", "r", "", `replacement string for missing rank in {lineage}`)
+	annotateSeqsCmd.Flags().StringP("miss-taxid-repl", "R", "", `replacement string for missing taxid in {lineage_taxids}`)
+	annotateSeqsCmd.Flags().BoolP("trim", "T", false, "do not replace missing ranks lower than the rank of the current node")
+	annotateSeqsCmd.Flags().StringSliceP("no-ranks", "B", []string{"no rank", "clade"}, `rank names of no-rank. A lineage might have many "no rank" ranks, we only keep the last one below known ranks`)
+	annotateSeqsCmd.Flags().StringP("subtrees", "t", "", `only keep records belonging to these subtrees, i.e., comma-separated TaxIds of ancestors`)
+	annotateSeqsCmd.Flags().BoolP("drop-no-taxid", "D", false, `drop records without TaxIds in headers`)
+	annotateSeqsCmd.Flags().IntP("line-width", "w", 60, `line width of FASTA sequences, 0 for no wrap`)
+}
+
+var reHeaderPlaceHolder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// headerPlaceHolders are placeholders supported in header templates of annotate-seqs.
+var headerPlaceHolders = map[string]struct{}{
+	"header":         {},
+	"id":             {},
+	"desc":           {},
+	"taxid":          {},
+	"name":           {},
+	"rank":           {},
+	"lineage":        {},
+	"lineage_taxids": {},
+}
+
+// headerTemplate is a parsed template of sequence headers.
+type headerTemplate struct {
+	texts []string // texts[i] is followed by keys[i]
+	keys  []string
+	used  map[string]struct{}
+}
+
+func newHeaderTemplate(format string) (*headerTemplate, error) {
+	if format == "" {
+		return nil, fmt.Errorf("header template should not be empty")
+	}
+	t := &headerTemplate{used: make(map[string]struct{})}
+
+	var start int
+	for _, loc := range reHeaderPlaceHolder.FindAllStringSubmatchIndex(format, -1) {
+		key := format[loc[2]:loc[3]]
+		if _, ok := headerPlaceHolders[key]; !ok {
+			return nil, fmt.Errorf("unknown placeholder in header template: {%s}", key)
+		}
+		t.texts = append(t.texts, format[start:loc[0]])
+		t.keys = append(t.keys, key)
+		t.used[key] = struct{}{}
+		start = loc[1]
+	}
+	t.texts = append(t.texts, format[start:])
+
+	return t, nil
+}
+
+func (t *headerTemplate) has(key string) bool {
+	_, ok := t.used[key]
+	return ok
+}
+
+// render fills the template with values of the record (header, id and desc)
+// and the annotation of the TaxId.
+func (t *headerTemplate) render(record, annotation map[string]string) string {
+	var b strings.Builder
+	var value string
+	var ok bool
+	for i, key := range t.keys {
+		b.WriteString(t.texts[i])
+		if value, ok = record[key]; !ok {
+			value = annotation[key]
+		}
+		b.WriteString(value)
+	}
+	b.WriteString(t.texts[len(t.texts)-1])
+	return b.String()
+}
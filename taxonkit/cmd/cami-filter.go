@@ -27,6 +27,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -188,7 +189,7 @@ Examples:
 		rankMap := make(map[uint32]string, 1024)
 		meta := make([]string, 0, 8)
 
-		targets := make([]*Target, 0, 512)
+		targets := make([]*taxonomy.Target, 0, 512)
 
 		for scanner.Scan() {
 			line = scanner.Text()
@@ -203,7 +204,7 @@ Examples:
 
 					profile := generateProfile2(targets, targets1)
 
-					nodes := make([]*ProfileNode, 0, len(profile))
+					nodes := make([]*taxonomy.ProfileNode, 0, len(profile))
 					for _, node := range profile {
 						nodes = append(nodes, node)
 					}
@@ -304,7 +305,7 @@ Examples:
 				taxidsUint = append(taxidsUint, uint32(_taxid))
 			}
 
-			targets = append(targets, &Target{
+			targets = append(targets, &taxonomy.Target{
 				Taxid:     taxid,
 				Abundance: percenage,
 
@@ -328,7 +329,7 @@ Examples:
 
 			profile := generateProfile2(targets, targets1)
 
-			nodes := make([]*ProfileNode, 0, len(profile))
+			nodes := make([]*taxonomy.ProfileNode, 0, len(profile))
 			for _, node := range profile {
 				nodes = append(nodes, node)
 			}
@@ -373,21 +374,21 @@ Examples:
 	},
 }
 
-func generateProfile2(targets0, targets []*Target) map[uint32]*ProfileNode {
+func generateProfile2(targets0, targets []*taxonomy.Target) map[uint32]*taxonomy.ProfileNode {
 
-	targetsMap := make(map[uint32]*Target, len(targets0))
+	targetsMap := make(map[uint32]*taxonomy.Target, len(targets0))
 	for _, target := range targets0 {
 		targetsMap[target.Taxid] = target
 	}
 
-	profile := make(map[uint32]*ProfileNode, len(targets))
+	profile := make(map[uint32]*taxonomy.ProfileNode, len(targets))
 
-	var target0 *Target
+	var target0 *taxonomy.Target
 	for _, target := range targets {
 		for _, taxid := range target.CompleteLineageTaxids {
 			if node, ok := profile[taxid]; !ok {
 				if target0, ok = targetsMap[taxid]; ok {
-					profile[taxid] = &ProfileNode{
+					profile[taxid] = &taxonomy.ProfileNode{
 						Taxid:         taxid,
 						Rank:          target0.Rank,
 						TaxonName:     target0.TaxonName,
@@ -406,9 +407,9 @@ func generateProfile2(targets0, targets []*Target) map[uint32]*ProfileNode {
 	return profile
 }
 
-func filterLeaves(rankMap map[uint32]string, leavesRanksMap map[string]interface{}, targets []*Target) []*Target {
+func filterLeaves(rankMap map[uint32]string, leavesRanksMap map[string]interface{}, targets []*taxonomy.Target) []*taxonomy.Target {
 
-	targetsMap := make(map[uint32]*Target, len(targets))
+	targetsMap := make(map[uint32]*taxonomy.Target, len(targets))
 	// parent -> son -> leave
 	tree := make(map[uint32]map[uint32]uint32, 1024)
 
@@ -443,7 +444,7 @@ func filterLeaves(rankMap map[uint32]string, leavesRanksMap map[string]interface
 		}
 	}
 	// leaves := make([]uint32, 0, 1024)
-	leaves := make([]*Target, 0, 1024)
+	leaves := make([]*taxonomy.Target, 0, 1024)
 	for _, m := range tree {
 		for taxid = range m {
 			if _, ok = tree[taxid]; !ok {
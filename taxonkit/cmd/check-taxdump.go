@@ -0,0 +1,131 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// checkTaxdumpCmd represents the check-taxdump command
+var checkTaxdumpCmd = &cobra.Command{
+	Use:   "check-taxdump",
+	Short: "Check structural problems in taxdump files",
+	Long: `Check structural problems in taxdump files
+
+Broken taxdump files, e.g., custom ones created by "taxonkit create-taxdump"
+or edited by hand, might make other commands loop or output partial lineages.
+This command scans nodes.dmp, names.dmp, delnodes.dmp and merged.dmp in
+the data directory (or a taxdump archive) and reports all problems below.
+It exits with a non-zero status if any problem is found.
+
+Problems:
+
+    malformed-line              lines with too few columns or invalid TaxIds
+    duplicated-taxid            TaxIds appearing more than once in a file
+    parent-not-found            parent TaxIds not in nodes.dmp
+    no-root                     no root node, whose parent is itself
+    multiple-roots              more than one root node
+    cycle                       TaxIds in a cycle, which never reach the root
+    no-scientific-name          nodes without scientific names in names.dmp
+    multiple-scientific-names   nodes with more than one scientific name
+    name-of-unknown-taxid       names of TaxIds not in nodes.dmp
+    deleted-but-live            deleted TaxIds which are also in nodes.dmp
+    merged-but-live             merged TaxIds which are also in nodes.dmp
+    merged-and-deleted          merged TaxIds which are also deleted
+    merged-into-deleted         TaxIds merged into deleted TaxIds
+    merged-into-merged          TaxIds merged into merged TaxIds
+    merged-into-unknown         TaxIds merged into TaxIds not in nodes.dmp
+
+Output (tab-delimited):
+
+    1. file name
+    2. line number, 0 for problems of the whole file
+    3. TaxId, 0 for unavailable
+    4. problem type
+    5. message
+
+Examples:
+
+    $ taxonkit check-taxdump --data-dir gtdb-taxdump/
+    $ taxonkit check-taxdump --data-dir taxdump.tar.gz
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		var issues []taxonomy.Issue
+		var err error
+		if config.Archive != "" {
+			issues, err = taxonomy.CheckArchive(config.Archive)
+		} else {
+			files := taxonomy.Files{
+				Nodes:    config.NodesFile,
+				Names:    config.NamesFile,
+				DelNodes: config.DelNodesFile,
+				Merged:   config.MergedFile,
+			}
+			for _, file := range []*string{&files.DelNodes, &files.Merged} {
+				existed, err := pathutil.Exists(*file)
+				checkError(err)
+				if !existed {
+					log.Warningf("file not found, skipped: %s", *file)
+					*file = ""
+				}
+			}
+			issues, err = taxonomy.Check(files)
+		}
+		checkError(err)
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+
+		counts := make(map[taxonomy.IssueType]int, 16)
+		for _, issue := range issues {
+			counts[issue.Type]++
+			fmt.Fprintf(outfh, "%s\t%d\t%d\t%s\t%s\n", issue.File, issue.Line, issue.TaxId, issue.Type, issue.Message)
+		}
+		checkError(outfh.Close())
+
+		if len(issues) == 0 {
+			log.Infof("no problems found in %s", config.DataDir)
+			return
+		}
+
+		types := make([]string, 0, len(counts))
+		for t := range counts {
+			types = append(types, string(t))
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			log.Warningf("  %s: %d", t, counts[taxonomy.IssueType(t)])
+		}
+		checkError(fmt.Errorf("%d problems found in %s", len(issues), config.DataDir))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(checkTaxdumpCmd)
+}
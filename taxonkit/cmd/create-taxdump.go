@@ -29,10 +29,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/cespare/xxhash/v2"
-	"github.com/shenwei356/bio/taxdump"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/pathutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -175,64 +175,41 @@ Attention:
 
 		oldTaxdumpDir := getFlagString(cmd, "old-taxdump-dir")
 
-		var taxdb *taxdump.Taxonomy
+		var taxdb *taxonomy.Taxonomy
 
 		if oldTaxdumpDir != "" {
 			log.Infof("loading Taxonomy from: %s", oldTaxdumpDir)
 
-			taxdb, err = taxdump.NewTaxonomyWithRankFromNCBI(filepath.Join(oldTaxdumpDir, "nodes.dmp"))
-			if err != nil {
-				checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
-			}
-			log.Infof("  %d nodes in %d ranks loaded", len(taxdb.Nodes), len(taxdb.Ranks))
+			files := taxonomy.Files{Nodes: filepath.Join(oldTaxdumpDir, "nodes.dmp")}
 
 			var existed bool
+			file := filepath.Join(oldTaxdumpDir, "delnodes.dmp")
+			existed, err = pathutil.Exists(file)
+			if err != nil {
+				checkError(fmt.Errorf("err on checking file delnodes.dmp: %s", err))
+			}
+			if existed {
+				files.DelNodes = file
+			}
 
-			var wg sync.WaitGroup
-			wg.Add(3)
+			file = filepath.Join(oldTaxdumpDir, "merged.dmp")
+			existed, err = pathutil.Exists(file)
+			if err != nil {
+				checkError(fmt.Errorf("err on checking file merged.dmp: %s", err))
+			}
+			if existed {
+				files.Merged = file
+			}
 
-			go func() {
-				defer wg.Done()
-				err = taxdb.LoadNamesFromNCBI(filepath.Join(oldTaxdumpDir, "names.dmp"))
-				if err != nil {
-					checkError(fmt.Errorf("err on loading Taxonomy names: %s", err))
-				}
-				log.Infof("  %d names loaded", len(taxdb.Names))
-			}()
-
-			go func() {
-				defer wg.Done()
-				file := filepath.Join(oldTaxdumpDir, "delnodes.dmp")
-				existed, err = pathutil.Exists(file)
-				if err != nil {
-					checkError(fmt.Errorf("err on checking file delnodes.dmp: %s", err))
-				}
-				if existed {
-					err = taxdb.LoadDeletedNodesFromNCBI(file)
-					if err != nil {
-						checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
-					}
-				}
-				log.Infof("  %d deleted nodes loaded", len(taxdb.DelNodes))
-			}()
-
-			go func() {
-				defer wg.Done()
-				file := filepath.Join(oldTaxdumpDir, "merged.dmp")
-				existed, err = pathutil.Exists(file)
-				if err != nil {
-					checkError(fmt.Errorf("err on checking file merged.dmp: %s", err))
-				}
-				if existed {
-					err = taxdb.LoadMergedNodesFromNCBI(file)
-					if err != nil {
-						checkError(fmt.Errorf("err on loading Taxonomy merged nodes: %s", err))
-					}
-				}
-				log.Infof("  %d merged nodes loaded", len(taxdb.MergeNodes))
-			}()
+			taxdb = taxonomy.Open(files)
+			err = taxdb.Load(taxonomy.PartNodes | taxonomy.PartRanks | taxonomy.PartDelNodes | taxonomy.PartMerged)
+			if err != nil {
+				checkError(fmt.Errorf("err on loading Taxonomy: %s", err))
+			}
 
-			wg.Wait()
+			log.Infof("  %d nodes in %d ranks loaded", taxdb.NumNodes(), len(taxdb.Ranks()))
+			log.Infof("  %d deleted nodes loaded", taxdb.NumDelNodes())
+			log.Infof("  %d merged nodes loaded", taxdb.NumMerged())
 			log.Info()
 		}
 
@@ -701,13 +678,14 @@ Attention:
 		var delnodes map[uint32]interface{}
 
 		if taxdb != nil {
+			oldNodes := taxdb.Nodes()
 			// --------------------- newly merged --------------------
-			merged = make(map[uint32]uint32, len(taxdb.MergeNodes))
+			merged = make(map[uint32]uint32, taxdb.NumMerged())
 			var _parent uint32
 			for child, parent := range tree {
-				if _parent, ok = taxdb.Nodes[child]; ok { // not new taxid
+				if _parent, ok = oldNodes[child]; ok { // not new taxid
 					if parent != _parent && // its parent changed
-						tree[parent] == taxdb.Nodes[_parent] { // while parents of the parents not changed
+						tree[parent] == oldNodes[_parent] { // while parents of the parents not changed
 						if _, ok = tree[_parent]; !ok { // and the old parent disappeared
 							// then the old parent is merged into the new parent
 
@@ -726,9 +704,9 @@ Attention:
 
 			// --------------------- newly deleted --------------------
 
-			delnodes = make(map[uint32]interface{}, len(taxdb.DelNodes))
+			delnodes = make(map[uint32]interface{}, taxdb.NumDelNodes())
 
-			for child := range taxdb.Nodes {
+			for child := range oldNodes {
 				if child == 1 {
 					continue
 				}
@@ -752,7 +730,7 @@ Attention:
 
 			// append old merged.dmp
 			var toNew uint32
-			for from, to := range taxdb.MergeNodes {
+			for from, to := range taxdb.MergedNodes() {
 				// previoulsly merged reads may be reused again, it happens both in GTDB and NCBI Taxonomy
 				if _, ok = tree[from]; ok {
 					// discard the old record
@@ -830,7 +808,7 @@ Attention:
 			}
 
 			// --------------------- append old delnodes.dmp ---------------------
-			for child := range taxdb.DelNodes {
+			for child := range taxdb.DelNodes() {
 				if _, ok = tree[child]; ok { // some deleted taxids may be reused
 					continue
 				}
@@ -875,6 +853,32 @@ Attention:
 
 		log.Infof("%d records saved to %s", len(merged), fileMerged)
 		log.Infof("%d records saved to %s", len(delnodes), fileDelNodes)
+
+		// ------------------------------- metadata -------------------------
+
+		sources := make([]string, len(files))
+		for i, file := range files {
+			if isStdin(file) {
+				sources[i] = "stdin"
+				continue
+			}
+			sources[i], err = filepath.Abs(file)
+			checkError(err)
+		}
+		now := time.Now()
+		meta := &DBMetadata{
+			Source:    strings.Join(sources, ","),
+			Date:      now.Format("2006-01-02"),
+			Installed: now.Truncate(time.Second),
+		}
+
+		// all the dump files should be completely written before computing the version ID
+		for _, outfh := range []*xopen.Writer{outfhNodes, outfhNames, outfhMerged, outfhDelNodes} {
+			checkError(outfh.Close())
+		}
+		checkError(setVersionID(meta, []string{fileNodes, fileNames, fileDelNodes, fileMerged}))
+		checkError(writeDBMetadata(outDir, meta))
+		log.Infof("metadata saved to %s", filepath.Join(outDir, dbMetadataFileName))
 	},
 }
 
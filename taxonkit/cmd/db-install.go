@@ -0,0 +1,591 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/util/pathutil"
+	"github.com/spf13/cobra"
+)
+
+// taxdumpMembers are the dump files extracted from a taxdump archive,
+// including these in new_taxdump.tar.gz.
+var taxdumpMembers = []string{
+	"nodes.dmp", "names.dmp", "delnodes.dmp", "merged.dmp",
+	"division.dmp", "gencode.dmp", "citations.dmp",
+	"rankedlineage.dmp", "fullnamelineage.dmp", "taxidlineage.dmp",
+	"host.dmp", "typematerial.dmp", "typeoftype.dmp", "excludedfromtype.dmp", "images.dmp",
+}
+
+const (
+	stagingDirName      = ".staging" // in the directory of versions
+	installLockFileName = ".taxonkit-install.lock"
+)
+
+var dbInstallCmd = &cobra.Command{
+	Use:   "install <taxdump archive>",
+	Short: "Install taxdump files from an archive, with md5 verification and rollback",
+	Long: `Install taxdump files from an archive, with md5 verification and rollback
+
+Steps:
+
+  1. Verifying the archive with the md5 file, given by --md5, or the
+     companion file "<archive>.md5" from NCBI if it exists.
+  2. Extracting dump files into a staging directory "versions/.staging/"
+     in the data directory.
+  3. Recording the source, date and version ID of the archive in
+     "taxdump-info.toml".
+  4. Checking structural problems of the new files (see "taxonkit check-taxdump").
+  5. Renaming the staging directory to "versions/<date>_<version ID>/".
+  6. Switching the pointer file "taxdump-current" to the new version, by
+     renaming a temporary file in one step. The previous version is named
+     in "taxdump-previous".
+  7. Removing other versions than the new and previous ones.
+
+Other commands read dump files of the version named in "taxdump-current",
+which is resolved once in a run, so commands running during the
+installation use either the previous version or the new one, not a mixture.
+Concurrent installations and rollbacks in the same data directory are
+prevented with a lock file ".taxonkit-install.lock", which is removed when
+they finish.
+
+Dump files placed directly in the data directory, e.g., copied manually,
+are moved into "versions/" as the previous version in the first installation.
+
+The data directory is the one used by other commands (--data-dir, etc.).
+With --name, files are installed into "~/.taxonkit/db/<name>/",
+and the database is registered for the global flag --db.
+
+The previous version can be restored with --rollback, which switches the
+two pointer files, and running it again switches back to the new version.
+
+Examples:
+
+    $ wget https://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz{,.md5}
+    $ taxonkit db install taxdump.tar.gz
+    $ taxonkit db install taxdump.tar.gz --name ncbi --source https://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz
+
+    $ taxonkit db install --rollback
+
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := getFlagString(cmd, "name")
+		md5File := getFlagString(cmd, "md5")
+		source := getFlagString(cmd, "source")
+		date := getFlagString(cmd, "date")
+		noCheck := getFlagBool(cmd, "no-check")
+		rollback := getFlagBool(cmd, "rollback")
+		verbose := getFlagBool(cmd, "verbose")
+
+		var dir string
+		var registry *DBRegistry
+		var err error
+		if name != "" {
+			checkError(checkDBName(name))
+			dir = managedDBDir(name)
+
+			registry, err = loadDBRegistry()
+			checkError(err)
+			if db, ok := registry.Databases[name]; ok && !db.Managed {
+				checkError(fmt.Errorf(`database %s (%s) is not installed by "taxonkit db install", please remove it first`, name, db.Path))
+			}
+		} else {
+			dir = getDataDir(cmd)
+			if taxonomy.IsArchive(dir) {
+				checkError(fmt.Errorf("taxdump files can not be installed into an archive file: %s", dir))
+			}
+		}
+		dir, err = filepath.Abs(dir)
+		checkError(err)
+
+		versionsDir := filepath.Join(dir, taxdumpVersionsDirName)
+		staging := filepath.Join(versionsDir, stagingDirName)
+
+		// ----------------------------------------------------------
+
+		if rollback {
+			if len(args) > 0 {
+				checkError(fmt.Errorf("no archive file needed for --rollback"))
+			}
+
+			unlock, err := lockDataDir(dir)
+			checkError(err)
+			// checkError exits without running deferred functions
+			checkLocked := func(err error) {
+				if err != nil {
+					unlock()
+					checkError(err)
+				}
+			}
+
+			current, err := readTaxdumpPointer(dir, currentPointerFileName)
+			checkLocked(err)
+			previous, err := readTaxdumpPointer(dir, previousPointerFileName)
+			checkLocked(err)
+			if previous == "" {
+				checkLocked(fmt.Errorf("no previous version found in %s", dir))
+			}
+			existed, err := pathutil.DirExists(taxdumpVersionDir(dir, previous))
+			checkLocked(err)
+			if !existed {
+				checkLocked(fmt.Errorf("previous version %s not found in %s", previous, versionsDir))
+			}
+
+			checkLocked(writeTaxdumpPointer(dir, currentPointerFileName, previous))
+			if current != "" {
+				checkLocked(writeTaxdumpPointer(dir, previousPointerFileName, current))
+			}
+
+			meta, err := readDBMetadata(taxdumpVersionDir(dir, previous))
+			checkLocked(err)
+			if meta != nil && registry != nil {
+				checkLocked(registerInstalled(registry, name, dir, meta))
+			}
+			unlock()
+			log.Infof("previous version %s restored in %s", previous, dir)
+			return
+		}
+
+		if len(args) == 0 {
+			checkError(fmt.Errorf("a taxdump archive file needed"))
+		}
+		file := args[0]
+		archive, err := taxonomy.NewArchive(file)
+		checkError(err)
+
+		file, err = filepath.Abs(file)
+		checkError(err)
+
+		// md5
+		if md5File == "" {
+			existed, err := pathutil.Exists(file + ".md5")
+			checkError(err)
+			if existed {
+				md5File = file + ".md5"
+			} else {
+				log.Warningf("md5 file not found, skip verification: %s.md5", file)
+			}
+		}
+		var md5sum string
+		if md5File != "" {
+			if verbose {
+				log.Infof("verifying %s with %s", file, md5File)
+			}
+			md5sum, err = verifyMD5(file, md5File)
+			checkError(err)
+			log.Infof("md5 verified: %s", md5sum)
+		}
+
+		// extract
+		checkError(os.MkdirAll(dir, 0777))
+		unlock, err := lockDataDir(dir)
+		checkError(err)
+		// checkError exits without running deferred functions
+		checkLocked := func(err error) {
+			if err != nil {
+				unlock()
+				checkError(err)
+			}
+		}
+		checkLocked(os.RemoveAll(staging))
+		checkLocked(os.MkdirAll(staging, 0777))
+		checkStaging := func(err error) {
+			if err != nil {
+				os.RemoveAll(staging)
+				checkLocked(err)
+			}
+		}
+
+		if verbose {
+			log.Infof("extracting dump files to %s", staging)
+		}
+		n, err := extractTaxdump(archive, staging)
+		checkStaging(err)
+		if verbose {
+			log.Infof("  %d files extracted", n)
+		}
+
+		// metadata
+		if date == "" {
+			info, err := os.Stat(file)
+			checkStaging(err)
+			date = info.ModTime().Format("2006-01-02")
+		}
+		if source == "" {
+			source = file
+		}
+		meta := &DBMetadata{
+			Source:    source,
+			Date:      date,
+			MD5:       md5sum,
+			Installed: time.Now().Truncate(time.Second),
+		}
+		stagingFiles := make([]string, len(versionFiles))
+		for i, name := range versionFiles {
+			stagingFiles[i] = filepath.Join(staging, name)
+		}
+		checkStaging(setVersionID(meta, stagingFiles))
+		checkStaging(writeDBMetadata(staging, meta))
+
+		// sanity check
+		if !noCheck {
+			if verbose {
+				log.Infof("checking new taxdump files")
+			}
+			files := taxonomy.Files{
+				Nodes: filepath.Join(staging, "nodes.dmp"),
+				Names: filepath.Join(staging, "names.dmp"),
+			}
+			for _, f := range []struct {
+				file *string
+				name string
+			}{{&files.DelNodes, "delnodes.dmp"}, {&files.Merged, "merged.dmp"}} {
+				existed, err := pathutil.Exists(filepath.Join(staging, f.name))
+				checkStaging(err)
+				if existed {
+					*f.file = filepath.Join(staging, f.name)
+				}
+			}
+			issues, err := taxonomy.Check(files)
+			checkStaging(err)
+			if len(issues) > 0 {
+				for i, issue := range issues {
+					if i == 10 {
+						log.Warningf("  ...")
+						break
+					}
+					log.Warningf("  %s", issue)
+				}
+				checkStaging(fmt.Errorf(`%d problems found in the new taxdump files, installation aborted. use --no-check to skip checking`, len(issues)))
+			}
+		}
+
+		// the version directory
+		version := meta.Date + "_" + meta.VersionID
+		existed, err := pathutil.DirExists(taxdumpVersionDir(dir, version))
+		checkStaging(err)
+		if existed { // the same files were installed before
+			if verbose {
+				log.Infof("version %s existed in %s", version, versionsDir)
+			}
+			checkLocked(os.RemoveAll(staging))
+		} else {
+			checkStaging(os.Rename(staging, taxdumpVersionDir(dir, version)))
+		}
+
+		// switch
+		current, err := readTaxdumpPointer(dir, currentPointerFileName)
+		checkLocked(err)
+		if current == version {
+			if registry != nil {
+				checkLocked(registerInstalled(registry, name, dir, meta))
+			}
+			unlock()
+			log.Infof("taxdump version %s is already in use in %s", version, dir)
+			return
+		}
+		if current != "" {
+			checkLocked(writeTaxdumpPointer(dir, previousPointerFileName, current))
+		}
+		checkLocked(writeTaxdumpPointer(dir, currentPointerFileName, version))
+		if current == "" {
+			// dump files placed directly in the data directory, which are not read any more.
+			if current, err = moveLooseTaxdump(dir); err != nil {
+				log.Warningf("failed to move dump files in %s into %s: %s", dir, versionsDir, err)
+			} else if current != "" {
+				checkLocked(writeTaxdumpPointer(dir, previousPointerFileName, current))
+			}
+		}
+
+		// old versions are only removed after the switch
+		if err = removeTaxdumpVersions(dir, version, current); err != nil {
+			log.Warningf("failed to remove old versions in %s: %s", versionsDir, err)
+		}
+
+		if registry != nil {
+			checkLocked(registerInstalled(registry, name, dir, meta))
+		}
+		unlock()
+
+		if current != "" {
+			log.Infof("taxdump version %s installed in %s, the previous version %s can be restored with --rollback", version, dir, current)
+		} else {
+			log.Infof("taxdump version %s installed in %s", version, dir)
+		}
+	},
+}
+
+// registerInstalled adds or updates an installed database in the registry.
+func registerInstalled(registry *DBRegistry, name, dir string, meta *DBMetadata) error {
+	db, ok := registry.Databases[name]
+	if !ok {
+		db = &Database{Added: time.Now().Truncate(time.Second)}
+		registry.Databases[name] = db
+	}
+	db.Path = dir
+	db.Source = meta.Source
+	db.Date = meta.Date
+	db.Managed = true
+	return registry.Save()
+}
+
+var reMD5 = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// verifyMD5 checks the md5 of a file with the md5 file in the format of md5sum,
+// returning the md5 hex string.
+func verifyMD5(file, md5File string) (string, error) {
+	data, err := os.ReadFile(md5File)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || !reMD5.MatchString(fields[0]) {
+		return "", fmt.Errorf("invalid md5 file: %s", md5File)
+	}
+	expected := strings.ToLower(fields[0])
+
+	fh, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	h := md5.New()
+	if _, err = io.Copy(h, bufio.NewReaderSize(fh, 1<<20)); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if sum != expected {
+		return "", fmt.Errorf("md5 mismatch for %s: %s expected, %s computed", file, expected, sum)
+	}
+	return sum, nil
+}
+
+// extractTaxdump extracts dump files in the archive into a directory,
+// nodes.dmp and names.dmp are required.
+func extractTaxdump(archive *taxonomy.Archive, dir string) (int, error) {
+	readers := archive.OpenMembers(taxdumpMembers...)
+
+	errs := make([]error, len(readers))
+	found := make([]bool, len(readers))
+	var wg sync.WaitGroup
+	for i, r := range readers {
+		wg.Add(1)
+		go func(i int, r io.ReadCloser) {
+			defer wg.Done()
+			defer r.Close()
+
+			file := filepath.Join(dir, taxdumpMembers[i])
+			fh, err := os.Create(file)
+			if err != nil {
+				errs[i] = err
+				io.Copy(io.Discard, r)
+				return
+			}
+			_, err = io.Copy(fh, r)
+			fh.Close()
+			if errors.Is(err, fs.ErrNotExist) {
+				os.Remove(file)
+				return
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %s", taxdumpMembers[i], err)
+				return
+			}
+			found[i] = true
+		}(i, r)
+	}
+	wg.Wait()
+
+	var n int
+	for i, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+		if found[i] {
+			n++
+		} else if i < 2 {
+			return 0, fmt.Errorf("%s not found in %s", taxdumpMembers[i], archive.File())
+		}
+	}
+	return n, nil
+}
+
+// lockDataDir creates a lock file in a data directory, to prevent concurrent
+// installations and rollbacks. It returns a function for removing the lock file.
+func lockDataDir(dir string) (func(), error) {
+	file := filepath.Join(dir, installLockFileName)
+	fh, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("another installation or rollback is running in %s, if not, please remove the lock file: %s", dir, file)
+		}
+		return nil, err
+	}
+	fmt.Fprintf(fh, "pid: %d\nstarted: %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	if err = fh.Close(); err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+	return func() { os.Remove(file) }, nil
+}
+
+// taxdumpFiles returns names of files of a taxdump version in a directory,
+// i.e., dump files, the index file and the metadata file.
+func taxdumpFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if strings.HasSuffix(e.Name(), ".dmp") || e.Name() == taxonomy.IndexFileName || e.Name() == dbMetadataFileName {
+			files = append(files, e.Name())
+		}
+	}
+	return files, nil
+}
+
+// moveLooseTaxdump moves taxdump files placed directly in a data directory into
+// a version directory, and returns the version. An empty string is returned
+// if there's no nodes.dmp. Moved files are restored if any step fails.
+func moveLooseTaxdump(dir string) (version string, err error) {
+	files, err := taxdumpFiles(dir)
+	if err != nil || !slices.Contains(files, "nodes.dmp") {
+		return "", err
+	}
+
+	meta, err := readDBMetadata(dir)
+	if err != nil {
+		return "", err
+	}
+	if meta == nil {
+		meta = &DBMetadata{}
+	}
+	paths := make([]string, len(versionFiles))
+	for i, name := range versionFiles {
+		paths[i] = filepath.Join(dir, name)
+	}
+	stamps, err := stampVersionFiles(paths)
+	if err != nil {
+		return "", err
+	}
+	if meta.VersionID == "" || !slices.Equal(meta.VersionFiles, stamps) {
+		if err = setVersionID(meta, paths); err != nil {
+			return "", err
+		}
+	}
+	date := meta.Date
+	if date == "" {
+		var latest int64
+		for _, s := range stamps {
+			if s.ModTime > latest {
+				latest = s.ModTime
+			}
+		}
+		date = time.Unix(0, latest).Format("2006-01-02")
+	}
+	version = date + "_" + meta.VersionID
+
+	target := taxdumpVersionDir(dir, version)
+	existed, err := pathutil.DirExists(target)
+	if err != nil {
+		return "", err
+	}
+	if existed { // the same files were installed before, they are left in place
+		return version, nil
+	}
+	if err = os.MkdirAll(target, 0777); err != nil {
+		return "", err
+	}
+
+	moved := make([]string, 0, len(files))
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, file := range moved {
+			if e := os.Rename(filepath.Join(target, file), filepath.Join(dir, file)); e != nil {
+				log.Warningf("failed to restore %s: %s", filepath.Join(dir, file), e)
+			}
+		}
+		os.RemoveAll(target)
+	}()
+	for _, file := range files {
+		if err = os.Rename(filepath.Join(dir, file), filepath.Join(target, file)); err != nil {
+			return "", err
+		}
+		moved = append(moved, file)
+	}
+	if err = writeDBMetadata(target, meta); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// removeTaxdumpVersions removes versions in a data directory, except these to keep.
+func removeTaxdumpVersions(dir string, keep ...string) error {
+	entries, err := os.ReadDir(filepath.Join(dir, taxdumpVersionsDirName))
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || slices.Contains(keep, e.Name()) {
+			continue
+		}
+		if err = os.RemoveAll(taxdumpVersionDir(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	dbCmd.AddCommand(dbInstallCmd)
+
+	dbInstallCmd.Flags().StringP("name", "n", "", `install as a named database in "~/.taxonkit/db/<name>/", which can be used with --db`)
+	dbInstallCmd.Flags().StringP("md5", "m", "", `md5 file of the archive (default: "<archive>.md5" if it exists)`)
+	dbInstallCmd.Flags().StringP("source", "s", "", "source of the archive, e.g., a URL (default: the path)")
+	dbInstallCmd.Flags().StringP("date", "d", "", "release date of the archive (default: the modification date of the archive)")
+	dbInstallCmd.Flags().BoolP("no-check", "", false, "do not check structural problems of the new taxdump files")
+	dbInstallCmd.Flags().BoolP("rollback", "", false, "restore the previous version")
+}
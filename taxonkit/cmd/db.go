@@ -0,0 +1,475 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// dbCmd represents the db command
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage named taxonomy databases",
+	Long: `Manage named taxonomy databases
+
+Databases, e.g., NCBI, GTDB and ICTV taxdump files created by
+"taxonkit create-taxdump", can be registered with names, and be used
+by all commands via the global flag --db, instead of --data-dir.
+
+The registry is saved in "~/.taxonkit/databases.toml", and files of
+databases added with --copy are stored in "~/.taxonkit/db/<name>/".
+
+Names of databases in the config file (taxonkit --help) are also
+accepted by --db, but they can not be changed with these commands.
+
+Examples:
+
+    $ taxonkit db add ncbi ~/.taxonkit/ --source https://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz
+    $ taxonkit db add gtdb gtdb-taxdump/R220/ --copy --source https://github.com/shenwei356/gtdb-taxdump
+    $ taxonkit db add ictv ictv-taxdump.tar.gz --date 2024-01-01
+
+    # install taxdump files from an archive, see "taxonkit db install -h"
+    $ taxonkit db install taxdump.tar.gz --md5 taxdump.tar.gz.md5
+
+    $ taxonkit db list
+    $ taxonkit db default gtdb
+    $ taxonkit db info gtdb
+
+    $ echo 562 | taxonkit lineage --db ncbi
+
+`,
+}
+
+var dbAddCmd = &cobra.Command{
+	Use:   "add <name> <data directory or taxdump archive>",
+	Short: "Register a database",
+	Long: `Register a database
+
+The data directory should contain nodes.dmp and names.dmp, and a taxdump
+archive file (.tar.gz, .tgz or .zip) is also accepted.
+
+By default, only the path is recorded. Use --copy to copy the dump files
+(or the archive file) into "~/.taxonkit/db/<name>/".
+
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, path := args[0], args[1]
+		checkError(checkDBName(name))
+
+		source := getFlagString(cmd, "source")
+		date := getFlagString(cmd, "date")
+		description := getFlagString(cmd, "description")
+		copyFiles := getFlagBool(cmd, "copy")
+		force := getFlagBool(cmd, "force")
+
+		registry, err := loadDBRegistry()
+		checkError(err)
+		if db, ok := registry.Databases[name]; ok && !force {
+			checkError(fmt.Errorf("database existed: %s (%s), use --force to replace it", name, db.Path))
+		}
+
+		path, err = filepath.Abs(path)
+		checkError(err)
+
+		files, err := dbFiles(path)
+		checkError(err)
+
+		if date == "" {
+			info, err := os.Stat(files[0])
+			checkError(err)
+			date = info.ModTime().Format("2006-01-02")
+		}
+		if source == "" {
+			source = path
+		}
+
+		db := &Database{
+			Path:        path,
+			Source:      source,
+			Date:        date,
+			Added:       time.Now().Truncate(time.Second),
+			Description: description,
+		}
+
+		if copyFiles {
+			dir := managedDBDir(name)
+			if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				checkError(fmt.Errorf("files of database %s are already in %s", name, dir))
+			}
+			checkError(os.RemoveAll(dir))
+			checkError(os.MkdirAll(dir, 0777))
+			for _, file := range files {
+				checkError(copyFile(file, filepath.Join(dir, filepath.Base(file))))
+			}
+			if taxonomy.IsArchive(path) {
+				db.Path = filepath.Join(dir, filepath.Base(path))
+			} else {
+				db.Path = dir
+			}
+			db.Managed = true
+			log.Infof("%d files copied to %s", len(files), dir)
+		}
+
+		registry.Databases[name] = db
+		checkError(registry.Save())
+
+		log.Infof("database added: %s (%s)", name, db.Path)
+	},
+}
+
+var dbListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List databases",
+	Long: `List databases
+
+Output (tab-delimited):
+
+    1. name, "*" is appended for the default database
+    2. release date
+    3. source
+    4. path
+
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		registry, err := loadDBRegistry()
+		checkError(err)
+
+		outfh, err := xopen.Wopen(getFlagString(cmd, "out-file"))
+		checkError(err)
+		defer outfh.Close()
+
+		var name string
+		for _, n := range registry.Names() {
+			db := registry.Databases[n]
+			name = n
+			if n == registry.Default {
+				name += "*"
+			}
+			fmt.Fprintf(outfh, "%s\t%s\t%s\t%s\n", name, db.Date, db.Source, db.Path)
+		}
+
+		names := make([]string, 0, len(userConfig.Databases))
+		for n := range userConfig.Databases {
+			if _, ok := registry.Databases[n]; ok { // the registry takes precedence
+				continue
+			}
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(outfh, "%s\t\t%s\t%s\n", n, "config file", userConfig.Databases[n])
+		}
+	},
+}
+
+var dbRemoveCmd = &cobra.Command{
+	Use:   "remove <name>...",
+	Short: "Unregister databases",
+	Long: `Unregister databases
+
+Files of databases added with --copy are kept unless --delete-files is given,
+while files of other databases are never deleted.
+
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		deleteFiles := getFlagBool(cmd, "delete-files")
+
+		registry, err := loadDBRegistry()
+		checkError(err)
+
+		for _, name := range args {
+			if _, ok := registry.Databases[name]; !ok {
+				checkError(fmt.Errorf("database not found in registry: %s", name))
+			}
+		}
+
+		for _, name := range args {
+			db := registry.Databases[name]
+			delete(registry.Databases, name)
+			if registry.Default == name {
+				registry.Default = ""
+				log.Warningf("the default database is removed: %s", name)
+			}
+			checkError(registry.Save())
+			log.Infof("database removed: %s", name)
+
+			if !db.Managed {
+				continue
+			}
+			dir := managedDBDir(name)
+			if deleteFiles {
+				checkError(os.RemoveAll(dir))
+				log.Infof("  files deleted: %s", dir)
+			} else {
+				log.Infof("  files kept: %s", dir)
+			}
+		}
+	},
+}
+
+var dbDefaultCmd = &cobra.Command{
+	Use:   "default [name]",
+	Short: "Show or set the default database",
+	Long: `Show or set the default database
+
+The default database is used when none of --db, --data-dir, the environment
+variable TAXONKIT_DB and data-dir/db in the config file is given.
+
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		unset := getFlagBool(cmd, "unset")
+
+		registry, err := loadDBRegistry()
+		checkError(err)
+
+		if unset {
+			registry.Default = ""
+			checkError(registry.Save())
+			log.Infof("default database unset, %s will be used", defaulDataDir)
+			return
+		}
+
+		if len(args) == 0 {
+			if registry.Default == "" {
+				log.Infof("no default database, %s is used", defaulDataDir)
+				return
+			}
+			fmt.Println(registry.Default)
+			return
+		}
+
+		name := args[0]
+		if _, ok := registry.Databases[name]; !ok {
+			checkError(fmt.Errorf("database not found in registry: %s", name))
+		}
+		registry.Default = name
+		checkError(registry.Save())
+		log.Infof("default database: %s", name)
+	},
+}
+
+var dbInfoCmd = &cobra.Command{
+	Use:   "info [name]...",
+	Short: "Show statistics of databases",
+	Long: `Show statistics of databases
+
+Statistics of the current database (--db, --data-dir, etc.) are shown
+if no names are given.
+
+Output (tab-delimited):
+
+    1. key, including name, path, source, date, nodes, names (scientific names),
+       all-names (names of all name classes), merged, deleted, and ranks
+    2. value
+    3. (for ranks) the number of nodes of each rank
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		registry, err := loadDBRegistry()
+		checkError(err)
+
+		outfh, err := xopen.Wopen(getFlagString(cmd, "out-file"))
+		checkError(err)
+		defer outfh.Close()
+
+		if len(args) == 0 {
+			config := getConfigs(cmd)
+			name := ""
+			for _, n := range registry.Names() {
+				if registry.Databases[n].Path == config.DataDir {
+					name = n
+					break
+				}
+			}
+			writeDBInfo(outfh, config, name, registry.Databases[name])
+			return
+		}
+
+		for i, name := range args {
+			db, ok := registry.Databases[name]
+			if !ok {
+				checkError(fmt.Errorf("database not found in registry: %s", name))
+			}
+			if i > 0 {
+				fmt.Fprintln(outfh)
+			}
+			writeDBInfo(outfh, getConfigsOfDataDir(cmd, db.Path), name, db)
+		}
+	},
+}
+
+// writeDBInfo writes statistics of a database, db can be nil for unregistered ones.
+func writeDBInfo(outfh *xopen.Writer, config Config, name string, db *Database) {
+	taxdb := loadTaxonomy(&config, taxonomy.PartNodes|taxonomy.PartRanks|taxonomy.PartNames|
+		taxonomy.PartAllNames|taxonomy.PartDelNodes|taxonomy.PartMerged)
+
+	var source, date string
+	if db != nil {
+		source, date = db.Source, db.Date
+	} else if config.Archive == "" {
+		meta, err := readDBMetadata(config.TaxdumpDir)
+		checkError(err)
+		if meta != nil {
+			source, date = meta.Source, meta.Date
+		}
+	}
+
+	fmt.Fprintf(outfh, "name\t%s\n", name)
+	fmt.Fprintf(outfh, "path\t%s\n", config.DataDir)
+	fmt.Fprintf(outfh, "source\t%s\n", source)
+	fmt.Fprintf(outfh, "date\t%s\n", date)
+	fmt.Fprintf(outfh, "nodes\t%d\n", taxdb.NumNodes())
+	fmt.Fprintf(outfh, "names\t%d\n", taxdb.NumNames())
+	fmt.Fprintf(outfh, "all-names\t%d\n", taxdb.NumAllNames())
+	fmt.Fprintf(outfh, "merged\t%d\n", taxdb.NumMerged())
+	fmt.Fprintf(outfh, "deleted\t%d\n", taxdb.NumDelNodes())
+
+	ranks, counts := countRanks(taxdb, taxdb.TaxIds())
+	fmt.Fprintf(outfh, "ranks\t%d\n", len(ranks))
+	for _, rank := range ranks {
+		fmt.Fprintf(outfh, "ranks\t%s\t%d\n", rank, counts[rank])
+	}
+}
+
+// countRanks counts nodes of each rank,
+// ranks are sorted by the counts in descending order.
+func countRanks(taxdb *taxonomy.Taxonomy, taxids []uint32) ([]string, map[string]int) {
+	counts := make(map[string]int, len(taxdb.Ranks()))
+	for _, taxid := range taxids {
+		counts[taxdb.Rank(taxid)]++
+	}
+	ranks := make([]string, 0, len(counts))
+	for rank := range counts {
+		ranks = append(ranks, rank)
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		if counts[ranks[i]] == counts[ranks[j]] {
+			return ranks[i] < ranks[j]
+		}
+		return counts[ranks[i]] > counts[ranks[j]]
+	})
+	return ranks, counts
+}
+
+// dbFiles returns the files of a database to be copied, the first one is nodes.dmp
+// or the archive file.
+func dbFiles(path string) ([]string, error) {
+	existed, err := pathutil.Exists(path)
+	if err != nil {
+		return nil, err
+	}
+	if !existed {
+		return nil, fmt.Errorf("path not found: %s", path)
+	}
+
+	if taxonomy.IsArchive(path) {
+		return []string{path}, nil
+	}
+
+	isDir, err := pathutil.IsDir(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isDir {
+		return nil, fmt.Errorf("a data directory or a taxdump archive file expected: %s", path)
+	}
+	// the version in use of taxdump files installed by "taxonkit db install"
+	if path, err = currentTaxdumpDir(path); err != nil {
+		return nil, err
+	}
+
+	for _, file := range []string{"nodes.dmp", "names.dmp"} {
+		existed, err = pathutil.Exists(filepath.Join(path, file))
+		if err != nil {
+			return nil, err
+		}
+		if !existed {
+			return nil, fmt.Errorf("%s not found in %s", file, path)
+		}
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{filepath.Join(path, "nodes.dmp")}
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == "nodes.dmp" || !strings.HasSuffix(e.Name(), ".dmp") {
+			continue
+		}
+		files = append(files, filepath.Join(path, e.Name()))
+	}
+	return files, nil
+}
+
+// copyFile copies a file, the destination is overwritten.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func init() {
+	RootCmd.AddCommand(dbCmd)
+
+	dbCmd.AddCommand(dbAddCmd)
+	dbAddCmd.Flags().StringP("source", "s", "", "source of the database, e.g., a URL (default: the path)")
+	dbAddCmd.Flags().StringP("date", "d", "", "release date of the database (default: the modification date of nodes.dmp or the archive)")
+	dbAddCmd.Flags().StringP("description", "", "", "description of the database")
+	dbAddCmd.Flags().BoolP("copy", "c", false, `copy the dump files or the archive file into "~/.taxonkit/db/<name>/"`)
+	dbAddCmd.Flags().BoolP("force", "f", false, "replace the existing database with the same name")
+
+	dbCmd.AddCommand(dbListCmd)
+
+	dbCmd.AddCommand(dbRemoveCmd)
+	dbRemoveCmd.Flags().BoolP("delete-files", "", false, `delete files of databases added with --copy`)
+
+	dbCmd.AddCommand(dbDefaultCmd)
+	dbDefaultCmd.Flags().BoolP("unset", "", false, "unset the default database")
+
+	dbCmd.AddCommand(dbInfoCmd)
+}
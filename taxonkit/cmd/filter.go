@@ -27,6 +27,7 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/stringutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -59,6 +60,22 @@ Attention:
     -n/--save-predictable-norank to save some special ranks without order,
     where rank of the closest higher node is still lower than rank cutoff.
 
+  7. TaxIds can also be filtered by type materials (--with-type-material)
+     and potential hosts (--with-host), provided by "typematerial.dmp" and
+     "host.dmp" of new_taxdump (https://ftp.ncbi.nlm.nih.gov/pub/taxonomy/new_taxdump/).
+     They can be used alone or along with rank filters. E.g., species with
+     type strains:
+
+       taxonkit filter -E species --with-type-material --type-material-type "type strain" taxids.txt
+
+  8. With --header (no shorthand, as -H is used by --higher-than), the first
+     line is treated as a header line and passed through, and the TaxId field
+     can be given by the column name, e.g., --header -i taxid.
+
+  9. TaxIds in free text, e.g., "taxid=562" in FASTA headers, can be extracted
+     with a regular expression via --taxid-regex, from the field (-i/--taxid-field)
+     or the whole line (-i 0). A line passes if any of the matched TaxIds passes.
+
 Rank file:
 
   1. Blank lines or lines starting with "#" are ignored.
@@ -101,10 +118,20 @@ Rank file:
 
 		trimChar := getFlagString(cmd, "trim")
 		delimiter := getFlagString(cmd, "delimiter")
-		field := getFlagPositiveInt(cmd, "taxid-field") - 1
+		reTaxId := getTaxIdRegex(cmd)
+		fieldFlag := getFlagField(cmd, "taxid-field", reTaxId != nil)
+		field := fieldFlag.index
+		hasHeader := getFlagBool(cmd, "header")
 
 		keep := getFlagBool(cmd, "keep")
 
+		withTypeMaterial := getFlagBool(cmd, "with-type-material")
+		typeMaterialTypes := getFlagStringSlice(cmd, "type-material-type")
+		withHosts := getFlagStringSlice(cmd, "with-host")
+		if len(typeMaterialTypes) > 0 && !withTypeMaterial {
+			checkError(fmt.Errorf("flag --type-material-type only works along with --with-type-material"))
+		}
+
 		if higher != "" && lower != "" {
 			checkError(fmt.Errorf("-H/--higher-than and -L/--lower-than can't be simultaneous given"))
 		}
@@ -137,7 +164,7 @@ Rank file:
 			for r, o := range rankOrder {
 				orders = append(orders, stringutil.StringCount{Key: r, Count: o})
 			}
-			sorts.Quicksort(stringutil.ReversedStringCountList{orders})
+			sorts.Quicksort(stringutil.ReversedStringCountList{StringCountList: orders})
 			preOrder := -1
 			for _, order := range orders {
 				// fmt.Printf("%d\t%s\n", order.Count, order.Key)
@@ -155,13 +182,31 @@ Rank file:
 			return
 		}
 
-		taxondb := loadTaxonomy(&config, true)
+		parts := taxonomy.PartNodes | taxonomy.PartDelNodes | taxonomy.PartMerged | taxonomy.PartRanks
+		if withTypeMaterial {
+			parts |= taxonomy.PartTypeMaterial
+		}
+		if len(withHosts) > 0 {
+			parts |= taxonomy.PartHosts
+		}
+		taxondb := loadTaxonomy(&config, parts)
+		if withTypeMaterial {
+			checkNewTaxdumpPart(taxondb, taxonomy.PartTypeMaterial, "typematerial.dmp")
+		}
+		if len(withHosts) > 0 {
+			checkNewTaxdumpPart(taxondb, taxonomy.PartHosts, "host.dmp")
+		}
+		typeMaterials := typeMaterialFunc(taxondb, typeMaterialTypes)
+		hostSet := make(map[string]interface{}, len(withHosts))
+		for _, h := range withHosts {
+			hostSet[strings.ToLower(h)] = struct{}{}
+		}
 
 		if config.Verbose {
 			log.Infof("checking defined taxonomic rank order")
 		}
 		notDefined := make([]string, 0, 10)
-		for rank := range taxondb.Ranks {
+		for rank := range taxondb.Ranks() {
 			if _, ok := rankOrder[rank]; !ok {
 				if _, ok := noRanks[rank]; !ok {
 					notDefined = append(notDefined, rank)
@@ -176,9 +221,9 @@ Rank file:
 		}
 
 		if listRanks {
-			orders := make([]stringutil.StringCount, 0, len(taxondb.Ranks))
+			orders := make([]stringutil.StringCount, 0, len(taxondb.Ranks()))
 			var ok bool
-			for rank := range taxondb.Ranks {
+			for rank := range taxondb.Ranks() {
 				if _, ok = rankOrder[rank]; !ok {
 					if _, ok := noRanks[rank]; !ok {
 						checkError(fmt.Errorf("rank order not defined: %s", rank))
@@ -186,7 +231,7 @@ Rank file:
 				}
 				orders = append(orders, stringutil.StringCount{Key: rank, Count: rankOrder[rank]})
 			}
-			sorts.Quicksort(stringutil.ReversedStringCountList{orders})
+			sorts.Quicksort(stringutil.ReversedStringCountList{StringCountList: orders})
 			for _, order := range orders {
 				// fmt.Printf("%d\t%s\n", order.Count, order.Key)
 				fmt.Printf("%s\n", order.Key)
@@ -215,56 +260,107 @@ Rank file:
 			}
 		}
 
-		filter, err := newRankFilter(taxondb, rankOrder, noRanks, lower, higher, equals, blackListRanks, discardNoRank, saveNorank)
+		filter, err := taxonomy.NewRankFilter(taxondb, rankOrder, noRanks, lower, higher, equals, blackListRanks, discardNoRank, saveNorank)
 		checkError(err)
 
 		outfh, err := xopen.Wopen(config.OutFile)
 		checkError(err)
 		defer outfh.Close()
 
+		rw := newRecordWriter(outfh, config)
+
+		// passed checks a TaxId, and returns the TaxId to use, which is the new one for a merged TaxId
+		passed := func(taxid uint32) (uint32, bool) {
+			if discardRoot && taxid == rootTaxid {
+				return taxid, false
+			}
+
+			// records of kept merged, deleted or unknown TaxIds never pass
+			taxid, _, action := config.TaxIdPolicy.Resolve(taxondb, taxid)
+			if action != actionUse {
+				return taxid, false
+			}
+
+			pass, err := filter.IsPassed(taxid)
+			if err != nil {
+				checkError(err)
+			}
+
+			if !pass {
+				return taxid, false
+			}
+
+			if withTypeMaterial && len(typeMaterials(taxid)) == 0 {
+				return taxid, false
+			}
+
+			if len(hostSet) > 0 && !hasHost(taxondb.Hosts(taxid), hostSet) {
+				return taxid, false
+			}
+
+			return taxid, true
+		}
+
+		var headerWritten bool
 		for _, file := range files {
 			fh, err := xopen.Ropen(file)
 			checkError(err)
 
-			var line0, line string
+			var line0, line, text string
 			var items []string
 
 			scanner := bufio.NewScanner(fh)
 			var _taxid int
 			var taxid uint32
 			var pass bool
-			for scanner.Scan() {
+
+			if hasHeader && scanner.Scan() {
 				line0 = strings.Trim(scanner.Text(), "\r\n")
 				line = strings.Trim(line0, trimChar)
-				if line == "" {
-					continue
-				}
-
-				items = strings.Split(line, delimiter)
-				if len(items) <= field {
-					field = len(items) - 1
+				field = fieldFlag.Resolve(strings.Split(line, delimiter))
+				if !headerWritten {
+					if keep {
+						line = line0
+					}
+					rw.WriteHeader(line)
+					headerWritten = true
 				}
+			}
 
-				if items[field] == "" {
+			for scanner.Scan() {
+				line0 = strings.Trim(scanner.Text(), "\r\n")
+				line = strings.Trim(line0, trimChar)
+				if line == "" {
 					continue
 				}
 
-				_taxid, err = strconv.Atoi(items[field])
-				if err != nil {
-					continue
+				if field < 0 { // the whole line, only for --taxid-regex
+					text = line
+				} else {
+					items = strings.Split(line, delimiter)
+					if len(items) <= field {
+						field = len(items) - 1
+					}
+					text = items[field]
 				}
 
-				taxid = uint32(_taxid)
-
-				// ----------------------------------
-
-				if discardRoot && taxid == rootTaxid {
+				if text == "" {
 					continue
 				}
 
-				pass, err = filter.isPassed(taxid)
-				if err != nil {
-					checkError(err)
+				if reTaxId != nil { // a line passes if any of the TaxIds passes
+					pass = false
+					for _, id := range extractTaxIds(reTaxId, text) {
+						if taxid, pass = passed(id); pass {
+							break
+						}
+					}
+				} else {
+					_taxid, err = strconv.Atoi(text)
+					if err != nil {
+						continue
+					}
+					taxid, pass = passed(uint32(_taxid))
 				}
 
 				if !pass {
@@ -272,10 +368,13 @@ Rank file:
 				}
 
 				if keep {
-					outfh.WriteString(line0 + "\n")
-				} else {
-					outfh.WriteString(line + "\n")
+					line = line0
 				}
+				rw.Write(
+					outField{Value: outInput(line)},
+					outField{Key: "taxid", Value: taxid, JSONOnly: true},
+					outField{Key: "rank", Value: taxondb.Rank(taxid), JSONOnly: true},
+				)
 			}
 			if err := scanner.Err(); err != nil {
 				checkError(err)
@@ -307,7 +406,25 @@ func init() {
 
 	filterCmd.Flags().StringP("trim", "t", " ", "trim characters in the input before parsing the taxids")
 	filterCmd.Flags().StringP("delimiter", "d", "\t", "delimiting character of the input after triming characters from --trim")
-	filterCmd.Flags().IntP("taxid-field", "i", 1, "field index of taxid. input data should be tab-separated")
+	filterCmd.Flags().StringP("taxid-regex", "", "", `regular expression with a capture group for extracting TaxIds from the field (-i/--taxid-field), `+
+		`or the whole line with "-i 0", e.g., "taxid=(\d+)". a line passes if any of the matched TaxIds passes`)
+	filterCmd.Flags().StringP("taxid-field", "i", "1", "field index of taxid, or the column name with --header. input data should be tab-separated")
 
 	filterCmd.Flags().BoolP("keep", "k", false, `retain trimmed input characters in the output`)
+
+	filterCmd.Flags().BoolP("with-type-material", "", false, `only output TaxIds with type materials, provided by "typematerial.dmp" of new_taxdump`)
+	filterCmd.Flags().StringSliceP("type-material-type", "", []string{}, `only consider type materials of these types (case ignored), e.g., "type strain", "neotype"`)
+	filterCmd.Flags().StringSliceP("with-host", "", []string{}, `only output TaxIds with any of these potential hosts (case ignored), e.g., "human", "vertebrates", provided by "host.dmp" of new_taxdump`)
+
+	addHeaderFlag(filterCmd)
+}
+
+// hasHost checks if any of the hosts is in the host set.
+func hasHost(hosts []string, hostSet map[string]interface{}) bool {
+	for _, h := range hosts {
+		if _, ok := hostSet[strings.ToLower(h)]; ok {
+			return true
+		}
+	}
+	return false
 }
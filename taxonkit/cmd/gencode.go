@@ -0,0 +1,217 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// gencodeCmd represents the gencode command
+var gencodeCmd = &cobra.Command{
+	Use:   "gencode",
+	Short: "Query division and genetic codes of given TaxIds",
+	Long: `Query division and genetic codes of given TaxIds
+
+Division and genetic code ids are read from nodes.dmp. Codes flagged
+as inherited are taken from the nearest ancestor which specifies them.
+Division names and genetic code names are read from division.dmp and
+gencode.dmp in the data directory, if they exist.
+
+Input:
+
+  - List of TaxIds, one TaxId per line.
+  - Or tab-delimited format, please specify TaxId field 
+    with flag -i/--taxid-field (default 1).
+  - With -H/--header, the first line is treated as a header line, which is
+    passed through with names of new columns appended, and the TaxId field
+    can be given by the column name, e.g., -H -i taxid.
+  - Supporting (gzipped) file or STDIN.
+
+Output:
+
+  1. Input line data.
+  2. Division id.
+  3. Division name.
+  4. Genetic code id.
+  5. Mitochondrial genetic code id.
+  6. (Optional) Genetic code name (-n/--show-code-names).
+  7. (Optional) Mitochondrial genetic code name (-n/--show-code-names).
+
+  By default, merged TaxIds are replaced with the new ones, and columns are
+  empty for deleted or unknown TaxIds, see --on-merged, --on-deleted and
+  --on-unknown.
+
+Examples:
+
+    $ echo 9606 | taxonkit gencode
+    9606    5       Primates        1       2
+
+    $ echo 9606 | taxonkit gencode -n
+    9606    5       Primates        1       2       Standard        Vertebrate Mitochondrial
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		fieldFlag := getFlagField(cmd, "taxid-field", false)
+		field := fieldFlag.index
+		hasHeader := getFlagBool(cmd, "header")
+		showCodeNames := getFlagBool(cmd, "show-code-names")
+
+		files := getFileList(args)
+
+		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
+			checkError(fmt.Errorf("stdin not detected"))
+		}
+
+		taxdb := loadTaxonomy(&config, taxonomy.PartNodes|taxonomy.PartDelNodes|taxonomy.PartMerged|taxonomy.PartGenCodes)
+
+		if _, ok := taxdb.Division(0); !ok {
+			log.Warningf("division.dmp not found in %s, division names will be empty", config.DataDir)
+		}
+		if _, ok := taxdb.GeneticCode(1); !ok && showCodeNames {
+			log.Warningf("gencode.dmp not found in %s, genetic code names will be empty", config.DataDir)
+		}
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		type taxid2codes struct {
+			line  string
+			codes taxonomy.Codes
+			ok    bool
+		}
+
+		fn := func(line string) (interface{}, bool, error) {
+			line = strings.Trim(line, "\r\n ")
+			if line == "" {
+				return nil, false, nil
+			}
+
+			data := strings.Split(line, "\t")
+			if len(data) <= field {
+				field = len(data) - 1
+			}
+
+			id, e := strconv.Atoi(data[field])
+			if e != nil {
+				return taxid2codes{line: line}, true, nil
+			}
+
+			taxid, _, action := config.TaxIdPolicy.Resolve(taxdb, uint32(id))
+			switch action {
+			case actionDrop:
+				return nil, false, nil
+			case actionKeep:
+				return taxid2codes{line: line}, true, nil
+			}
+
+			codes, ok := taxdb.GenCodes(taxid)
+			return taxid2codes{line, codes, ok}, true, nil
+		}
+
+		rw := newRecordWriter(outfh, config)
+
+		var t2c taxid2codes
+		var division taxonomy.Division
+		var gc, mgc taxonomy.GeneticCode
+		fields := make([]outField, 0, 7)
+		columns := []string{"division_id", "division", "gencode_id", "mito_gencode_id"}
+		if showCodeNames {
+			columns = append(columns, "gencode", "mito_gencode")
+		}
+
+		var headerWritten bool
+		for _, file := range files {
+			chunks, err := readLines(file, hasHeader, func(header string) {
+				field = fieldFlag.Resolve(strings.Split(header, "\t"))
+				if !headerWritten && header != "" {
+					rw.WriteHeader(header, columns...)
+					headerWritten = true
+				}
+			}, config.Threads, 10, fn)
+			checkError(err)
+
+			for chunk := range chunks {
+				checkError(chunk.Err)
+
+				for _, data := range chunk.Data {
+					t2c = data.(taxid2codes)
+
+					fields = fields[:0]
+					fields = append(fields, outField{Value: outInput(t2c.line)})
+
+					if !t2c.ok {
+						fields = append(fields,
+							outField{Key: "division_id", Value: nil},
+							outField{Key: "division", Value: nil},
+							outField{Key: "gencode_id", Value: nil},
+							outField{Key: "mito_gencode_id", Value: nil},
+						)
+						if showCodeNames {
+							fields = append(fields,
+								outField{Key: "gencode", Value: nil},
+								outField{Key: "mito_gencode", Value: nil},
+							)
+						}
+						rw.Write(fields...)
+						continue
+					}
+
+					division, _ = taxdb.Division(t2c.codes.Division)
+					fields = append(fields,
+						outField{Key: "division_id", Value: t2c.codes.Division},
+						outField{Key: "division", Value: division.Name},
+						outField{Key: "gencode_id", Value: t2c.codes.GenCode},
+						outField{Key: "mito_gencode_id", Value: t2c.codes.MitoGenCode},
+					)
+
+					if showCodeNames {
+						gc, _ = taxdb.GeneticCode(t2c.codes.GenCode)
+						mgc, _ = taxdb.GeneticCode(t2c.codes.MitoGenCode)
+						fields = append(fields,
+							outField{Key: "gencode", Value: gc.Name},
+							outField{Key: "mito_gencode", Value: mgc.Name},
+						)
+					}
+
+					rw.Write(fields...)
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(gencodeCmd)
+
+	gencodeCmd.Flags().StringP("taxid-field", "i", "1", "field index of taxid, or the column name with -H/--header. input data should be tab-separated")
+	gencodeCmd.Flags().BoolP("show-code-names", "n", false, "appending names of genetic codes")
+
+	addHeaderFlag(gencodeCmd)
+}
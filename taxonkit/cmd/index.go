@@ -0,0 +1,120 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/spf13/cobra"
+)
+
+// indexCmd represents the index command
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Create a binary index of taxdump files for fast loading",
+	Long: `Create a binary index of taxdump files for fast loading
+
+The index file "taxonkit.idx" is saved in the data directory, it contains
+nodes, ranks and scientific names in nodes.dmp and names.dmp, and
+TaxIds in delnodes.dmp and merged.dmp. The taxonomy version ID of the
+dump files is also computed and saved in the metadata file
+"taxdump-info.toml", so it's not recomputed by other commands.
+
+Other commands automatically use the index when it exists and all the
+dump files are unchanged since it was built, otherwise they parse the
+dump files as usual. So please re-run this command after updating the
+dump files.
+
+Examples:
+
+    $ taxonkit index --data-dir ~/.taxonkit/
+
+    # check whether the index is up to date
+    $ taxonkit index --check
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		if config.Archive != "" {
+			checkError(fmt.Errorf("taxdump archives can not be indexed, please extract the files first: %s", config.Archive))
+		}
+
+		check := getFlagBool(cmd, "check")
+		remove := getFlagBool(cmd, "remove")
+
+		if remove {
+			err := os.Remove(config.IndexFile)
+			if err != nil && !os.IsNotExist(err) {
+				checkError(err)
+			}
+			log.Infof("index file removed: %s", config.IndexFile)
+			return
+		}
+
+		if check {
+			err := taxonomy.CheckIndex(config.IndexFile, indexSourceFiles(config))
+			if err != nil {
+				checkError(fmt.Errorf("index file is not up to date: %s: %s", config.IndexFile, err))
+			}
+			log.Infof("index file is up to date: %s", config.IndexFile)
+			return
+		}
+
+		// stat before parsing, in case the files are changed during parsing
+		sources, err := taxonomy.StatIndexSources(indexSourceFiles(config))
+		checkError(err)
+
+		// do not use the existing index
+		indexFile := config.IndexFile
+		config.IndexFile = ""
+		taxdb := loadTaxonomy(&config, taxonomy.PartNodes|taxonomy.PartDelNodes|taxonomy.PartMerged|taxonomy.PartRanks|taxonomy.PartNames)
+
+		if config.Verbose {
+			log.Infof("building index ...")
+		}
+		checkError(taxonomy.WriteIndex(indexFile, taxdb, sources))
+
+		log.Infof("%d nodes in %d ranks, %d deleted and %d merged TaxIds saved to %s",
+			taxdb.NumNodes(), len(taxdb.Ranks()), taxdb.NumDelNodes(), taxdb.NumMerged(), indexFile)
+
+		// the taxonomy version ID is also saved, so it's not recomputed by other commands
+		meta, err := readDBMetadata(config.TaxdumpDir)
+		checkError(err)
+		if meta == nil {
+			meta = &DBMetadata{}
+		}
+		checkError(setVersionID(meta, indexSourceFiles(config)))
+		checkError(writeDBMetadata(config.TaxdumpDir, meta))
+		if config.Verbose {
+			log.Infof("taxonomy version ID %s saved to %s", meta.VersionID, filepath.Join(config.TaxdumpDir, dbMetadataFileName))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(indexCmd)
+
+	indexCmd.Flags().BoolP("check", "c", false, "only check whether the index is up to date, exit with non-zero status if not")
+	indexCmd.Flags().BoolP("remove", "", false, "remove the index file")
+}
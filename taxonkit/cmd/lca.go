@@ -21,13 +21,12 @@
 package cmd
 
 import (
-	"bufio"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 
-	"github.com/shenwei356/util/bytesize"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -46,6 +45,20 @@ Attention:
      single charactor separator is prefered.
   3. Empty lines or lines without valid TaxIds in the field are omitted.
   4. If some TaxIds are not found in database, it returns 0.
+  5. Merged, deleted and unknown TaxIds are handled according to the global
+     flags --on-merged, --on-deleted and --on-unknown, where "keep" leads to
+     a LCA of 0, and "drop" skips the TaxId and computes with left ones.
+     -D/--skip-deleted and -U/--skip-unfound are the same as
+     "--on-deleted drop" and "--on-unknown drop", respectively.
+  6. Lines are processed in parallel with -j/--threads, and the output
+     keeps the order of the input.
+  7. With -H/--header, the first line is treated as a header line, which is
+     passed through with the new column name "lca" appended, and the field
+     can be given by the column name, e.g., -H -i taxids.
+  8. TaxIds in free text, e.g., BLAST staxids or FASTA headers, can be
+     extracted with a regular expression via --taxid-regex, from the field
+     (-i/--taxids-field) or the whole line (-i 0), and the LCA is computed
+     for all matched TaxIds.
   
 Examples:
 
@@ -59,15 +72,16 @@ Examples:
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
 
-		var err error
-
 		files := getFileList(args)
 
 		if len(files) == 1 && isStdin(files[0]) && !xopen.IsStdin() {
 			checkError(fmt.Errorf("stdin not detected"))
 		}
 
-		field := getFlagPositiveInt(cmd, "taxids-field") - 1
+		reTaxId := getTaxIdRegex(cmd)
+		fieldFlag := getFlagField(cmd, "taxids-field", reTaxId != nil)
+		field := fieldFlag.index
+		hasHeader := getFlagBool(cmd, "header")
 
 		separater := getFlagString(cmd, "separater")
 		separator := getFlagString(cmd, "separator")
@@ -79,143 +93,161 @@ Examples:
 			separator = separater
 		}
 
-		skipDeleted := getFlagBool(cmd, "skip-deleted")
-		skipUnfound := getFlagBool(cmd, "skip-unfound")
-		keepInvalid := getFlagBool(cmd, "keep-invalid")
-
-		bufferSizeS := getFlagString(cmd, "buffer-size")
-		if bufferSizeS == "" {
-			checkError(fmt.Errorf("value of buffer size. supported unit: K, M, G"))
+		if getFlagBool(cmd, "skip-deleted") {
+			config.TaxIdPolicy.OnDeleted = actionDrop
 		}
-		bufferSize, err := bytesize.ParseByteSize(bufferSizeS)
-		if err != nil {
-			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
+		if getFlagBool(cmd, "skip-unfound") {
+			config.TaxIdPolicy.OnUnknown = actionDrop
 		}
+		keepInvalid := getFlagBool(cmd, "keep-invalid")
 
-		taxondb := loadTaxonomy(&config, false)
-		nodes := taxondb.Nodes
-		merged := taxondb.MergeNodes
-		delnodes := taxondb.DelNodes
+		taxondb := loadTaxonomy(&config, taxonomy.PartNodes|taxonomy.PartDelNodes|taxonomy.PartMerged)
+		taxondb.CacheLCA()
 
 		outfh, err := xopen.Wopen(config.OutFile)
 		checkError(err)
 		defer outfh.Close()
 
-		buf := make([]byte, bufferSize)
+		rw := newRecordWriter(outfh, config)
 
-		taxids := make([]uint32, 0, 128)
-		for _, file := range files {
-			fh, err := xopen.Ropen(file)
-			checkError(err)
+		type line2lca struct {
+			line string
+			lca  uint32
+		}
 
-			scanner := bufio.NewScanner(fh)
-			scanner.Buffer(buf, int(bufferSize))
-
-			var _taxid int
-			var line, item string
-			var items []string
-			var lca, taxid, taxid2 uint32
-			var ok, flag bool
-			for scanner.Scan() {
-				line = strings.Trim(scanner.Text(), "\r\n ")
-				if line == "" {
-					continue
-				}
+		// output lines of TSV are created in the workers, which is faster
+		result := func(line string, lca uint32) interface{} {
+			if config.OutFormat == outTSV {
+				return line + "\t" + strconv.FormatUint(uint64(lca), 10) + "\n"
+			}
+			return line2lca{line, lca}
+		}
 
-				lca = 0
+		fn := func(line string) (interface{}, bool, error) {
+			line = strings.Trim(line, "\r\n ")
+			if line == "" {
+				return nil, false, nil
+			}
 
-				items = strings.Split(line, "\t")
-				if len(items) <= field {
-					field = len(items) - 1
-				}
+			var taxid uint32
+			var action taxIdAction
 
-				if items[field] == "" {
-					continue
+			// all TaxIds extracted from the field or the line
+			if reTaxId != nil {
+				ids := extractTaxIds(reTaxId, regexField(line, field))
+				if len(ids) == 0 {
+					return nil, false, nil
 				}
+				taxids := make([]uint32, 0, len(ids))
+				for _, id := range ids {
+					taxid, _, action = config.TaxIdPolicy.Resolve(taxondb, id)
+					switch action {
+					case actionUse:
+						taxids = append(taxids, taxid)
+					case actionKeep:
+						return result(line, 0), true, nil
+					}
+				}
+				if len(taxids) == 0 && !keepInvalid {
+					return nil, false, nil
+				}
+				return result(line, taxondb.LCAOf(taxids)), true, nil
+			}
+
+			items := strings.Split(line, "\t")
+			f := field
+			if len(items) <= f {
+				f = len(items) - 1
+			}
 
-				items = strings.Split(items[field], separator)
+			if items[f] == "" {
+				return nil, false, nil
+			}
 
-				taxids = taxids[:0]
+			items = strings.Split(items[f], separator)
 
-				flag = false
-				for _, item = range items {
+			taxids := make([]uint32, 0, len(items))
+			var _taxid uint64
+			var err error
+			for _, item := range items {
+				// most items are plain TaxIds
+				if _taxid, err = strconv.ParseUint(item, 10, 32); err != nil {
 					item = reNonTaxid.ReplaceAllString(item, "")
 					if item == "" {
 						continue
 					}
+					n, _ := strconv.Atoi(item)
+					_taxid = uint64(uint32(n))
+				}
 
-					_taxid, _ = strconv.Atoi(item)
-					taxid = uint32(_taxid)
+				taxid, _, action = config.TaxIdPolicy.Resolve(taxondb, uint32(_taxid))
+				switch action {
+				case actionUse:
+					taxids = append(taxids, taxid)
+				case actionKeep:
+					return result(line, 0), true, nil
+				}
+			}
 
-					_, ok = nodes[taxid]
-					if ok {
-						taxids = append(taxids, taxid)
-						continue
-					}
+			if len(taxids) == 0 && !keepInvalid {
+				return nil, false, nil
+			}
 
-					if _, ok = delnodes[taxid]; ok {
-						log.Warningf("taxid %d was deleted", taxid)
-						if !skipDeleted {
-							flag = true
-							break
-						}
-						continue
-					}
-					if taxid2, ok = merged[taxid]; ok {
-						log.Warningf("taxid %d was merged into %d", taxid, taxid2)
-						taxid = taxid2
-						taxids = append(taxids, taxid)
-					} else {
-						log.Warningf("taxid %d not found", taxid)
-						if !skipUnfound {
-							flag = true
-							break
-						}
-					}
-				}
-				if flag {
-					outfh.WriteString(fmt.Sprintf("%s\t%d\n", line, 0))
-					continue
+			return result(line, taxondb.LCAOf(taxids)), true, nil
+		}
+
+		columns := []string{"lca"}
+
+		var headerWritten bool
+		for _, file := range files {
+			chunks, err := readLines(file, hasHeader, func(header string) {
+				field = fieldFlag.Resolve(strings.Split(header, "\t"))
+				if !headerWritten && header != "" {
+					rw.WriteHeader(header, columns...)
+					headerWritten = true
 				}
+			}, config.Threads, 64, fn)
+			checkError(err)
 
-				switch len(taxids) {
-				case 0:
-					if !keepInvalid {
-						continue
-					}
-				case 1:
-					lca = taxids[0]
-				default:
-					lca = taxids[0]
-					for _, taxid = range taxids {
-						lca = taxondb.LCA(lca, taxid)
+			var data interface{}
+			for chunk := range chunks {
+				checkError(chunk.Err)
+
+				for _, data = range chunk.Data {
+					switch r := data.(type) {
+					case string:
+						outfh.WriteString(r)
+						if config.LineBuffered {
+							outfh.Flush()
+						}
+					case line2lca:
+						rw.Write(
+							outField{Value: outInput(r.line)},
+							outField{Key: "lca", Value: r.lca},
+						)
 					}
 				}
-
-				outfh.WriteString(fmt.Sprintf("%s\t%d\n", line, lca))
-			}
-			if err := scanner.Err(); err != nil {
-				checkError(err)
 			}
-
-			checkError(fh.Close())
 		}
-
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(lcaCmd)
 
-	lcaCmd.Flags().IntP("taxids-field", "i", 1, "field index of TaxIds. Input data should be tab-separated")
+	lcaCmd.Flags().StringP("taxids-field", "i", "1", "field index of TaxIds, or the column name with -H/--header. Input data should be tab-separated")
 
+	lcaCmd.Flags().StringP("taxid-regex", "", "", `regular expression with a capture group for extracting TaxIds from the field (-i/--taxids-field), `+
+		`or the whole line with "-i 0", e.g., "taxid=(\d+)". LCA is computed for all matched TaxIds, and -s/--separator is ignored`)
 	lcaCmd.Flags().StringP("separater", "", " ", "separater for TaxIds. This flag is same to --separator.")
 	lcaCmd.Flags().StringP("separator", "s", " ", "separator for TaxIds")
 	lcaCmd.Flags().BoolP("skip-deleted", "D", false, "skip deleted TaxIds and compute with left ones")
 	lcaCmd.Flags().BoolP("skip-unfound", "U", false, "skip unfound TaxIds and compute with left ones")
 	lcaCmd.Flags().BoolP("keep-invalid", "K", false, "print the query even if no single valid taxid left")
-	lcaCmd.Flags().StringP("buffer-size", "b", "1M", `size of line buffer, supported unit: K, M, G. You need to increase the value when "bufio.Scanner: token too long" error occured`)
+	lcaCmd.Flags().StringP("buffer-size", "b", "1M", `size of line buffer, not needed anymore as lines of any length are supported`)
+	lcaCmd.Flags().MarkHidden("buffer-size")
 
+	addHeaderFlag(lcaCmd)
 }
 
 var reTaxid = regexp.MustCompile(`^\d+$`)
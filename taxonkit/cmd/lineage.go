@@ -21,14 +21,11 @@
 package cmd
 
 import (
-	"bytes"
 	"fmt"
 	"strconv"
 	"strings"
-	"sync"
 
-	"github.com/shenwei356/breader"
-	"github.com/shenwei356/util/stringutil"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -44,6 +41,13 @@ Input:
   - List of TaxIds, one TaxId per line.
   - Or tab-delimited format, please specify TaxId field 
     with flag -i/--taxid-field (default 1).
+  - With -H/--header, the first line is treated as a header line, which is
+    passed through with names of new columns appended, and the TaxId field
+    can be given by the column name, e.g., -H -i taxid.
+  - TaxIds in free text, e.g., "taxid 562" in Kraken output, can be
+    extracted with a regular expression via --taxid-regex, from the field
+    (-i/--taxid-field) or the whole line (-i 0). All matches are used,
+    and the matched TaxId is appended after the input line in the output.
   - Supporting (gzipped) file or STDIN.
 
 Output:
@@ -54,10 +58,19 @@ Output:
      - "0" for deleted TaxIds, provided by "delnodes.dmp".
      - New TaxIds for merged TaxIds, provided by "merged.dmp".
      - Taxids for these found in "nodes.dmp".
+     Merged, deleted and unknown TaxIds are handled according to the global
+     flags --on-merged, --on-deleted and --on-unknown. By default, lines of
+     deleted and unknown TaxIds are kept with empty lineages.
   3. Lineage, delimiter can be changed with flag -d/--delimiter.
   4. (Optional) TaxIds taxons in the lineage (-t/--show-lineage-taxids)
   5. (Optional) Name (-n/--show-name)
   6. (Optional) Rank (-r/--show-rank)
+  7. (Optional) Lineage ranks (-R/--show-lineage-ranks)
+  8. (Optional) Potential hosts (--show-hosts), separated by comma,
+     provided by "host.dmp" of new_taxdump.
+  9. (Optional) Type material identifiers (--show-type-material), e.g., type
+     strains, separated by "; ", provided by "typematerial.dmp" of new_taxdump.
+     Types can be restricted with --type-material-type.
 
 Filter out invalid and deleted taxids, and replace merged 
 taxids with new ones:
@@ -74,6 +87,23 @@ taxids with new ones:
         | csvtk filter2 -H -t -f '$5>0' \
         | csvtk -H -t cut -f -3
 
+Names in other name classes of names.dmp, e.g., "genbank common name",
+can be shown with --name-class, and the scientific name is used when
+a TaxId has no names in the classes:
+
+    $ echo 9606 | taxonkit lineage -n -L --name-class "genbank common name"
+    9606    human
+
+Files of new_taxdump (https://ftp.ncbi.nlm.nih.gov/pub/taxonomy/new_taxdump/)
+are supported. Taxa with type strains can be flagged with:
+
+    $ taxonkit lineage -n -L --show-type-material --type-material-type "type strain" taxids.txt
+
+The version of the taxonomy data can be recorded in a comment line before
+the output with --taxonomy-version, e.g.,
+
+    # taxonomy version: 2024-05-01_d2a38e12a1c3f6b0, source: ..., data: ...
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -83,9 +113,17 @@ taxids with new ones:
 		printLineageInRank := getFlagBool(cmd, "show-lineage-ranks")
 		printRank := getFlagBool(cmd, "show-rank")
 		printName := getFlagBool(cmd, "show-name")
-		field := getFlagPositiveInt(cmd, "taxid-field") - 1
+		reTaxId := getTaxIdRegex(cmd)
+		taxidField := getFlagField(cmd, "taxid-field", reTaxId != nil)
+		field := taxidField.index
+		hasHeader := getFlagBool(cmd, "header")
 		showCode := getFlagBool(cmd, "show-status-code")
 		noLineage := getFlagBool(cmd, "no-lineage")
+		nameClasses := getFlagStringSlice(cmd, "name-class")
+		printHosts := getFlagBool(cmd, "show-hosts")
+		printTypeMaterial := getFlagBool(cmd, "show-type-material")
+		typeMaterialTypes := getFlagStringSlice(cmd, "type-material-type")
+		showVersion := getFlagBool(cmd, "taxonomy-version")
 
 		files := getFileList(args)
 
@@ -93,18 +131,31 @@ taxids with new ones:
 			checkError(fmt.Errorf("stdin not detected"))
 		}
 
-		if noLineage && !printRank && !printName {
+		if noLineage && !printRank && !printName && !printHosts && !printTypeMaterial {
 			checkError(fmt.Errorf("when given -L/--no-lineage, -n/--show-name or/and -r/--show-rank needed"))
 		}
 
 		// -------------------- load data ----------------------
 
-		var tree map[uint32]uint32
-		var ranks map[uint32]string
-		var names map[uint32]string
-		var delnodes map[uint32]struct{}
-		var merged map[uint32]uint32
-		tree, ranks, names, delnodes, merged = loadData(config, true, printRank || printLineageInRank)
+		parts := taxonomy.PartNodes | taxonomy.PartDelNodes | taxonomy.PartMerged | nameClassParts(nameClasses)
+		if printRank || printLineageInRank {
+			parts |= taxonomy.PartRanks
+		}
+		if printHosts {
+			parts |= taxonomy.PartHosts
+		}
+		if printTypeMaterial {
+			parts |= taxonomy.PartTypeMaterial
+		}
+		taxdb := loadTaxonomy(&config, parts)
+		taxonName := taxonNameFunc(taxdb, nameClasses)
+		if printHosts {
+			checkNewTaxdumpPart(taxdb, taxonomy.PartHosts, "host.dmp")
+		}
+		if printTypeMaterial {
+			checkNewTaxdumpPart(taxdb, taxonomy.PartTypeMaterial, "typematerial.dmp")
+		}
+		typeMaterials := typeMaterialFunc(taxdb, typeMaterialTypes)
 
 		// -------------------- load data ----------------------
 
@@ -112,18 +163,86 @@ taxids with new ones:
 		checkError(err)
 		defer outfh.Close()
 
+		if showVersion {
+			if config.OutFormat == outJSONL {
+				checkError(fmt.Errorf("flag --taxonomy-version is not supported for --out-format jsonl"))
+			}
+			outfh.WriteString(getTaxonomyVersion(config).Header())
+		}
+
+		rw := newRecordWriter(outfh, config)
+
 		type taxid2lineage struct {
 			line           string
+			matched        interface{} // TaxId extracted with --taxid-regex
 			taxid          uint32
-			lineage        string
-			lineageInTaxid string
-			lineageInRank  string
-			notFound       bool
+			status         interface{} // name of the status, nil for invalid TaxIds
+			lineage        interface{}
+			lineageInTaxid interface{}
+			lineageInRank  interface{}
+			code           int // status code
 		}
 
-		var poolStrings = &sync.Pool{New: func() interface{} {
-			return make([]string, 0, 16)
-		}}
+		// lineageOf queries the lineage of a TaxId, it returns false for dropped TaxIds.
+		lineageOf := func(line string, id uint32) (taxid2lineage, bool) {
+			taxid, status, action := config.TaxIdPolicy.Resolve(taxdb, id)
+			if action == actionDrop {
+				return taxid2lineage{}, false
+			}
+			if action == actionKeep {
+				switch status {
+				case taxonomy.Merged:
+					newtaxid, _ := taxdb.TaxId(taxid)
+					return taxid2lineage{line: line, status: status.String(), code: int(newtaxid)}, true
+				case taxonomy.Deleted:
+					return taxid2lineage{line: line, status: status.String()}, true
+				default:
+					return taxid2lineage{line: line, status: status.String(), code: -1}, true
+				}
+			}
+
+			if noLineage {
+				return taxid2lineage{line: line, taxid: taxid, status: status.String(), code: int(taxid)}, true
+			}
+
+			taxids := taxdb.LineageTaxIds(taxid)
+
+			var lineageInTaxid, lineageInRank interface{}
+
+			items := make([]string, len(taxids))
+			for i, tax := range taxids {
+				items[i] = taxonName(tax)
+			}
+			lineage := rw.List(items, delimiter)
+
+			if printLineageInTaxid {
+				if config.OutFormat == outJSONL {
+					lineageInTaxid = taxids
+				} else {
+					items = make([]string, len(taxids))
+					for i, tax := range taxids {
+						items[i] = strconv.Itoa(int(tax))
+					}
+					lineageInTaxid = strings.Join(items, delimiter)
+				}
+			}
+
+			if printLineageInRank {
+				items = make([]string, len(taxids))
+				for i, tax := range taxids {
+					items[i] = taxdb.Rank(tax)
+				}
+				lineageInRank = rw.List(items, delimiter)
+			}
+
+			return taxid2lineage{line, nil, taxid,
+				status.String(),
+				lineage,
+				lineageInTaxid,
+				lineageInRank,
+				int(taxid),
+			}, true
+		}
 
 		fn := func(line string) (interface{}, bool, error) {
 			line = strings.Trim(line, "\r\n ")
@@ -131,153 +250,156 @@ taxids with new ones:
 				return nil, false, nil
 			}
 
+			// one record for each TaxId extracted from the line
+			if reTaxId != nil {
+				ids := extractTaxIds(reTaxId, regexField(line, field))
+				if len(ids) == 0 {
+					return []taxid2lineage{{line: line}}, true, nil
+				}
+				t2ls := make([]taxid2lineage, 0, len(ids))
+				for _, id := range ids {
+					if t2l, ok := lineageOf(line, id); ok {
+						t2l.matched = id
+						t2ls = append(t2ls, t2l)
+					}
+				}
+				if len(t2ls) == 0 {
+					return nil, false, nil
+				}
+				return t2ls, true, nil
+			}
+
 			data := strings.Split(line, "\t")
 			if len(data) <= field {
 				field = len(data) - 1
 			}
 
 			if data[field] == "" {
-				return taxid2lineage{line, 0, "", "", "", false}, true, nil
+				return taxid2lineage{line: line}, true, nil
 			}
 			id, e := strconv.Atoi(data[field])
 			if e != nil {
-				return taxid2lineage{line, 0, "", "", "", false}, true, nil
+				return taxid2lineage{line: line}, true, nil
 			}
 
-			// lineage := make([]string, 0, 16)
-			lineage := poolStrings.Get().([]string)
-			var lineageInTaxid, lineageInRank []string
-
-			if printLineageInTaxid {
-				lineageInTaxid = make([]string, 0, 16)
+			t2l, ok := lineageOf(line, uint32(id))
+			if !ok {
+				return nil, false, nil
 			}
-			if printLineageInRank {
-				lineageInRank = make([]string, 0, 16)
-			}
-
-			var child, parent, newtaxid uint32
-			var ok bool
-			child = uint32(id)
-			var notFound bool
-			for {
-				parent, ok = tree[child]
-				if !ok { // taxid not found
-					// check if it was deleted
-					if _, ok = delnodes[child]; ok {
-						// log
-						log.Warningf("taxid %d was deleted", child)
-						id = 0
-						break
-					}
-					// check if it was merged
-					if newtaxid, ok = merged[child]; ok {
-						// log
-						log.Warningf("taxid %d was merged into %d", child, newtaxid)
-						child = newtaxid
-						parent = tree[child]
-						id = int(child)
-					} else {
-						id = 0
-						log.Warningf("taxid %d not found", child)
-						notFound = true
-						break
-					}
-				}
-
-				lineage = append(lineage, names[child])
-				if noLineage {
-					break
-				}
+			return t2l, true, nil
+		}
 
+		var columns []string
+		if hasHeader {
+			if reTaxId != nil {
+				columns = append(columns, "matched_taxid")
+			}
+			if showCode {
+				columns = append(columns, "status_code")
+			}
+			if !noLineage {
+				columns = append(columns, "lineage")
 				if printLineageInTaxid {
-					lineageInTaxid = append(lineageInTaxid, strconv.Itoa(int(child)))
-				}
-				if printLineageInRank {
-					lineageInRank = append(lineageInRank, ranks[child])
+					columns = append(columns, "lineage_taxids")
 				}
+			}
+			if printName {
+				columns = append(columns, "name")
+			}
+			if printRank {
+				columns = append(columns, "rank")
+			}
+			if printLineageInRank && !noLineage {
+				columns = append(columns, "lineage_ranks")
+			}
+			if printHosts {
+				columns = append(columns, "hosts")
+			}
+			if printTypeMaterial {
+				columns = append(columns, "type_materials")
+			}
+		}
 
-				if parent == 1 {
-					break
+		fields := make([]outField, 0, 12)
+		var taxid, name, rank interface{}
+		write := func(t2l taxid2lineage) {
+			if t2l.taxid > 0 {
+				taxid = t2l.taxid
+				if printName {
+					name = taxonName(t2l.taxid)
+				}
+				if printRank {
+					rank = taxdb.Rank(t2l.taxid)
 				}
-				child = parent
+			} else {
+				taxid, name, rank = nil, nil, nil
 			}
-			child = uint32(id)
 
-			var lineageS, lineageInTaxidS, lineageInRankS string
-			lineageS = strings.Join(stringutil.ReverseStringSlice(lineage), delimiter)
+			fields = fields[:0]
+			fields = append(fields,
+				outField{Value: outInput(t2l.line)},
+				outField{Key: "taxid", Value: taxid, JSONOnly: true},
+				outField{Key: "status", Value: t2l.status, JSONOnly: true},
+			)
 
-			lineage = lineage[:0]
-			poolStrings.Put(lineage)
+			if reTaxId != nil {
+				fields = append(fields, outField{Key: "matched_taxid", Value: t2l.matched})
+			}
+			if showCode {
+				fields = append(fields, outField{Key: "status_code", Value: t2l.code})
+			}
+			if !noLineage {
+				fields = append(fields, outField{Key: "lineage", Value: t2l.lineage})
+			}
 
-			if printLineageInTaxid {
-				lineageInTaxidS = strings.Join(stringutil.ReverseStringSlice(lineageInTaxid), delimiter)
+			if printLineageInTaxid && !noLineage {
+				fields = append(fields, outField{Key: "lineage_taxids", Value: t2l.lineageInTaxid})
+			}
 
-				lineageInTaxid = lineageInTaxid[:0]
-				poolStrings.Put(lineageInTaxid)
+			if printName {
+				fields = append(fields, outField{Key: "name", Value: name})
+			}
+			if printRank {
+				fields = append(fields, outField{Key: "rank", Value: rank})
 			}
 
-			if printLineageInRank {
-				lineageInRankS = strings.Join(stringutil.ReverseStringSlice(lineageInRank), delimiter)
+			if printLineageInRank && !noLineage {
+				fields = append(fields, outField{Key: "lineage_ranks", Value: t2l.lineageInRank})
+			}
 
-				lineageInRank = lineageInRank[:0]
-				poolStrings.Put(lineageInRank)
+			if printHosts {
+				fields = append(fields, outField{Key: "hosts", Value: taxdb.Hosts(t2l.taxid), Sep: ","})
+			}
+			if printTypeMaterial {
+				fields = append(fields, outField{Key: "type_materials", Value: typeMaterials(t2l.taxid), Sep: "; "})
 			}
 
-			return taxid2lineage{line, child,
-				lineageS,
-				lineageInTaxidS,
-				lineageInRankS,
-				notFound,
-			}, true, nil
+			rw.Write(fields...)
 		}
 
-		var buf bytes.Buffer
+		var headerWritten bool
 		for _, file := range files {
-			reader, err := breader.NewBufferedReader(file, config.Threads, 10, fn)
+			chunks, err := readLines(file, hasHeader, func(header string) {
+				field = taxidField.Resolve(strings.Split(header, "\t"))
+				if !headerWritten && header != "" {
+					rw.WriteHeader(header, columns...)
+					headerWritten = true
+				}
+			}, config.Threads, 10, fn)
 			checkError(err)
 
-			var t2l taxid2lineage
-			for chunk := range reader.Ch {
+			for chunk := range chunks {
 				checkError(chunk.Err)
 
 				for _, data := range chunk.Data {
-					t2l = data.(taxid2lineage)
-
-					buf.Reset()
-					buf.WriteString(t2l.line)
-
-					if showCode {
-						if t2l.notFound {
-							buf.WriteString("\t-1")
-						} else {
-							buf.WriteString("\t" + strconv.Itoa(int(t2l.taxid)))
+					switch t2l := data.(type) {
+					case taxid2lineage:
+						write(t2l)
+					case []taxid2lineage:
+						for _, r := range t2l {
+							write(r)
 						}
 					}
-					if !noLineage {
-						buf.WriteString("\t" + t2l.lineage)
-					}
-
-					if printLineageInTaxid && !noLineage {
-						buf.WriteString("\t" + t2l.lineageInTaxid)
-					}
-
-					if printName {
-						buf.WriteString("\t" + names[t2l.taxid])
-					}
-					if printRank {
-						buf.WriteString("\t" + ranks[t2l.taxid])
-					}
-
-					if printLineageInRank && !noLineage {
-						buf.WriteString("\t" + t2l.lineageInRank)
-					}
-
-					buf.WriteString("\n")
-
-					outfh.WriteString(buf.String())
-					if config.LineBuffered {
-						outfh.Flush()
-					}
 				}
 			}
 		}
@@ -291,8 +413,20 @@ func init() {
 	lineageCmd.Flags().BoolP("show-lineage-taxids", "t", false, `appending lineage consisting of taxids`)
 	lineageCmd.Flags().BoolP("show-lineage-ranks", "R", false, `appending ranks of all levels`)
 	lineageCmd.Flags().BoolP("show-rank", "r", false, `appending rank of taxids`)
-	lineageCmd.Flags().BoolP("show-name", "n", false, `appending scientific name`)
-	lineageCmd.Flags().IntP("taxid-field", "i", 1, "field index of taxid. input data should be tab-separated")
+	lineageCmd.Flags().BoolP("show-name", "n", false, `appending scientific name, or the name in classes given by --name-class`)
+	lineageCmd.Flags().StringSliceP("name-class", "", []string{taxonomy.ScientificName},
+		`name class(es) of names in lineage and -n/--show-name, e.g., "genbank common name", "synonym". `+
+			`multiple values are checked in order, and the scientific name is used if none is found`)
+	lineageCmd.Flags().StringP("taxid-field", "i", "1", "field index of taxid, or the column name with -H/--header. input data should be tab-separated")
+	lineageCmd.Flags().StringP("taxid-regex", "", "", `regular expression with a capture group for extracting TaxIds from the field (-i/--taxid-field), `+
+		`or the whole line with "-i 0", e.g., "taxid=(\d+)". a record is output for each match, with the matched TaxId appended after the input line`)
 	lineageCmd.Flags().StringP("delimiter", "d", ";", "field delimiter in lineage")
 	lineageCmd.Flags().BoolP("no-lineage", "L", false, "do not show lineage, when user just want names or/and ranks")
+	lineageCmd.Flags().BoolP("taxonomy-version", "", false, "print the taxonomy version in a comment line before the output")
+	lineageCmd.Flags().BoolP("show-hosts", "", false, `appending potential hosts, provided by "host.dmp" of new_taxdump`)
+	lineageCmd.Flags().BoolP("show-type-material", "", false, `appending identifiers of type materials, provided by "typematerial.dmp" of new_taxdump`)
+	lineageCmd.Flags().StringSliceP("type-material-type", "", []string{},
+		`only show type materials of these types (case ignored), e.g., "type strain", "neotype"`)
+
+	addHeaderFlag(lineageCmd)
 }
@@ -21,13 +21,10 @@
 package cmd
 
 import (
-	"bufio"
 	"fmt"
-	"sort"
-	"strconv"
 	"strings"
-	"sync"
 
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -41,6 +38,9 @@ var listCmd = &cobra.Command{
 Attention:
   1. When multiple taxids are given, the output may contain duplicated records
      if some taxids are descendants of others.
+  2. With --out-format csv or jsonl, one record is written for each node,
+     with the columns/keys: query, taxid, parent, level, rank (-r/--show-rank)
+     and name (-n/--show-name). The level of a queried TaxId is 0.
 
 Examples:
 
@@ -49,6 +49,9 @@ Examples:
         63221 [subspecies] Homo sapiens neanderthalensis
         741158 [subspecies] Homo sapiens subsp. 'Denisova'
 
+    # names in other name classes, the scientific name is used if not found
+    $ taxonkit list --ids 9606 -n --name-class "genbank common name"
+
     $ taxonkit list --ids 9606 --indent ""
     9606
     63221
@@ -86,106 +89,57 @@ Examples:
 
 		printName := getFlagBool(cmd, "show-name")
 		printRank := getFlagBool(cmd, "show-rank")
+		nameClasses := getFlagStringSlice(cmd, "name-class")
 
 		// -------------------- load data ----------------------
 
-		var names map[uint32]string
-		var delnodes map[uint32]struct{}
-		var merged map[uint32]uint32
-		// var tree map[uint32]map[uint32]bool // different from that in lineage.go
-		var tree map[uint32]map[uint32]interface{} // different from that in lineage.go
-		var ranks map[uint32]string
-
-		var wg sync.WaitGroup
-
-		wg.Add(1)
-		go func() {
-			_, _, names, delnodes, merged = loadData(config, false, false)
-			wg.Done()
-		}()
-
-		wg.Add(1)
-		go func() {
-			// tree = make(map[uint32]map[uint32]bool, mapInitialSize)
-			tree = make(map[uint32]map[uint32]interface{}, mapInitialSize)
-			ranks = make(map[uint32]string, mapInitialSize)
-
-			fh, err := xopen.Ropen(config.NodesFile)
-			checkError(err)
-
-			items := make([]string, 6)
-			scanner := bufio.NewScanner(fh)
-			var _child, _parent int
-			var child, parent uint32
-			var rank string
-			var ok bool
-			for scanner.Scan() {
-				stringSplitN(scanner.Text(), "\t", 6, &items)
-				if len(items) < 6 {
-					continue
-				}
-
-				_child, err = strconv.Atoi(items[0])
-				if err != nil {
-					continue
-				}
+		parts := taxonomy.PartNodes | taxonomy.PartDelNodes | taxonomy.PartMerged
+		if printRank {
+			parts |= taxonomy.PartRanks
+		}
+		if printName {
+			parts |= nameClassParts(nameClasses)
+		}
+		taxdb := loadTaxonomy(&config, parts)
 
-				_parent, err = strconv.Atoi(items[2])
-				if err != nil {
-					continue
-				}
-				child, parent, rank = uint32(_child), uint32(_parent), items[4]
+		var taxonName func(uint32) string
+		if printName {
+			taxonName = taxonNameFunc(taxdb, nameClasses)
+		}
 
-				// ----------------------------------
+		// -------------------- load data ----------------------
 
-				if child > 1 {
-					if _, ok = tree[parent]; !ok {
-						// tree[parent] = make(map[uint32]bool)
-						tree[parent] = make(map[uint32]interface{})
-					}
-					// tree[parent][child] = false
-					tree[parent][child] = struct{}{}
-				}
+		if config.OutFormat != outTSV {
+			if jsonFormat {
+				checkError(fmt.Errorf("flag -J/--json is not supported for --out-format csv or jsonl"))
+			}
 
-				if _, ok = tree[child]; !ok {
-					// tree[child] = make(map[uint32]bool)
-					tree[child] = make(map[uint32]interface{})
-				}
-				if printRank {
-					ranks[child] = rank
+			rw := newRecordWriter(outfh, config)
+			var newtaxid uint32
+			var action taxIdAction
+			for _, id := range ids {
+				newtaxid, _, action = config.TaxIdPolicy.Resolve(taxdb, uint32(id))
+				if action != actionUse {
+					continue
 				}
+				writeTreeRecords(taxdb, rw, newtaxid, newtaxid, 0, printName, taxonName, printRank)
 			}
-			if err := scanner.Err(); err != nil {
-				checkError(err)
-			}
-			wg.Done()
-		}()
-
-		wg.Wait()
-
-		// -------------------- load data ----------------------
+			return
+		}
 
 		var level int
 		if jsonFormat {
 			outfh.WriteString("{\n")
 		}
 		var newtaxid uint32
+		var action taxIdAction
 		for i, id := range ids {
-			if _, ok := tree[uint32(id)]; !ok {
-				// check if it was deleted
-				if _, ok = delnodes[uint32(id)]; ok {
-					log.Warningf("taxid %d was deleted", id)
-					continue
-				}
-				// check if it was merged
-				if newtaxid, ok = merged[uint32(id)]; ok {
-					log.Warningf("taxid %d was merged into %d", id, newtaxid)
-					id = int(newtaxid)
-				} else {
-					log.Warningf("taxid %d not found", id)
-					continue
-				}
+			// there's nothing to list for kept merged, deleted or unknown TaxIds
+			newtaxid, _, action = config.TaxIdPolicy.Resolve(taxdb, uint32(id))
+			if action != actionUse {
+				continue
 			}
+			id = int(newtaxid)
 
 			level = 0
 			if jsonFormat {
@@ -200,10 +154,10 @@ Examples:
 			outfh.WriteString(fmt.Sprintf("%d", id))
 
 			if printRank {
-				outfh.WriteString(fmt.Sprintf(" [%s]", ranks[uint32(id)]))
+				outfh.WriteString(fmt.Sprintf(" [%s]", taxdb.Rank(uint32(id))))
 			}
 			if printName {
-				outfh.WriteString(fmt.Sprintf(" %s", names[uint32(id)]))
+				outfh.WriteString(fmt.Sprintf(" %s", taxonName(uint32(id))))
 			}
 
 			level = 0
@@ -216,8 +170,8 @@ Examples:
 				outfh.Flush()
 			}
 
-			traverseTree(tree, uint32(id), outfh, indent, level+1, names,
-				printName, ranks, printRank, jsonFormat, config)
+			traverseTree(taxdb, uint32(id), outfh, indent, level+1,
+				printName, taxonName, printRank, jsonFormat, config)
 
 			if jsonFormat {
 				outfh.WriteString(fmt.Sprintf("%s}", strings.Repeat(indent, level)))
@@ -246,44 +200,33 @@ func init() {
 	listCmd.Flags().StringP("ids", "i", "", "TaxId(s), multiple values should be separated by comma")
 	listCmd.Flags().StringP("indent", "I", "  ", "indent")
 	listCmd.Flags().BoolP("show-rank", "r", false, `output rank`)
-	listCmd.Flags().BoolP("show-name", "n", false, `output scientific name`)
+	listCmd.Flags().BoolP("show-name", "n", false, `output scientific name, or the name in classes given by --name-class`)
+	listCmd.Flags().StringSliceP("name-class", "", []string{taxonomy.ScientificName},
+		`name class(es) of names for -n/--show-name, e.g., "genbank common name", "synonym". `+
+			`multiple values are checked in order, and the scientific name is used if none is found`)
 	listCmd.Flags().BoolP("json", "J", false, `output in JSON format. you can save the result in file with suffix ".json" and open with modern text editor`)
 }
 
 func traverseTree(
-	// tree map[uint32]map[uint32]bool,
-	tree map[uint32]map[uint32]interface{},
+	taxdb *taxonomy.Taxonomy,
 	parent uint32,
 	outfh *xopen.Writer,
 	indent string,
 	level int,
-	names map[uint32]string,
 	printName bool,
-	ranks map[uint32]string,
+	taxonName func(uint32) string,
 	printRank bool,
 	jsonFormat bool,
 	config Config,
 ) {
-	if _, ok := tree[parent]; !ok {
+	if _, ok := taxdb.Parent(parent); !ok {
 		return
 	}
 
-	// sort children by taxid
-	children := make([]int, len(tree[parent]))
-	i := 0
-	for child := range tree[parent] {
-		children[i] = int(child)
-		i++
-	}
-	sort.Ints(children)
-
-	var child uint32
-	for i, c := range children {
-		child = uint32(c)
-		// if tree[parent][child] {
-		// 	continue
-		// }
+	// sorted by taxid
+	children := taxdb.Children(parent)
 
+	for i, child := range children {
 		outfh.WriteString(strings.Repeat(indent, level))
 
 		if jsonFormat {
@@ -291,15 +234,15 @@ func traverseTree(
 		}
 		outfh.WriteString(fmt.Sprintf("%d", child))
 		if printRank {
-			outfh.WriteString(fmt.Sprintf(" [%s]", ranks[child]))
+			outfh.WriteString(fmt.Sprintf(" [%s]", taxdb.Rank(child)))
 		}
 		if printName {
-			outfh.WriteString(fmt.Sprintf(" %s", names[child]))
+			outfh.WriteString(fmt.Sprintf(" %s", taxonName(child)))
 		}
 
 		var ok bool
 		if jsonFormat {
-			_, ok = tree[child]
+			_, ok = taxdb.Parent(child)
 			if ok {
 				outfh.WriteString(`": {`)
 			} else {
@@ -314,10 +257,8 @@ func traverseTree(
 			outfh.Flush()
 		}
 
-		// tree[parent][child] = true
-
-		traverseTree(tree, child, outfh, indent, level+1, names, printName,
-			ranks, printRank, jsonFormat, config)
+		traverseTree(taxdb, child, outfh, indent, level+1, printName, taxonName,
+			printRank, jsonFormat, config)
 
 		if jsonFormat && ok {
 			outfh.WriteString(fmt.Sprintf("%s}", strings.Repeat(indent, level)))
@@ -331,3 +272,39 @@ func traverseTree(
 		}
 	}
 }
+
+// writeTreeRecords writes one record for each node of a subtree, in the same order of traverseTree.
+func writeTreeRecords(
+	taxdb *taxonomy.Taxonomy,
+	rw *recordWriter,
+	query uint32,
+	taxid uint32,
+	level int,
+	printName bool,
+	taxonName func(uint32) string,
+	printRank bool,
+) {
+	parent, ok := taxdb.Parent(taxid)
+	if !ok {
+		return
+	}
+
+	fields := make([]outField, 0, 6)
+	fields = append(fields,
+		outField{Key: "query", Value: query},
+		outField{Key: "taxid", Value: taxid},
+		outField{Key: "parent", Value: parent},
+		outField{Key: "level", Value: level},
+	)
+	if printRank {
+		fields = append(fields, outField{Key: "rank", Value: taxdb.Rank(taxid)})
+	}
+	if printName {
+		fields = append(fields, outField{Key: "name", Value: taxonName(taxid)})
+	}
+	rw.Write(fields...)
+
+	for _, child := range taxdb.Children(taxid) {
+		writeTreeRecords(taxdb, rw, query, child, level+1, printName, taxonName, printRank)
+	}
+}
@@ -22,10 +22,11 @@ package cmd
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 
-	"github.com/shenwei356/breader"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 	"github.com/suggest-go/suggest/pkg/dictionary"
@@ -49,15 +50,118 @@ Attention:
     Drosophila      32281   subgenus
     Drosophila      2081351 genus
 
+     Homonyms can be resolved with the context of the names:
+       -p/--parent-field   a field of names or TaxIds of parents or any ancestors
+       --rank              expected ranks
+       --within            TaxIds of subtrees the taxa belong to
+     Names of direct parents are checked first, and then all ancestors.
+     A column "resolution" is appended in this case, with values of:
+       unique     only one TaxId matches the name, and it fits the context
+       resolved   multiple TaxIds match the name, and only one fits the context
+       ambiguous  multiple TaxIds fit the context, all of them are outputted
+       unmatched  no TaxId fits the context, the TaxId is empty
+       not_found  the name is not found
+
+    $ echo -e "Drosophila\tDrosophilidae\nDrosophila\tFungi" \
+        | taxonkit name2taxid -p 2 -r
+    Drosophila      Drosophilidae   7215    genus   resolved
+    Drosophila      Fungi           2081351 genus   resolved
+
+    $ echo Drosophila | taxonkit name2taxid --within 33208 --rank genus
+    Drosophila      7215    resolved
+
+  2. Names of all name classes are searched by default, and a name might be
+     a synonym of one TaxId and the scientific name of another one.
+     The matched name class and the current scientific name can be outputted
+     with -c/--show-name-class and -N/--show-sci-name. -P/--prefer-sci-name
+     only keeps the TaxIds matched by scientific names in this case.
+
+    $ echo "Lactobacillus casei" | taxonkit name2taxid -c -N
+    Lactobacillus casei     1582    synonym Lacticaseibacillus casei
+
+  3. Names in sample sheets might contain authorities, qualifiers or odd
+     Unicode characters. With --normalize, names not found are normalized
+     step by step (--normalize-steps), and searched again after each step:
+       unicode     folding letters with diacritics, quotes, dashes and
+                   spaces to ASCII, removing invisible characters
+       space       trimming and collapsing white spaces
+       bracket     removing brackets and quotes, "[Clostridium] scindens"
+       qualifier   removing "sp./spp." and the rest, and "cf./aff."
+       authority   removing authorities and years,
+                   "Homo sapiens Linnaeus, 1758" -> "Homo sapiens"
+       candidatus  expanding "Ca." to "Candidatus", and then removing it
+       genus       expanding abbreviated genus names with scientific names
+                   sharing the rest, "E. coli" -> "Escherichia coli". If
+                   several genera match, the name is ambiguous, and all of
+                   them are outputted with a warning
+     The query used for the hit, or the fully normalized name if nothing is
+     found, is appended in a column "normalized_name". For ambiguous
+     abbreviated genus names, it's the expanded name of each TaxId.
+     In fuzzy search (-f/--fuzzy), the fully normalized name is always used,
+     without expanding abbreviated genus names.
+
+    $ echo -e "Bacteroides sp. ABC\nHomo  sapiens (Linnaeus, 1758)" \
+        | taxonkit name2taxid --normalize
+    Bacteroides sp. ABC     816     Bacteroides
+    Homo  sapiens (Linnaeus, 1758)  9606    Homo sapiens
+
+  4. With -H/--header, the first line is treated as a header line, which is
+     passed through with names of new columns appended, and the name field
+     can be given by the column name, e.g., -H -i name.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
 
 		printRank := getFlagBool(cmd, "show-rank")
-		field := getFlagPositiveInt(cmd, "name-field") - 1
+		fieldFlag := getFlagField(cmd, "name-field", false)
+		field := fieldFlag.index
+		hasHeader := getFlagBool(cmd, "header")
 		limite2SciName := getFlagBool(cmd, "sci-name")
 		fuzzy := getFlagBool(cmd, "fuzzy")
 		fuzzyTopN := getFlagPositiveInt(cmd, "fuzzy-top-n")
+		printNameClass := getFlagBool(cmd, "show-name-class")
+		printSciName := getFlagBool(cmd, "show-sci-name")
+		preferSciName := getFlagBool(cmd, "prefer-sci-name")
+		if preferSciName && limite2SciName {
+			log.Warningf("flag -P/--prefer-sci-name is ignored with -s/--sci-name")
+			preferSciName = false
+		}
+		needNameClass := (printNameClass || preferSciName) && !limite2SciName
+
+		var normalizer *taxonomy.NameNormalizer
+		var expandGenus bool // the step "genus", which needs names in the taxonomy
+		if getFlagBool(cmd, "normalize") {
+			steps := make([]string, 0, len(nameNormSteps))
+			for _, step := range getFlagStringSlice(cmd, "normalize-steps") {
+				step = strings.ToLower(strings.TrimSpace(step))
+				if !slices.Contains(nameNormSteps, step) {
+					checkError(fmt.Errorf(`invalid value of flag --normalize-steps: "%s", available: "%s"`,
+						step, strings.Join(nameNormSteps, `", "`)))
+				}
+				if step == nameNormStepGenus {
+					expandGenus = true
+					continue
+				}
+				steps = append(steps, step)
+			}
+			var err error
+			normalizer, err = taxonomy.NewNameNormalizer(steps)
+			checkError(err)
+		}
+
+		var parentFlag *inputField
+		var pfield int
+		if getFlagString(cmd, "parent-field") != "" {
+			parentFlag = getFlagField(cmd, "parent-field", false)
+			pfield = parentFlag.index
+		}
+		ranks := make(map[string]struct{})
+		for _, rank := range getFlagStringSlice(cmd, "rank") {
+			ranks[strings.ToLower(rank)] = struct{}{}
+		}
+		withinInt := getFlagTaxonIDs(cmd, "within")
+		useContext := parentFlag != nil || len(ranks) > 0 || len(withinInt) > 0
 
 		files := getFileList(args)
 
@@ -69,83 +173,180 @@ Attention:
 		checkError(err)
 		defer outfh.Close()
 
-		var m map[string][]uint32
-
-		var dict dictionary.Dictionary
-		var service *suggest.Service
-
-		var wg sync.WaitGroup
-		wg.Add(1)
+		parts := taxonomy.PartAllNames
+		if limite2SciName {
+			parts = taxonomy.PartNames
+		}
+		if printRank || len(ranks) > 0 {
+			parts |= taxonomy.PartRanks | taxonomy.PartMerged
+		}
+		if useContext {
+			parts |= taxonomy.PartNodes | taxonomy.PartNames | taxonomy.PartDelNodes | taxonomy.PartMerged
+		}
+		if printSciName {
+			parts |= taxonomy.PartNames
+		}
+		if needNameClass {
+			parts |= taxonomy.PartMerged
+		}
+		taxdb := loadTaxonomy(&config, parts)
+
+		m := taxdb.NameMap(limite2SciName)
+
+		var within []uint32
+		for _, id := range withinInt {
+			taxid, status := taxdb.Resolve(uint32(id))
+			switch status {
+			case taxonomy.Merged:
+				log.Warningf("taxid %d (--within) was merged into %d", id, taxid)
+			case taxonomy.Deleted, taxonomy.NotFound:
+				checkError(fmt.Errorf("taxid %d (--within) not found", id))
+			}
+			within = append(within, taxid)
+		}
 
-		go func() {
+		// for querying taxid with the name of its parent
+		var resolver *taxonomy.NameResolver
+		if parentFlag != nil {
 			if config.Verbose {
-				log.Infof("parsing names file: %s", config.NamesFile)
+				log.Infof("creating links: child name -> parent name -> taxid")
 			}
-			m = getTaxonName2Taxids(config.NamesFile, limite2SciName)
+			resolver, err = taxdb.NewNameResolver()
+			checkError(err)
 			if config.Verbose {
-				log.Infof("%d names parsed", len(m))
+				log.Infof("created links: child name -> parent name -> taxid")
 			}
+		}
 
-			if fuzzy {
-				if config.Verbose {
-					log.Infof("creating indexing for name searching ...")
-				}
+		// isDescendant checks if a TaxId is a descendant of an ancestor.
+		isDescendant := func(taxid, ancestor uint32) bool {
+			return taxid != ancestor && taxdb.LCA(taxid, ancestor) == ancestor
+		}
 
-				names := make([]string, len(m))
-				i := 0
-				for n := range m {
-					names[i] = n
-					i++
+		// hasAncestorName checks if one of the ancestors of a TaxId has the name.
+		hasAncestorName := func(taxid uint32, pname string) bool {
+			names := taxdb.LineageNames(taxid)
+			if len(names) == 0 {
+				return false
+			}
+			for _, name := range names[:len(names)-1] {
+				if strings.EqualFold(name, pname) {
+					return true
 				}
-				dict = dictionary.NewInMemoryDictionary(names)
+			}
+			return false
+		}
 
-				indexDescription := suggest.IndexDescription{
-					Name:      "taxonkit",
-					NGramSize: 3,
-					Wrap:      [2]string{"$", "$"},
-					Pad:       "$",
-					Alphabet:  []string{"english", "$"},
+		// fitContext checks if a TaxId fits the expected ranks and subtrees.
+		fitContext := func(taxid uint32) bool {
+			if len(ranks) > 0 {
+				if _, ok := ranks[strings.ToLower(taxdb.Rank(taxid))]; !ok {
+					return false
+				}
+			}
+			if len(within) > 0 {
+				var ok bool
+				for _, sub := range within {
+					if taxid == sub || isDescendant(taxid, sub) {
+						ok = true
+						break
+					}
+				}
+				if !ok {
+					return false
 				}
+			}
+			return true
+		}
 
-				builder, err := suggest.NewRAMBuilder(dict, indexDescription)
-				checkError(err)
+		// disambiguate chooses TaxIds of a name fitting its context.
+		disambiguate := func(name string, parent string, taxids []uint32) ([]uint32, string) {
+			if len(taxids) == 0 {
+				return nil, "not_found"
+			}
 
-				service = suggest.NewService()
-				if err := service.AddIndex(indexDescription.Name, dict, builder); err != nil {
-					checkError(err)
+			candidates := taxids
+			if parent != "" {
+				candidates = make([]uint32, 0, len(taxids))
+				if id, err := strconv.Atoi(parent); err == nil { // TaxId of an ancestor
+					ptaxid, _ := taxdb.Resolve(uint32(id))
+					for _, taxid := range taxids {
+						if isDescendant(taxid, ptaxid) {
+							candidates = append(candidates, taxid)
+						}
+					}
+				} else if taxid, ambiguous, ok := resolver.TaxIdWithParent(name, parent); ok { // name of the direct parent
+					if len(ambiguous) > 0 {
+						candidates = append(candidates, ambiguous...)
+					} else {
+						candidates = append(candidates, taxid)
+					}
+				} else { // name of an ancestor
+					for _, taxid := range taxids {
+						if hasAncestorName(taxid, parent) {
+							candidates = append(candidates, taxid)
+						}
+					}
 				}
+			}
 
-				if config.Verbose {
-					log.Infof(`indexing finished`)
+			fitted := make([]uint32, 0, len(candidates))
+			for _, taxid := range candidates {
+				if fitContext(taxid) {
+					fitted = append(fitted, taxid)
 				}
 			}
 
-			wg.Done()
-		}()
+			switch {
+			case len(fitted) == 0:
+				return nil, "unmatched"
+			case len(fitted) > 1:
+				return fitted, "ambiguous"
+			case len(taxids) == 1:
+				return fitted, "unique"
+			default:
+				return fitted, "resolved"
+			}
+		}
 
-		var ranks map[uint32]string
+		var service *suggest.Service
 
-		if printRank {
-			wg.Add(1)
-			go func() {
-				if config.Verbose {
-					log.Infof("parsing nodes file: %s", config.NodesFile)
-				}
-				ranks = getRanks(config.NodesFile)
-				if config.Verbose {
-					log.Infof("%d nodes parsed", len(ranks))
-				}
-				wg.Done()
-			}()
-		}
+		if fuzzy {
+			if config.Verbose {
+				log.Infof("creating indexing for name searching ...")
+			}
+
+			service, err = newNameSuggester(m)
+			checkError(err)
 
-		wg.Wait()
+			if config.Verbose {
+				log.Infof(`indexing finished`)
+			}
+		}
 
 		// ----------------------------------------------------------
 
 		type line2taxids struct {
-			line   string
-			taxids []uint32
+			line       string
+			taxids     []uint32
+			resolution interface{} // only used with the context
+			classes    []string    // matched name classes of TaxIds
+			normalized interface{} // the normalized query, only used with --normalize
+			expanded   []string    // expanded names of TaxIds for an ambiguous abbreviated genus name
+		}
+
+		// nameClass returns the class of the first matched name of a TaxId.
+		nameClass := func(taxid uint32, names []string) string {
+			if limite2SciName {
+				return taxonomy.ScientificName
+			}
+			var class string
+			for _, name := range names {
+				if class = taxdb.NameClassOf(taxid, name); class != "" {
+					return class
+				}
+			}
+			return class
 		}
 
 		fn := func(line string) (interface{}, bool, error) {
@@ -157,60 +358,198 @@ Attention:
 			if len(data) < field+1 {
 				field = len(data) - 1
 			}
+			if parentFlag != nil && len(data) < pfield+1 {
+				return nil, false, fmt.Errorf("parent-field (%d) out of range (%d):%s", pfield+1, len(data), line)
+			}
+			query := data[field]
+			var normalized interface{}
+
 			var taxids []uint32
+			var names []string // matched names
 			if !fuzzy {
-				taxids = m[strings.ToLower(data[field])]
+				taxids = m[strings.ToLower(query)]
+				if len(taxids) == 0 && normalizer != nil {
+					variants := normalizer.Variants(query)
+					query = variants[len(variants)-1]
+					for _, v := range variants[1:] {
+						if taxids = m[strings.ToLower(v)]; len(taxids) > 0 {
+							query = v
+							break
+						}
+					}
+				}
+				names = []string{query}
+				if len(taxids) == 0 && expandGenus {
+					switch expanded := taxdb.ExpandGenusAbbreviation(query); len(expanded) {
+					case 0:
+					case 1:
+						if taxids = m[strings.ToLower(expanded[0])]; len(taxids) > 0 {
+							query = expanded[0]
+							names = expanded
+						}
+					default:
+						log.Warningf(`ambiguous abbreviated genus name "%s": %s`, query, strings.Join(expanded, ", "))
+						for _, name := range expanded {
+							taxids = append(taxids, m[strings.ToLower(name)]...)
+						}
+						names = expanded
+					}
+				}
 			} else {
-				searchConf, err := suggest.NewSearchConfig(data[field], fuzzyTopN, metric.CosineMetric(), 0.7)
-				checkError(err)
-				result, err := service.Suggest("taxonkit", searchConf)
+				if normalizer != nil {
+					variants := normalizer.Variants(query)
+					query = variants[len(variants)-1]
+				}
+				var err error
+				names, err = suggestNames(service, query, fuzzyTopN)
 				checkError(err)
-				taxids = make([]uint32, 0, 8)
-				for _, item := range result {
-					taxids = append(taxids, m[strings.ToLower(item.Value)]...)
+				for _, name := range names {
+					taxids = append(taxids, m[strings.ToLower(name)]...)
+				}
+			}
+			if normalizer != nil {
+				normalized = query
+			}
+
+			if preferSciName && len(taxids) > 1 {
+				sciTaxids := make([]uint32, 0, len(taxids))
+				for _, taxid := range taxids {
+					if nameClass(taxid, names) == taxonomy.ScientificName {
+						sciTaxids = append(sciTaxids, taxid)
+					}
+				}
+				if len(sciTaxids) > 0 {
+					taxids = sciTaxids
+				}
+			}
+
+			var resolution interface{}
+			if useContext {
+				var parent string
+				if parentFlag != nil {
+					parent = strings.TrimSpace(data[pfield])
+				}
+				taxids, resolution = disambiguate(query, parent, taxids)
+			}
+
+			var classes []string
+			if printNameClass {
+				classes = make([]string, len(taxids))
+				for i, taxid := range taxids {
+					classes[i] = nameClass(taxid, names)
+				}
+			}
+
+			var expanded []string
+			if !fuzzy && len(names) > 1 { // an ambiguous abbreviated genus name
+				expanded = make([]string, len(taxids))
+				for i, taxid := range taxids {
+					for _, name := range names {
+						if slices.Contains(m[strings.ToLower(name)], taxid) {
+							expanded[i] = name
+							break
+						}
+					}
+				}
+			}
+
+			return line2taxids{line, taxids, resolution, classes, normalized, expanded}, true, nil
+		}
+
+		rw := newRecordWriter(outfh, config)
+
+		fields := make([]outField, 0, 6)
+		// write writes a record of the i-th TaxId, i is -1 for names not found
+		write := func(l2t line2taxids, i int) {
+			var taxid, rank, class, sciName interface{}
+			normalized := l2t.normalized
+			if i >= 0 {
+				if l2t.expanded != nil {
+					normalized = l2t.expanded[i]
+				}
+				taxid = l2t.taxids[i]
+				if printRank {
+					rank = taxdb.Rank(l2t.taxids[i])
+				}
+				if printNameClass {
+					class = l2t.classes[i]
+				}
+				if printSciName {
+					sciName = taxdb.Name(l2t.taxids[i])
 				}
 			}
 
-			return line2taxids{line, taxids}, true, nil
+			fields = fields[:0]
+			fields = append(fields,
+				outField{Value: outInput(l2t.line)},
+				outField{Key: "taxid", Value: taxid},
+			)
+			if printRank {
+				fields = append(fields, outField{Key: "rank", Value: rank})
+			}
+			if printNameClass {
+				fields = append(fields, outField{Key: "name_class", Value: class})
+			}
+			if printSciName {
+				fields = append(fields, outField{Key: "sci_name", Value: sciName})
+			}
+			if useContext {
+				fields = append(fields, outField{Key: "resolution", Value: l2t.resolution})
+			}
+			if normalizer != nil {
+				fields = append(fields, outField{Key: "normalized_name", Value: normalized})
+			}
+			rw.Write(fields...)
+		}
+
+		columns := []string{"taxid"}
+		if printRank {
+			columns = append(columns, "rank")
+		}
+		if printNameClass {
+			columns = append(columns, "name_class")
+		}
+		if printSciName {
+			columns = append(columns, "sci_name")
+		}
+		if useContext {
+			columns = append(columns, "resolution")
+		}
+		if normalizer != nil {
+			columns = append(columns, "normalized_name")
 		}
 
-		var taxid uint32
+		var headerWritten bool
 		for _, file := range files {
-			reader, err := breader.NewBufferedReader(file, config.Threads, 10, fn)
+			chunks, err := readLines(file, hasHeader, func(header string) {
+				field = fieldFlag.Resolve(strings.Split(header, "\t"))
+				if parentFlag != nil {
+					pfield = parentFlag.Resolve(strings.Split(header, "\t"))
+				}
+				if !headerWritten && header != "" {
+					rw.WriteHeader(header, columns...)
+					headerWritten = true
+				}
+			}, config.Threads, 10, fn)
 			checkError(err)
 
 			var l2t line2taxids
 			var data interface{}
-			for chunk := range reader.Ch {
+			for chunk := range chunks {
 				checkError(chunk.Err)
 
 				for _, data = range chunk.Data {
 					l2t = data.(line2taxids)
 					if len(l2t.taxids) == 0 {
-						if printRank {
-							outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\n", l2t.line, "", ""))
-						} else {
-							outfh.WriteString(fmt.Sprintf("%s\t%s\n", l2t.line, ""))
-						}
-						if config.LineBuffered {
-							outfh.Flush()
-						}
-
+						write(l2t, -1)
 						continue
 					}
 
 					if len(l2t.taxids) > 1 {
 						log.Warningf("multiple TaxIds found for '%s'", l2t.line)
 					}
-					for _, taxid = range l2t.taxids {
-						if printRank {
-							outfh.WriteString(fmt.Sprintf("%s\t%d\t%s\n", l2t.line, taxid, ranks[taxid]))
-						} else {
-							outfh.WriteString(fmt.Sprintf("%s\t%d\n", l2t.line, taxid))
-						}
-						if config.LineBuffered {
-							outfh.Flush()
-						}
+					for i := range l2t.taxids {
+						write(l2t, i)
 					}
 				}
 			}
@@ -218,11 +557,88 @@ Attention:
 	},
 }
 
+// nameNormStepGenus is the step of expanding abbreviated genus names,
+// which is done with names in the taxonomy, after steps in taxonomy.NameNormSteps.
+const nameNormStepGenus = "genus"
+
+// nameNormSteps are all steps of name normalization in name2taxid.
+var nameNormSteps = append(slices.Clone(taxonomy.NameNormSteps), nameNormStepGenus)
+
 func init() {
 	RootCmd.AddCommand(name2taxidCmd)
 	name2taxidCmd.Flags().BoolP("show-rank", "r", false, `show rank`)
-	name2taxidCmd.Flags().IntP("name-field", "i", 1, "field index of name. data should be tab-separated")
+	name2taxidCmd.Flags().StringP("name-field", "i", "1", "field index of name, or the column name with -H/--header. data should be tab-separated")
 	name2taxidCmd.Flags().BoolP("sci-name", "s", false, "only searching scientific names")
 	name2taxidCmd.Flags().BoolP("fuzzy", "f", false, "allow fuzzy match")
 	name2taxidCmd.Flags().IntP("fuzzy-top-n", "n", 1, "choose top n matches in fuzzy search")
+	name2taxidCmd.Flags().BoolP("show-name-class", "c", false, `show the name class of the matched name, e.g., "scientific name", "synonym"`)
+	name2taxidCmd.Flags().BoolP("show-sci-name", "N", false, `show the current scientific name of the TaxId`)
+	name2taxidCmd.Flags().BoolP("prefer-sci-name", "P", false, `only keep TaxIds matched by scientific names if a name is also a synonym or common name of other TaxIds`)
+	name2taxidCmd.Flags().BoolP("normalize", "", false, "normalize names not found and search again, see details above")
+	name2taxidCmd.Flags().StringSliceP("normalize-steps", "", nameNormSteps, "steps of name normalization, in a fixed order")
+	name2taxidCmd.Flags().StringP("parent-field", "p", "", "field index of names or TaxIds of parents (or any ancestors) for resolving homonyms, or the column name with -H/--header")
+	name2taxidCmd.Flags().StringSliceP("rank", "", []string{}, "expected ranks of taxa for resolving homonyms, e.g., --rank genus")
+	name2taxidCmd.Flags().StringP("within", "", "", "comma-separated TaxIds of subtrees which taxa belong to, for resolving homonyms, e.g., --within 33208")
+
+	addHeaderFlag(name2taxidCmd)
+}
+
+// newNameSuggester creates an index of names for fuzzy searching.
+func newNameSuggester(m map[string][]uint32) (*suggest.Service, error) {
+	names := make([]string, len(m))
+	i := 0
+	for n := range m {
+		names[i] = n
+		i++
+	}
+	dict := dictionary.NewInMemoryDictionary(names)
+
+	indexDescription := suggest.IndexDescription{
+		Name:      "taxonkit",
+		NGramSize: 3,
+		Wrap:      [2]string{"$", "$"},
+		Pad:       "$",
+		Alphabet:  []string{"english", "$"},
+	}
+
+	builder, err := suggest.NewRAMBuilder(dict, indexDescription)
+	if err != nil {
+		return nil, err
+	}
+
+	service := suggest.NewService()
+	if err = service.AddIndex(indexDescription.Name, dict, builder); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+// suggestTaxIds returns TaxIds of the top n names similar to the query.
+func suggestTaxIds(service *suggest.Service, m map[string][]uint32, name string, topN int) ([]uint32, error) {
+	names, err := suggestNames(service, name, topN)
+	if err != nil {
+		return nil, err
+	}
+	taxids := make([]uint32, 0, 8)
+	for _, name := range names {
+		taxids = append(taxids, m[strings.ToLower(name)]...)
+	}
+	return taxids, nil
+}
+
+// suggestNames returns the top n names similar to the query.
+func suggestNames(service *suggest.Service, name string, topN int) ([]string, error) {
+	searchConf, err := suggest.NewSearchConfig(name, topN, metric.CosineMetric(), 0.7)
+	if err != nil {
+		return nil, err
+	}
+	result, err := service.Suggest("taxonkit", searchConf)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(result))
+	for i, item := range result {
+		names[i] = item.Value
+	}
+	return names, nil
 }
@@ -26,10 +26,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 
-	"github.com/shenwei356/bio/taxdump"
-	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 	"github.com/twotwotwo/sorts"
@@ -46,6 +44,8 @@ Input format:
   2. At least two columns needed:
      a) TaxId of a taxon.
      b) Abundance (could be percentage, automatically detected or use -p/--percentage).
+  3. With -H/--header, the first line is treated as a header line and skipped,
+     and the columns can be given by names, e.g., -H -i taxid -a abundance.
 
 Attention:
   0. If some TaxIds are parents of others, please switch on -S/--no-sum-up to disable
@@ -54,15 +54,29 @@ Attention:
      the abundances will be summed up.
   2. Some TaxIds may be deleted in current taxonomy version,
      the abundances can be optionally recomputed with the flag -R/--recompute-abd.
+  3. Merged, deleted and unknown TaxIds are handled according to the global
+     flags --on-merged, --on-deleted and --on-unknown. Kept deleted and unknown
+     TaxIds have no lineages, so they are not counted in the profile, the same
+     as dropped ones. "--on-merged keep" is not supported, as the taxa of old
+     TaxIds can not be placed in the profile, please use "replace" or "drop".
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
+		if config.TaxIdPolicy.OnMerged == actionKeep {
+			checkError(fmt.Errorf(`"--on-merged keep" is not supported by profile2cami, please use "replace" or "drop"`))
+		}
 
 		sampleID := getFlagString(cmd, "sample-id")
 		taxonomyID := getFlagString(cmd, "taxonomy-id")
-		fieldTaxid := getFlagPositiveInt(cmd, "taxid-field") - 1
-		fieldAbd := getFlagPositiveInt(cmd, "abundance-field") - 1
+		if taxonomyID == "" {
+			taxonomyID = getTaxonomyVersion(config).String()
+		}
+		fieldTaxidFlag := getFlagField(cmd, "taxid-field", false)
+		fieldAbdFlag := getFlagField(cmd, "abundance-field", false)
+		fieldTaxid := fieldTaxidFlag.index
+		fieldAbd := fieldAbdFlag.index
+		hasHeader := getFlagBool(cmd, "header")
 		keepZero := getFlagBool(cmd, "keep-zero")
 		usePercentage := getFlagBool(cmd, "percentage")
 		recomputeAbd := getFlagBool(cmd, "recompute-abd")
@@ -70,11 +84,6 @@ Attention:
 
 		showRanks := getFlagStringSlice(cmd, "show-rank")
 
-		maxField := fieldTaxid + 1
-		if fieldAbd > fieldTaxid {
-			maxField = fieldAbd + 1
-		}
-
 		files := getFileList(args)
 
 		if len(files) > 1 {
@@ -89,73 +98,7 @@ Attention:
 
 		var err error
 
-		var taxdb *taxdump.Taxonomy
-
-		if config.Verbose {
-			log.Infof("loading Taxonomy from: %s", config.DataDir)
-		}
-
-		taxdb, err = taxdump.NewTaxonomyWithRankFromNCBI(config.NodesFile)
-		if err != nil {
-			checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
-		}
-
-		if config.Verbose {
-			log.Infof("  %d nodes in %d ranks loaded", len(taxdb.Nodes), len(taxdb.Ranks))
-		}
-
-		var existed bool
-
-		var wg sync.WaitGroup
-		wg.Add(3)
-
-		go func() {
-			defer wg.Done()
-			err = taxdb.LoadNamesFromNCBI(config.NamesFile)
-			if err != nil {
-				checkError(fmt.Errorf("err on loading Taxonomy names: %s", err))
-			}
-
-			if config.Verbose {
-				log.Infof("  %d names loaded", len(taxdb.Names))
-			}
-		}()
-
-		go func() {
-			defer wg.Done()
-			existed, err = pathutil.Exists(config.DelNodesFile)
-			if err != nil {
-				checkError(fmt.Errorf("err on checking file delnodes.dmp: %s", err))
-			}
-			if existed {
-				err = taxdb.LoadDeletedNodesFromNCBI(config.DelNodesFile)
-				if err != nil {
-					checkError(fmt.Errorf("err on loading Taxonomy nodes: %s", err))
-				}
-			}
-			if config.Verbose {
-				log.Infof("  %d deleted nodes loaded", len(taxdb.DelNodes))
-			}
-		}()
-
-		go func() {
-			defer wg.Done()
-			existed, err = pathutil.Exists(config.MergedFile)
-			if err != nil {
-				checkError(fmt.Errorf("err on checking file merged.dmp: %s", err))
-			}
-			if existed {
-				err = taxdb.LoadMergedNodesFromNCBI(config.MergedFile)
-				if err != nil {
-					checkError(fmt.Errorf("err on loading Taxonomy merged nodes: %s", err))
-				}
-			}
-			if config.Verbose {
-				log.Infof("  %d merged nodes loaded", len(taxdb.MergeNodes))
-			}
-		}()
-
-		wg.Wait()
+		taxdb := loadTaxonomy(&config, taxonomy.PartNodes|taxonomy.PartDelNodes|taxonomy.PartMerged|taxonomy.PartRanks|taxonomy.PartNames)
 		taxdb.CacheLCA()
 
 		// ----------------------------------------------------------------
@@ -171,7 +114,25 @@ Attention:
 
 		// ----------------------
 
-		targets := make([]*Target, 0, 512)
+		targets := make([]*taxonomy.Target, 0, 512)
+
+		file := files[0]
+
+		fh, err := xopen.Ropen(file)
+		checkError(err)
+
+		scanner := bufio.NewScanner(fh)
+
+		if hasHeader && scanner.Scan() {
+			columns := strings.Split(strings.TrimRight(scanner.Text(), "\r\n"), "\t")
+			fieldTaxid = fieldTaxidFlag.Resolve(columns)
+			fieldAbd = fieldAbdFlag.Resolve(columns)
+		}
+
+		maxField := fieldTaxid + 1
+		if fieldAbd > fieldTaxid {
+			maxField = fieldAbd + 1
+		}
 
 		n := maxField + 1
 		items := make([]string, n)
@@ -181,13 +142,6 @@ Attention:
 		var abd float64
 		var sum float64
 
-		file := files[0]
-
-		fh, err := xopen.Ropen(file)
-		checkError(err)
-
-		scanner := bufio.NewScanner(fh)
-
 		for scanner.Scan() {
 			stringSplitN(scanner.Text(), "\t", n, &items)
 			if len(items) < maxField {
@@ -209,7 +163,7 @@ Attention:
 				continue
 			}
 
-			targets = append(targets, &Target{Taxid: taxid, Abundance: abd})
+			targets = append(targets, &taxonomy.Target{Taxid: taxid, Abundance: abd})
 			sum += abd
 		}
 
@@ -227,16 +181,19 @@ Attention:
 			log.Infof("%d taxons given, sum of abundance : %.6f", len(targets), sum)
 		}
 
-		sorts.Quicksort(Targets(targets))
+		sorts.Quicksort(taxonomy.Targets(targets))
 
-		// add taxonomy info
+		// add taxonomy info.
+		// kept or dropped deleted and unknown TaxIds, and dropped merged TaxIds have no lineages,
+		// and they are not counted in the profile.
 		var hasDeleted, ok bool
+		var action taxIdAction
 		for _, target := range targets {
-			ok = target.AddTaxonomy(taxdb, showRanksMap, target.Taxid)
-			if !ok {
-				log.Warningf("taxid is deleted in current taxonomy version: %d", target.Taxid)
+			if _, _, action = config.TaxIdPolicy.Resolve(taxdb, target.Taxid); action != actionUse {
 				hasDeleted = true
+				continue
 			}
+			target.AddTaxonomy(taxdb, showRanksMap, target.Taxid)
 		}
 		if hasDeleted {
 			if recomputeAbd {
@@ -249,77 +206,17 @@ Attention:
 		}
 
 		// check merged
-		targets2 := make([]*Target, 0, len(targets))
-		taxid2i := make(map[uint32]int, len(targets))
-		var j int
-		for _, target := range targets {
-			if j, ok = taxid2i[target.Taxid]; ok {
-				targets2[j].Abundance += target.Abundance
-			} else {
-				taxid2i[target.Taxid] = len(targets2)
-				targets2 = append(targets2, target)
-			}
-		}
-		targets = targets2
+		targets = taxonomy.MergeTargets(targets)
 
 		if recomputeAbd {
-			sum = 0
-			var lca uint32
-			var isAChildOfSomeOne bool
-			for i, target := range targets {
-				// fmt.Printf("i: %d, t: %d, a: %f\n", i, target.Taxid, target.Abundance)
-				if len(target.CompleteLineageTaxids) == 0 {
-					continue
-				}
-				isAChildOfSomeOne = false
-				for j, target2 := range targets {
-					if i == j {
-						continue
-					}
-
-					lca = taxdb.LCA(target.Taxid, target2.Taxid)
-					if lca != 0 && lca == target2.Taxid {
-						isAChildOfSomeOne = true
-						break
-					}
-				}
-
-				// fmt.Printf("i: %d, t: %d, is: %v\n", i, target.Taxid, isAChildOfSomeOne)
-				if !isAChildOfSomeOne {
-					sum += target.Abundance
-				}
-			}
-
-			// fmt.Printf("sum: %f\n", sum)
-
-			for i, target := range targets {
-				if len(target.CompleteLineageTaxids) == 0 {
-					continue
-				}
-				isAChildOfSomeOne = false
-				for j, target2 := range targets {
-					if i == j {
-						continue
-					}
-
-					lca = taxdb.LCA(target.Taxid, target2.Taxid)
-					if lca != 0 && lca == target2.Taxid {
-						isAChildOfSomeOne = true
-						break
-					}
-				}
-
-				if !isAChildOfSomeOne {
-					target.Abundance = target.Abundance / sum
-				}
-			}
+			taxdb.RecomputeAbundance(targets)
 		}
 
 		// ----------------------
 
-		profile := generateProfile(taxdb, targets, !noSumUp)
+		profile := taxdb.GenerateProfile(targets, !noSumUp)
 
-		nodes := make([]*ProfileNode, 0, len(profile))
+		nodes := make([]*taxonomy.ProfileNode, 0, len(profile))
 		for _, node := range profile {
 			nodes = append(nodes, node)
 		}
@@ -395,12 +292,14 @@ func init() {
 	RootCmd.AddCommand(profile2camiCmd)
 
 	profile2camiCmd.Flags().StringP("sample-id", "s", "", `sample ID in result file`)
-	profile2camiCmd.Flags().StringP("taxonomy-id", "t", "", `taxonomy ID in result file`)
-	profile2camiCmd.Flags().IntP("taxid-field", "i", 1, "field index of taxid. input data should be tab-separated")
-	profile2camiCmd.Flags().IntP("abundance-field", "a", 2, "field index of abundance. input data should be tab-separated")
+	profile2camiCmd.Flags().StringP("taxonomy-id", "t", "", `taxonomy ID in result file, default: the version computed from the taxonomy data, e.g., 2024-05-01_d2a38e12a1c3f6b0`)
+	profile2camiCmd.Flags().StringP("taxid-field", "i", "1", "field index of taxid, or the column name with -H/--header. input data should be tab-separated")
+	profile2camiCmd.Flags().StringP("abundance-field", "a", "2", "field index of abundance, or the column name with -H/--header. input data should be tab-separated")
 	profile2camiCmd.Flags().StringSliceP("show-rank", "r", []string{"superkingdom", "phylum", "class", "order", "family", "genus", "species", "strain"}, "only show TaxIds and names of these ranks")
 	profile2camiCmd.Flags().BoolP("keep-zero", "0", false, "keep taxons with abundance of zero")
 	profile2camiCmd.Flags().BoolP("percentage", "p", false, "abundance is in percentage")
 	profile2camiCmd.Flags().BoolP("recompute-abd", "R", false, "recompute abundance if some TaxIds are deleted in current taxonomy version")
 	profile2camiCmd.Flags().BoolP("no-sum-up", "S", false, "do not sum up abundance from child to parent TaxIds")
+
+	addHeaderFlag(profile2camiCmd)
 }
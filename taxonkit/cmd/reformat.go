@@ -24,8 +24,9 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/shenwei356/breader"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/stringutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -51,6 +52,9 @@ Input:
     Plese specify the lineage field with flag -i/--lineage-field (default 2).
     Or specify the TaxId field with flag -I/--taxid-field (default 0),
     which overrides -i/--lineage-field.
+  - With -H/--header, the first line is treated as a header line, which is
+    passed through with names of new columns appended, and fields can be
+    given by column names, e.g., -H -I taxid.
   - Supporting (gzipped) file or STDIN.
 
 Output:
@@ -105,22 +109,21 @@ Output format can contains some escape charactors like "\t".
 		fill := getFlagBool(cmd, "fill-miss-rank")
 		pseudoStrain := getFlagBool(cmd, "pseudo-strain")
 
-		taxIdField := getFlagNonNegativeInt(cmd, "taxid-field")
-		field := getFlagPositiveInt(cmd, "lineage-field")
+		taxIdFieldFlag := getFlagField(cmd, "taxid-field", true)
+		lineageFieldFlag := getFlagField(cmd, "lineage-field", false)
+		taxIdField := taxIdFieldFlag.index
+		field := lineageFieldFlag.index
 		outputAmbigous := getFlagBool(cmd, "output-ambiguous-result")
+		hasHeader := getFlagBool(cmd, "header")
 
 		var parsingTaxId bool
-		if taxIdField > 0 {
+		if taxIdFieldFlag.name != "" || taxIdField >= 0 {
 			if config.Verbose {
-				log.Infof("parsing TaxIds from field %d", taxIdField)
+				log.Infof("parsing TaxIds from field %s", getFlagString(cmd, "taxid-field"))
 			}
 			parsingTaxId = true
-			taxIdField--
-		} else if field > 0 {
-			if config.Verbose {
-				log.Infof("parsing complete lineages from field %d", field)
-			}
-			field--
+		} else if config.Verbose {
+			log.Infof("parsing complete lineages from field %s", getFlagString(cmd, "lineage-field"))
 		}
 
 		printLineageInTaxid := getFlagBool(cmd, "show-lineage-taxids")
@@ -200,13 +203,7 @@ Output format can contains some escape charactors like "\t".
 		// --------------------------------------------------------
 		// load data
 
-		var tree0 map[uint32]uint32
-		var ranks0 map[uint32]string
-		var names0 map[uint32]string
-		var delnodes0 map[uint32]struct{}
-		var merged0 map[uint32]uint32
-
-		tree0, ranks0, names0, delnodes0, merged0 = loadData(config, true, true)
+		taxdb := loadTaxonomy(&config, taxonomy.PartNodes|taxonomy.PartDelNodes|taxonomy.PartMerged|taxonomy.PartRanks|taxonomy.PartNames)
 
 		// for querying taxid from lineage
 		var name2parent2taxid map[string]map[string]uint32
@@ -214,7 +211,15 @@ Output format can contains some escape charactors like "\t".
 		var ambigous map[string][]uint32
 
 		if !parsingTaxId {
-			name2parent2taxid, name2taxids, ambigous = generateName2Parent2Taxid(config, tree0, names0)
+			if config.Verbose {
+				log.Infof("creating links: child name -> parent name -> taxid")
+			}
+			resolver, err := taxdb.NewNameResolver()
+			checkError(err)
+			name2parent2taxid, name2taxids, ambigous = resolver.Name2Parent2Taxid, resolver.Name2Taxids, resolver.Ambiguous
+			if config.Verbose {
+				log.Infof("created links: child name -> parent name -> taxid")
+			}
 		}
 
 		// --------------------------------------------------------
@@ -223,8 +228,12 @@ Output format can contains some escape charactors like "\t".
 			line      string
 			flineage  string
 			iflineage string
+			taxid     interface{} // nil for TaxIds or names not resolved
+			status    interface{}
 		}
 
+		rw := newRecordWriter(outfh, config)
+
 		unescape := stringutil.UnEscaper()
 
 		weightOfSpecies := symbol2weight["s"]
@@ -272,14 +281,14 @@ Output format can contains some escape charactors like "\t".
 				if err != nil || taxidInt < 0 {
 					// checkError(fmt.Errorf("invalid TaxId: %s", data[taxIdField]))
 					log.Warningf("invalid TaxId: %s", data[taxIdField])
-					return line2flineage{line, "", ""}, true, nil
+					return line2flineage{line: line}, true, nil
 				}
 				taxid = uint32(taxidInt)
 
 			} else { // query taxid by taxon names
 
 				if strings.Trim(data[field], " ") == "" { // empty, returns empty result
-					return line2flineage{line, "", ""}, true, nil
+					return line2flineage{line: line}, true, nil
 				}
 
 				// names
@@ -297,7 +306,7 @@ Output format can contains some escape charactors like "\t".
 						log.Warningf(`failed to query the TaxId of: %s. Possible reasons: `, data[field])
 						log.Warningf(`  1) the lineage were produced with different taxonomy data files, please re-run taxonkit lineage;`)
 						log.Warningf(`  2) some taxon names contain delimiter (%s), please re-run taxonkit lineage and taxonkit reformat with different flag value of -d, e.g., -d "/"`, delimiter)
-						return line2flineage{line, "", ""}, true, nil
+						return line2flineage{line: line}, true, nil
 					}
 
 					if len(*_taxids) == 1 { // found
@@ -311,7 +320,7 @@ Output format can contains some escape charactors like "\t".
 							strings.Join(tmp, ", "), data[field])
 
 						if !outputAmbigous {
-							return line2flineage{line, "", ""}, true, nil
+							return line2flineage{line: line}, true, nil
 						}
 					}
 
@@ -335,7 +344,7 @@ Output format can contains some escape charactors like "\t".
 							log.Warningf(`failed to query the TaxId of: %s. Possible reasons: `, data[field])
 							log.Warningf(`  1) the lineage were produced with different taxonomy data files, please re-run taxonkit lineage;`)
 							log.Warningf(`  2) some taxon names contain delimiter (%s), please re-run taxonkit lineage and taxonkit reformat with different flag value of -d, e.g., -d "/"`, delimiter)
-							return line2flineage{line, "", ""}, true, nil
+							return line2flineage{line: line}, true, nil
 						}
 
 						if len(*_taxids) == 1 { // found
@@ -349,7 +358,7 @@ Output format can contains some escape charactors like "\t".
 								strings.Join(tmp, ", "), data[field])
 
 							if !outputAmbigous {
-								return line2flineage{line, "", ""}, true, nil
+								return line2flineage{line: line}, true, nil
 							}
 						}
 					} else {
@@ -365,7 +374,7 @@ Output format can contains some escape charactors like "\t".
 								strings.Join(tmp, ", "), data[field])
 
 							if !outputAmbigous {
-								return line2flineage{line, "", ""}, true, nil
+								return line2flineage{line: line}, true, nil
 							}
 						}
 					}
@@ -375,12 +384,20 @@ Output format can contains some escape charactors like "\t".
 			// -----------------------------------------------
 			// query complete lineage with the taxid
 
-			names, ranks, taxids, ok = queryNamesRanksTaxids(tree0, ranks0, names0, delnodes0, merged0, taxid)
-			if !ok { // taxid not found
-				// return line2flineage{line, "", ""}, true, nil
-				return line2flineage{line, unescape(blankS), unescape(iblankS)}, true, nil
+			taxid, status, action := config.TaxIdPolicy.Resolve(taxdb, taxid)
+			switch action {
+			case actionDrop:
+				return nil, false, nil
+			case actionKeep:
+				return line2flineage{line, unescape(blankS), unescape(iblankS), nil, status.String()}, true, nil
 			}
 
+			lineage, err := taxdb.Lineage(taxid)
+			if err != nil {
+				return nil, false, err
+			}
+			names, ranks, taxids = lineage.Names, lineage.Ranks, lineage.TaxIds
+
 			sranks := poolStringsN16.Get().([]string)
 
 			srank2idx := make(map[string]int) // srank: index
@@ -549,38 +566,55 @@ Output format can contains some escape charactors like "\t".
 			}
 
 			// recycle
-			ranks = ranks[:0]
-			poolStringsN16.Put(ranks)
 			sranks = sranks[:0]
 			poolStringsN16.Put(sranks)
 
-			names = names[:0]
-			poolStringsN16.Put(names)
-			taxids = taxids[:0]
-			poolUint32N16.Put(taxids)
+			return line2flineage{line, unescape(flineage), unescape(iflineage), taxid, status.String()}, true, nil
+		}
 
-			return line2flineage{line, unescape(flineage), unescape(iflineage)}, true, nil
+		columns := []string{"lineage"}
+		if printLineageInTaxid {
+			columns = append(columns, "lineage_taxids")
 		}
 
+		var headerWritten bool
 		for _, file := range files {
-			reader, err := breader.NewBufferedReader(file, config.Threads, 64, fn)
+			chunks, err := readLines(file, hasHeader, func(header string) {
+				if parsingTaxId {
+					taxIdField = taxIdFieldFlag.Resolve(strings.Split(header, "\t"))
+				} else {
+					field = lineageFieldFlag.Resolve(strings.Split(header, "\t"))
+				}
+				if !headerWritten && header != "" {
+					rw.WriteHeader(header, columns...)
+					headerWritten = true
+				}
+			}, config.Threads, 64, fn)
 			checkError(err)
 
 			var l2s line2flineage
 			var data interface{}
-			for chunk := range reader.Ch {
+			for chunk := range chunks {
 				checkError(chunk.Err)
 
 				for _, data = range chunk.Data {
 					l2s = data.(line2flineage)
 
 					if printLineageInTaxid {
-						outfh.WriteString(l2s.line + "\t" + l2s.flineage + "\t" + l2s.iflineage + "\n")
+						rw.Write(
+							outField{Value: outInput(l2s.line)},
+							outField{Key: "taxid", Value: l2s.taxid, JSONOnly: true},
+							outField{Key: "status", Value: l2s.status, JSONOnly: true},
+							outField{Key: "lineage", Value: l2s.flineage},
+							outField{Key: "lineage_taxids", Value: l2s.iflineage},
+						)
 					} else {
-						outfh.WriteString(l2s.line + "\t" + l2s.flineage + "\n")
-					}
-					if config.LineBuffered {
-						outfh.Flush()
+						rw.Write(
+							outField{Value: outInput(l2s.line)},
+							outField{Key: "taxid", Value: l2s.taxid, JSONOnly: true},
+							outField{Key: "status", Value: l2s.status, JSONOnly: true},
+							outField{Key: "lineage", Value: l2s.flineage},
+						)
 					}
 				}
 			}
@@ -601,8 +635,8 @@ func init() {
 	flineageCmd.Flags().BoolP("fill-miss-rank", "F", false, "fill missing rank with lineage information of the next higher rank")
 	flineageCmd.Flags().BoolP("pseudo-strain", "S", false, `use the node with lowest rank as strain name, only if which rank is lower than "species" and not "subpecies" nor "strain". It affects {t}, {S}, {T}. This flag needs flag -F`)
 
-	flineageCmd.Flags().IntP("lineage-field", "i", 2, "field index of lineage. data should be tab-separated")
-	flineageCmd.Flags().IntP("taxid-field", "I", 0, "field index of taxid. input data should be tab-separated. it overrides -i/--lineage-field")
+	flineageCmd.Flags().StringP("lineage-field", "i", "2", "field index of lineage, or the column name with -H/--header. data should be tab-separated")
+	flineageCmd.Flags().StringP("taxid-field", "I", "0", "field index of taxid, or the column name with -H/--header. input data should be tab-separated. it overrides -i/--lineage-field")
 	flineageCmd.Flags().BoolP("show-lineage-taxids", "t", false, `show corresponding taxids of reformated lineage`)
 	flineageCmd.Flags().BoolP("output-ambiguous-result", "a", false, `output one of the ambigous result`)
 
@@ -624,4 +658,10 @@ func init() {
 	flineageCmd.Flags().StringP("prefix-T", "", "T__", `prefix for strain, used along with flag -P/--add-prefix`)
 
 	flineageCmd.Flags().BoolP("trim", "T", false, "do not fill or add prefix for missing rank lower than current rank")
+
+	addHeaderFlag(flineageCmd)
 }
+
+var poolStringsN16 = &sync.Pool{New: func() interface{} {
+	return make([]string, 0, 16)
+}}
@@ -22,13 +22,10 @@ package cmd
 
 import (
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 
-	"github.com/shenwei356/breader"
-	"github.com/shenwei356/util/stringutil"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -44,6 +41,13 @@ Input:
   - List of TaxIds, one record per line.
   - Or tab-delimited format.
     Please specify the TaxId field with flag -I/--taxid-field (default 1)
+  - With -H/--header, the first line is treated as a header line, which is
+    passed through with names of new columns appended, and the TaxId field
+    can be given by the column name, e.g., -H -I taxid.
+  - TaxIds in free text, e.g., "taxid 562" in Kraken output, can be
+    extracted with a regular expression via --taxid-regex, from the field
+    (-I/--taxid-field) or the whole line (-I 0). All matches are used,
+    and the matched TaxId is appended after the input line in the output.
   - Supporting (gzipped) file or STDIN.
 
 Output:
@@ -96,6 +100,9 @@ Differences from 'taxonkit reformat':
   - [format] support multiple ranks in one place holder, such as "{subspecies|strain}"
   - do not automatically add prefixes, but you can simply set them in the format
 
+The version of the taxonomy data can be recorded in a comment line before
+the output with --taxonomy-version.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -103,32 +110,26 @@ Differences from 'taxonkit reformat':
 		format := getFlagString(cmd, "format")
 		blank := getFlagString(cmd, "miss-rank-repl")
 		iblank := getFlagString(cmd, "miss-taxid-repl")
-		taxIdField := getFlagPositiveInt(cmd, "taxid-field")
+		reTaxId := getTaxIdRegex(cmd)
+		taxIdFieldFlag := getFlagField(cmd, "taxid-field", reTaxId != nil)
+		taxIdField := taxIdFieldFlag.index
+		hasHeader := getFlagBool(cmd, "header")
 		noRanks := getFlagStringSlice(cmd, "no-ranks")
 		trim := getFlagBool(cmd, "trim")
+		showVersion := getFlagBool(cmd, "taxonomy-version")
 
 		if config.Verbose {
-			log.Infof("parsing TaxIds from field %d", taxIdField)
-		}
-		taxIdField--
-
-		noRanksMap := make(map[string]interface{}, 8)
-		if len(noRanks) > 0 {
-			for _, rank := range noRanks {
-				noRanksMap[strings.ToLower(rank)] = struct{}{}
-			}
+			log.Infof("parsing TaxIds from field %s", getFlagString(cmd, "taxid-field"))
 		}
 
 		printLineageInTaxid := getFlagBool(cmd, "show-lineage-taxids")
 
-		// check format
-		if !reRankPlaceHolder2.MatchString(format) {
-			checkError(fmt.Errorf("placeholder of simplified rank not found in output format: %s", format))
-		}
-		matches := reRankPlaceHolder2.FindAllStringSubmatch(format, -1)
-		if len(matches) == 0 {
-			checkError(fmt.Errorf("no placeholder given %s", format))
-		}
+		formatter, err := taxonomy.NewLineageFormatter(format, noRanks)
+		checkError(err)
+		formatter.MissRankRepl = blank
+		formatter.MissTaxIdRepl = iblank
+		formatter.Trim = trim
+		formatter.TaxIds = printLineageInTaxid
 
 		files := getFileList(args)
 
@@ -140,16 +141,17 @@ Differences from 'taxonkit reformat':
 		checkError(err)
 		defer outfh.Close()
 
+		if showVersion {
+			if config.OutFormat == outJSONL {
+				checkError(fmt.Errorf("flag --taxonomy-version is not supported for --out-format jsonl"))
+			}
+			outfh.WriteString(getTaxonomyVersion(config).Header())
+		}
+
 		// --------------------------------------------------------
 		// load data
 
-		var tree0 map[uint32]uint32
-		var ranks0 map[uint32]string
-		var names0 map[uint32]string
-		var delnodes0 map[uint32]struct{}
-		var merged0 map[uint32]uint32
-
-		tree0, ranks0, names0, delnodes0, merged0 = loadData(config, true, true)
+		taxdb := loadTaxonomy(&config, taxonomy.PartNodes|taxonomy.PartDelNodes|taxonomy.PartMerged|taxonomy.PartRanks|taxonomy.PartNames)
 
 		// --------------------------------------------------------
 
@@ -157,14 +159,33 @@ Differences from 'taxonkit reformat':
 			line      string
 			flineage  string
 			iflineage string
+			matched   interface{} // TaxId extracted with --taxid-regex
+			taxid     interface{} // nil for invalid TaxIds
+			status    interface{}
 		}
 
-		unescape := stringutil.UnEscaper()
+		rw := newRecordWriter(outfh, config)
+
+		// reformat queries and reformats the lineage of a TaxId, it returns false for dropped TaxIds.
+		reformat := func(line string, taxid uint32) (line2flineage, bool, error) {
+			taxid, status, action := config.TaxIdPolicy.Resolve(taxdb, taxid)
+			switch action {
+			case actionDrop:
+				return line2flineage{}, false, nil
+			case actionKeep:
+				flineage, iflineage := formatter.Missing()
+				return line2flineage{line, flineage, iflineage, nil, nil, status.String()}, true, nil
+			}
+
+			lineage, err := taxdb.Lineage(taxid)
+			if err != nil {
+				return line2flineage{}, false, err
+			}
+
+			flineage, iflineage := formatter.Format(lineage)
 
-		blankS := format
-		iblankS := format
-		blankS = reRankPlaceHolder2.ReplaceAllString(blankS, blank)
-		iblankS = reRankPlaceHolder2.ReplaceAllString(iblankS, iblank)
+			return line2flineage{line, flineage, iflineage, nil, taxid, status.String()}, true, nil
+		}
 
 		fn := func(line string) (interface{}, bool, error) {
 			if len(line) == 0 || line[0] == '#' {
@@ -174,148 +195,102 @@ Differences from 'taxonkit reformat':
 			if line == "" {
 				return nil, false, nil
 			}
+
+			// one record for each TaxId extracted from the line
+			if reTaxId != nil {
+				ids := extractTaxIds(reTaxId, regexField(line, taxIdField))
+				if len(ids) == 0 {
+					return []line2flineage{{line: line}}, true, nil
+				}
+				l2ss := make([]line2flineage, 0, len(ids))
+				for _, id := range ids {
+					l2s, ok, err := reformat(line, id)
+					if err != nil {
+						return nil, false, err
+					}
+					if ok {
+						l2s.matched = id
+						l2ss = append(l2ss, l2s)
+					}
+				}
+				if len(l2ss) == 0 {
+					return nil, false, nil
+				}
+				return l2ss, true, nil
+			}
+
 			data := strings.Split(line, "\t")
 
 			if len(data) < taxIdField+1 {
 				return nil, false, fmt.Errorf("taxid-field (%d) out of range (%d):%s", taxIdField+1, len(data), line)
 			}
 
-			// -----------------------------------------------
-
-			var ok bool
-
-			var taxid uint32
-			var taxidInt int
-
-			var names []string
-			var ranks []string
-			var taxids []uint32
-
 			// -----------------------------------------------
 			// get the taxid
 
-			taxidInt, err = strconv.Atoi(data[taxIdField])
+			taxidInt, err := strconv.Atoi(data[taxIdField])
 			if err != nil || taxidInt < 0 {
-				// checkError(fmt.Errorf("invalid TaxId: %s", data[taxIdField]))
 				log.Warningf("invalid TaxId: %s", data[taxIdField])
-				return line2flineage{line, "", ""}, true, nil
+				return line2flineage{line: line}, true, nil
 			}
-			taxid = uint32(taxidInt)
-
-			// -----------------------------------------------
-			// query complete lineage with the taxid
 
-			names, ranks, taxids, ok = queryNamesRanksTaxids(tree0, ranks0, names0, delnodes0, merged0, taxid)
-			if !ok { // taxid not found
-				// return line2flineage{line, "", ""}, true, nil
-				return line2flineage{line, unescape(blankS), unescape(iblankS)}, true, nil
+			l2s, ok, err := reformat(line, uint32(taxidInt))
+			if err != nil || !ok {
+				return nil, false, err
 			}
+			return l2s, true, nil
+		}
 
-			rank2idx := poolRank2idx.Get().(*map[string]int)
-			clear(*rank2idx)
-			var meetKnownRanks bool
-			var lastKnownRank string
-			for i, rank := range ranks {
-				rank = strings.ToLower(rank)
+		columns := make([]string, 0, 3)
+		if reTaxId != nil {
+			columns = append(columns, "matched_taxid")
+		}
+		columns = append(columns, "lineage")
+		if printLineageInTaxid {
+			columns = append(columns, "lineage_taxids")
+		}
 
-				if _, ok = noRanksMap[rank]; ok {
-					if meetKnownRanks {
-						(*rank2idx)[rank] = i
-					}
-				} else {
-					meetKnownRanks = true
-					(*rank2idx)[rank] = i
-					lastKnownRank = rank
-				}
+		fields := make([]outField, 0, 6)
+		write := func(l2s line2flineage) {
+			fields = fields[:0]
+			fields = append(fields,
+				outField{Value: outInput(l2s.line)},
+				outField{Key: "taxid", Value: l2s.taxid, JSONOnly: true},
+				outField{Key: "status", Value: l2s.status, JSONOnly: true},
+			)
+			if reTaxId != nil {
+				fields = append(fields, outField{Key: "matched_taxid", Value: l2s.matched})
 			}
-
-			flineage := format
-			var iflineage string
+			fields = append(fields, outField{Key: "lineage", Value: l2s.flineage})
 			if printLineageInTaxid {
-				iflineage = format
-			}
-
-			var i int
-			var _matches []string
-			var _match string
-			var matched bool
-			var foundLastKnownRank bool
-			var repl, irepl string
-			for _, match := range matches {
-				_matches = strings.Split(match[1], "|")
-
-				matched = false
-				for _, _match = range _matches {
-					if _match == "" {
-						continue
-					}
-
-					_match = strings.ToLower(_match)
-					if i, ok = (*rank2idx)[_match]; !ok {
-						continue
-					}
-
-					flineage = strings.ReplaceAll(flineage, match[0], names[i])
-					if printLineageInTaxid {
-						iflineage = strings.ReplaceAll(iflineage, match[0], strconv.Itoa(int(taxids[i])))
-					}
-					matched = true
-
-					if _match == lastKnownRank {
-						foundLastKnownRank = true
-					}
-					break
-				}
-
-				if !matched {
-					if !foundLastKnownRank {
-						repl, irepl = blank, iblank
-					} else if trim {
-						repl, irepl = "", ""
-					} else {
-						repl, irepl = blank, iblank
-					}
-
-					flineage = strings.ReplaceAll(flineage, match[0], repl)
-					if printLineageInTaxid {
-						iflineage = strings.ReplaceAll(iflineage, match[0], irepl)
-					}
-				}
+				fields = append(fields, outField{Key: "lineage_taxids", Value: l2s.iflineage})
 			}
-
-			// recycle
-			ranks = ranks[:0]
-			poolStringsN16.Put(ranks)
-
-			names = names[:0]
-			poolStringsN16.Put(names)
-			taxids = taxids[:0]
-			poolUint32N16.Put(taxids)
-
-			poolRank2idx.Put(rank2idx)
-
-			return line2flineage{line, unescape(flineage), unescape(iflineage)}, true, nil
+			rw.Write(fields...)
 		}
 
+		var headerWritten bool
 		for _, file := range files {
-			reader, err := breader.NewBufferedReader(file, config.Threads, 64, fn)
+			chunks, err := readLines(file, hasHeader, func(header string) {
+				taxIdField = taxIdFieldFlag.Resolve(strings.Split(header, "\t"))
+				if !headerWritten && header != "" {
+					rw.WriteHeader(header, columns...)
+					headerWritten = true
+				}
+			}, config.Threads, 64, fn)
 			checkError(err)
 
-			var l2s line2flineage
 			var data interface{}
-			for chunk := range reader.Ch {
+			for chunk := range chunks {
 				checkError(chunk.Err)
 
 				for _, data = range chunk.Data {
-					l2s = data.(line2flineage)
-
-					if printLineageInTaxid {
-						outfh.WriteString(l2s.line + "\t" + l2s.flineage + "\t" + l2s.iflineage + "\n")
-					} else {
-						outfh.WriteString(l2s.line + "\t" + l2s.flineage + "\n")
-					}
-					if config.LineBuffered {
-						outfh.Flush()
+					switch l2s := data.(type) {
+					case line2flineage:
+						write(l2s)
+					case []line2flineage:
+						for _, r := range l2s {
+							write(r)
+						}
 					}
 				}
 			}
@@ -331,16 +306,13 @@ func init() {
 	reformat2Cmd.Flags().StringP("miss-taxid-repl", "R", "", `replacement string for missing taxid`)
 	reformat2Cmd.Flags().BoolP("trim", "T", false, "do not replace missing ranks lower than the rank of the current node")
 
-	reformat2Cmd.Flags().IntP("taxid-field", "I", 1, "field index of taxid. input data should be tab-separated. it overrides -i/--lineage-field")
+	reformat2Cmd.Flags().StringP("taxid-field", "I", "1", "field index of taxid, or the column name with -H/--header. input data should be tab-separated")
+	reformat2Cmd.Flags().StringP("taxid-regex", "", "", `regular expression with a capture group for extracting TaxIds from the field (-I/--taxid-field), `+
+		`or the whole line with "-I 0", e.g., "taxid=(\d+)". a record is output for each match, with the matched TaxId appended after the input line`)
 	reformat2Cmd.Flags().BoolP("show-lineage-taxids", "t", false, `show corresponding taxids of reformated lineage`)
 
+	reformat2Cmd.Flags().BoolP("taxonomy-version", "", false, "print the taxonomy version in a comment line before the output")
 	reformat2Cmd.Flags().StringSliceP("no-ranks", "B", []string{"no rank", "clade"}, `rank names of no-rank. A lineage might have many "no rank" ranks, we only keep the last one below known ranks`)
 
+	addHeaderFlag(reformat2Cmd)
 }
-
-var reRankPlaceHolder2 = regexp.MustCompile(`\{([^\{\}]+?)\}`)
-
-var poolRank2idx = &sync.Pool{New: func() interface{} {
-	tmp := make(map[string]int, 64)
-	return &tmp
-}}
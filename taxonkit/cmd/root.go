@@ -23,6 +23,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 
 	"github.com/mitchellh/go-homedir"
@@ -78,7 +79,78 @@ Dataset:
     When environment variable TAXONKIT_DB is set, explicitly setting --data-dir will
     overide the value of TAXONKIT_DB.
 
-`, VERSION, defaulDataDir)
+    A taxdump archive file (taxdump.tar.gz, or taxdmp_*.zip in the taxdump
+    archive) can also be given to --data-dir, dump files are read from it
+    without being extracted to disk.
+
+    "division.dmp" and "gencode.dmp" are optional, used by "taxonkit gencode".
+
+    Files in new_taxdump.tar.gz are also supported:
+    http://ftp.ncbi.nih.gov/pub/taxonomy/new_taxdump/new_taxdump.tar.gz
+    "host.dmp" and "typematerial.dmp" are used by "taxonkit lineage" and
+    "taxonkit filter". "taxidlineage.dmp" and "fullnamelineage.dmp" can be
+    used in place of "nodes.dmp" and "names.dmp", but no ranks are available.
+
+    Optionally, run "taxonkit index" to create a binary index of these files
+    for faster loading.
+
+Merged, deleted and unknown TaxIds:
+
+    All commands handle TaxIds merged into others (merged.dmp), deleted
+    TaxIds (delnodes.dmp) and unknown TaxIds in the same way, controlled by
+    --on-merged, --on-deleted and --on-unknown:
+
+        replace   use the new TaxId of a merged TaxId (only for --on-merged)
+        keep      keep the record as it is, without taxonomic information
+        drop      drop the record, or the TaxId in a list of TaxIds (taxonkit lca)
+        fail      stop with an error
+
+    With --strict, the run exits with a non-zero status after processing
+    all the data if any merged, deleted or unknown TaxId is met.
+
+    Each distinct TaxId is only warned once, and a summary of numbers of
+    these TaxIds is printed at exit, or before stopping with "fail". They can
+    be saved to a tab-delimited file with --unresolved-report, with columns:
+
+        1. TaxId
+        2. category: "merged", "deleted" or "unknown"
+        3. the new TaxId of a merged TaxId
+        4. action: "replace", "keep", "drop" or "fail"
+        5. number of occurrences
+
+Output formats:
+
+    Commands lineage, reformat, reformat2, name2taxid, lca, filter, gencode
+    and list support --out-format:
+
+        tsv       tab-separated values (default)
+        csv       comma-separated values, fields are quoted when needed
+        jsonl     JSON Lines, one object per record, with the input line
+                  as "input", and named fields, e.g., "taxid", "status",
+                  "name", "rank", and lineages as arrays
+
+Config file:
+
+    Default values of flags and names of databases can be set in the config file
+    "%s", or another file given by environment variable TAXONKIT_CONFIG.
+    Flags explicitly given in the command line always take precedence,
+    and TAXONKIT_DB overrides the data-dir in the config file.
+
+        [global]                 # for all commands with these flags
+        threads = 8
+        data-dir = "ncbi"        # a database name or a path
+
+        [command.reformat2]      # for a command
+        format = "{domain|superkingdom};{phylum};{class};{order};{family};{genus};{species}"
+
+        [command.filter]
+        black-list = ["no rank", "clade"]
+
+        [databases]              # names of databases, which can be used in --data-dir
+        ncbi = "~/.taxonkit/"
+        gtdb = "~/db/gtdb-taxdump/R220"
+
+`, VERSION, defaulDataDir, filepath.Join(defaulDataDir, configFileName))
 
 	defaultThreads := runtime.NumCPU()
 	if defaultThreads > 4 {
@@ -87,9 +159,28 @@ Dataset:
 
 	RootCmd.PersistentFlags().IntP("threads", "j", defaultThreads, "number of CPUs. 4 is enough")
 	RootCmd.PersistentFlags().StringP("out-file", "o", "-", `out file ("-" for stdout, suffix .gz for gzipped out)`)
-	RootCmd.PersistentFlags().StringP("data-dir", "", defaulDataDir, "directory containing nodes.dmp and names.dmp")
+	RootCmd.PersistentFlags().StringP("data-dir", "", defaulDataDir, "directory containing nodes.dmp and names.dmp, or a taxdump archive file (.tar.gz, .tgz or .zip)")
+	RootCmd.PersistentFlags().StringP("db", "", "", `name of a database managed by "taxonkit db", exclusive with --data-dir`)
 	RootCmd.PersistentFlags().BoolP("verbose", "", false, "print verbose information")
 	RootCmd.PersistentFlags().BoolP("line-buffered", "", false, "use line buffering on output, i.e., immediately writing to stdin/file for every line of output")
+	RootCmd.PersistentFlags().StringP("out-format", "", "tsv", `output format: "tsv", "csv" or "jsonl" (JSON Lines with named fields), supported by lineage, reformat, reformat2, name2taxid, lca, filter, gencode and list`)
+	RootCmd.PersistentFlags().StringP("on-merged", "", "replace", `action for merged TaxIds: "replace", "keep", "drop" or "fail"`)
+	RootCmd.PersistentFlags().StringP("on-deleted", "", "keep", `action for deleted TaxIds: "keep", "drop" or "fail"`)
+	RootCmd.PersistentFlags().StringP("on-unknown", "", "keep", `action for unknown TaxIds: "keep", "drop" or "fail"`)
+	RootCmd.PersistentFlags().BoolP("strict", "", false, "exit with a non-zero status if any merged, deleted or unknown TaxId is met")
+	RootCmd.PersistentFlags().StringP("unresolved-report", "", "", "save merged, deleted and unknown TaxIds to a file, with their categories, replacements, actions and occurrences")
+
+	// default values of flags in the config file
+	RootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		var err error
+		userConfig, err = loadUserConfig()
+		checkError(err)
+		checkError(applyUserConfig(cmd, userConfig))
+	}
+
+	RootCmd.PersistentPostRun = func(cmd *cobra.Command, args []string) {
+		taxIdPolicy.summarize()
+	}
 
 	RootCmd.CompletionOptions.DisableDefaultCmd = true
 	RootCmd.SetHelpCommand(&cobra.Command{Hidden: true})
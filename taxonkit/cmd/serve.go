@@ -0,0 +1,835 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/spf13/cobra"
+	"github.com/suggest-go/suggest/pkg/suggest"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve a local HTTP JSON API for querying the taxonomy",
+	Long: `Serve a local HTTP JSON API for querying the taxonomy
+
+The taxonomy data is loaded once, and queries are answered in JSON,
+which saves the loading time for frequent calls from web dashboards,
+notebooks and scripts.
+
+Listening address (-l/--listen):
+
+  - "host:port", e.g., "localhost:8080", ":8080" (all interfaces).
+  - "unix:<path>" for a unix socket, e.g., "unix:/tmp/taxonkit.sock".
+
+Endpoints:
+
+  Parameters can be given in the URL query (GET) or as a form (POST).
+  TaxIds are given with "taxid", multiple values can be separated by
+  commas, or given with repeated parameters.
+
+  /                 information of the server and the taxonomy data.
+  /lineage          complete lineages of TaxIds, like "taxonkit lineage".
+                      taxid, name-class
+  /reformat         lineages in chosen ranks, like "taxonkit reformat2".
+                      taxid, format, miss-rank-repl, miss-taxid-repl,
+                      trim, no-ranks
+  /name2taxid       TaxIds of names, like "taxonkit name2taxid".
+                      name (repeated for multiple names), sci-name,
+                      fuzzy, fuzzy-top-n
+  /lca              the lowest common ancestor of TaxIds, like "taxonkit lca".
+                      taxid
+  /list             descendants of TaxIds, like "taxonkit list".
+                      taxid, name-class, depth, limit
+  /filter           whether TaxIds pass a rank range, like "taxonkit filter".
+                      taxid, lower-than, higher-than, equal-to,
+                      black-list, discard-noranks, save-predictable-norank,
+                      discard-root, root-taxid
+
+  Values should be URL-encoded, e.g., ";" in the format of /reformat.
+  Boolean parameters are true if given with no value, or with "true"/"1".
+  The default values are the same as those of the commands.
+
+Merged, deleted and unknown TaxIds:
+
+  They are handled according to the global flags --on-merged, --on-deleted
+  and --on-unknown, which can be overridden for a request with parameters
+  of the same names. The status ("found", "merged", "deleted" or "unknown")
+  is given in each result, and results of dropped TaxIds are omitted.
+  A request with a TaxId of the action "fail" returns the status code 422.
+  Unlike other commands, they are not warned or reported at exit.
+
+Limits of /list:
+
+  Descendants are listed down to "depth" levels below each TaxId (default 0
+  for all levels), and at most "limit" descendants are returned for each
+  TaxId (default 10000), which can not exceed --max-list-size. A result
+  with "truncated": true has more descendants than returned.
+
+Errors are returned with HTTP status codes and a JSON object: {"error": "..."}.
+
+Examples:
+
+    $ taxonkit serve --listen localhost:8080
+
+    $ curl -s "localhost:8080/lineage?taxid=9606,562"
+    $ curl -s localhost:8080/reformat -G -d taxid=9606 --data-urlencode "format={genus};{species}"
+    $ curl -s "localhost:8080/name2taxid?name=Homo+sapiens&name=Escherichia+coli"
+    $ curl -s "localhost:8080/lca?taxid=9606,10090"
+    $ curl -s "localhost:8080/list?taxid=9605&depth=1"
+    $ curl -s "localhost:8080/filter?taxid=9606,9605&equal-to=species"
+    $ curl -s localhost:8080/lineage -d taxid=9606 -d taxid=562 -d on-merged=keep
+
+    # unix socket
+    $ taxonkit serve --listen unix:/tmp/taxonkit.sock
+    $ curl -s --unix-socket /tmp/taxonkit.sock "http://localhost/lineage?taxid=9606"
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		listen := getFlagString(cmd, "listen")
+		if listen == "" {
+			checkError(fmt.Errorf("flag -l/--listen needed"))
+		}
+		rankFile := getFlagString(cmd, "rank-file")
+		maxListSize := getFlagPositiveInt(cmd, "max-list-size")
+
+		rankOrder, noRanks, err := readRankOrder(config, rankFile)
+		checkError(err)
+
+		// all the data is loaded in advance, so no loading during requests
+		taxdb := loadTaxonomy(&config, taxonomy.PartNodes|taxonomy.PartDelNodes|taxonomy.PartMerged|
+			taxonomy.PartRanks|taxonomy.PartNames|taxonomy.PartAllNames)
+
+		s := &server{
+			config:    config,
+			taxdb:     taxdb,
+			version:   getTaxonomyVersion(config),
+			rankOrder: rankOrder,
+			noRanks:   noRanks,
+
+			maxListSize: maxListSize,
+		}
+
+		// -------------------------------------------------------
+
+		var network, address string
+		if strings.HasPrefix(listen, "unix:") {
+			network, address = "unix", strings.TrimPrefix(listen, "unix:")
+			if address == "" {
+				checkError(fmt.Errorf("invalid value of flag -l/--listen: %s", listen))
+			}
+			// remove the socket file left by a previous run
+			if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket > 0 {
+				checkError(os.Remove(address))
+			}
+		} else {
+			network, address = "tcp", listen
+		}
+
+		ln, err := net.Listen(network, address)
+		checkError(err)
+		if network == "unix" {
+			defer os.Remove(address)
+		}
+
+		srv := &http.Server{
+			Handler:           s.handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		done := make(chan struct{})
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			log.Infof("shutting down ...")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Warningf("failed to shut down the server: %s", err)
+			}
+			close(done)
+		}()
+
+		log.Infof("taxonkit v%s serving %s on %s://%s", VERSION, s.version, network, address)
+		if err = srv.Serve(ln); err != http.ErrServerClosed {
+			checkError(err)
+		}
+		<-done
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringP("listen", "l", "localhost:8080", `listening address, "host:port" or "unix:<path of socket>"`)
+	serveCmd.Flags().StringP("rank-file", "", "", `rank order file for /filter, one rank per line, leveled ranks are separated by ",". default: ${data-dir}/ranks.txt`)
+	serveCmd.Flags().IntP("max-list-size", "", 1000000, `maximum number of descendants returned for a TaxId by /list, i.e., the upper bound of the parameter "limit"`)
+}
+
+// server answers queries of the taxonomy.
+type server struct {
+	config  Config
+	taxdb   *taxonomy.Taxonomy
+	version taxonomyVersion
+
+	rankOrder map[string]int
+	noRanks   map[string]interface{}
+
+	maxListSize int // the upper bound of "limit" of /list
+
+	// name -> TaxIds mappings and indexes for fuzzy searching,
+	// 0 for all names, 1 for scientific names. They are created on demand.
+	onceNames  [2]sync.Once
+	nameMaps   [2]map[string][]uint32
+	onceFuzzy  [2]sync.Once
+	suggesters [2]*suggest.Service
+	fuzzyErrs  [2]error
+}
+
+// httpError is an error with an HTTP status code.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+func badRequest(format string, a ...interface{}) *httpError {
+	return &httpError{http.StatusBadRequest, fmt.Sprintf(format, a...)}
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	for path, fn := range map[string]func(*http.Request) (interface{}, error){
+		"/lineage":    s.lineage,
+		"/reformat":   s.reformat,
+		"/name2taxid": s.name2taxid,
+		"/lca":        s.lca,
+		"/list":       s.list,
+		"/filter":     s.filter,
+	} {
+		mux.Handle(path, s.wrap(fn))
+	}
+	mux.Handle("/", s.wrap(s.info))
+	return mux
+}
+
+// wrap parses the parameters, and writes the result or error in JSON.
+func (s *server) wrap(fn func(*http.Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		var result interface{}
+		var err error
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			err = &httpError{http.StatusMethodNotAllowed, "only GET and POST are allowed"}
+		} else if err = r.ParseForm(); err != nil {
+			err = badRequest("%s", err)
+		} else {
+			result, err = fn(r)
+		}
+
+		code := http.StatusOK
+		if err != nil {
+			code = http.StatusInternalServerError
+			if e, ok := err.(*httpError); ok {
+				code = e.code
+			}
+			result = map[string]string{"error": err.Error()}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(result); err != nil && s.config.Verbose {
+			log.Warningf("failed to write the response: %s", err)
+		}
+
+		if s.config.Verbose {
+			log.Infof("%s %s %d %s", r.Method, r.URL.Path, code, time.Since(start))
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------
+// parameters
+
+func formBool(r *http.Request, key string) (bool, error) {
+	if _, ok := r.Form[key]; !ok {
+		return false, nil
+	}
+	switch v := strings.ToLower(r.Form.Get(key)); v {
+	case "", "true", "1", "yes":
+		return true, nil
+	case "false", "0", "no":
+		return false, nil
+	default:
+		return false, badRequest("invalid value of %s: %s", key, v)
+	}
+}
+
+func formString(r *http.Request, key string, value string) string {
+	if _, ok := r.Form[key]; !ok {
+		return value
+	}
+	return r.Form.Get(key)
+}
+
+// formStrings returns all values of a key, values separated by commas are split.
+func formStrings(r *http.Request, key string) []string {
+	values := make([]string, 0, len(r.Form[key]))
+	for _, v := range r.Form[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+func formTaxIds(r *http.Request) ([]string, error) {
+	queries := formStrings(r, "taxid")
+	if len(queries) == 0 {
+		return nil, badRequest("parameter taxid needed")
+	}
+	for _, q := range queries {
+		if _, err := strconv.ParseUint(q, 10, 32); err != nil {
+			return nil, badRequest("invalid TaxId: %s", q)
+		}
+	}
+	return queries, nil
+}
+
+// policy returns the policy of merged, deleted and unknown TaxIds for a request.
+func (s *server) policy(r *http.Request) (*TaxIdPolicy, error) {
+	p := &TaxIdPolicy{
+		OnMerged:  s.config.TaxIdPolicy.OnMerged,
+		OnDeleted: s.config.TaxIdPolicy.OnDeleted,
+		OnUnknown: s.config.TaxIdPolicy.OnUnknown,
+	}
+	var err error
+	for _, f := range []struct {
+		key         string
+		action      *taxIdAction
+		replaceable bool
+	}{
+		{"on-merged", &p.OnMerged, true},
+		{"on-deleted", &p.OnDeleted, false},
+		{"on-unknown", &p.OnUnknown, false},
+	} {
+		if _, ok := r.Form[f.key]; !ok {
+			continue
+		}
+		if *f.action, err = parseTaxIdActionString(r.Form.Get(f.key), f.replaceable); err != nil {
+			return nil, badRequest("invalid value of %s: %s", f.key, err)
+		}
+	}
+	return p, nil
+}
+
+// ---------------------------------------------------------------------------
+// results
+
+// taxonResult is the common part of results of a queried TaxId.
+type taxonResult struct {
+	Query    string `json:"query"`
+	TaxId    uint32 `json:"taxid"`               // the TaxId in use, the new one for replaced merged TaxIds
+	Status   string `json:"status"`              // found, merged, deleted or unknown
+	NewTaxId uint32 `json:"new_taxid,omitempty"` // the new TaxId of a merged TaxId
+}
+
+// taxon is a node in the taxonomy.
+type taxon struct {
+	TaxId  uint32 `json:"taxid"`
+	Parent uint32 `json:"parent,omitempty"`
+	Name   string `json:"name"`
+	Rank   string `json:"rank"`
+}
+
+// resolve checks a TaxId with the policy, like TaxIdPolicy.Resolve,
+// but an error is returned for the action "fail".
+func (s *server) resolve(p *TaxIdPolicy, query string) (taxonResult, taxIdAction, error) {
+	id, _ := strconv.ParseUint(query, 10, 32)
+	taxid := uint32(id)
+	newtaxid, status := s.taxdb.Resolve(taxid)
+	action := p.action(status)
+
+	result := taxonResult{Query: query, TaxId: newtaxid, Status: status.String()}
+	if status == taxonomy.Merged {
+		result.NewTaxId = newtaxid
+		if action != actionUse {
+			result.TaxId = taxid
+		}
+	}
+
+	if action == actionFail {
+		var msg string
+		switch status {
+		case taxonomy.Merged:
+			msg = fmt.Sprintf("taxid %d was merged into %d (on-merged fail)", taxid, newtaxid)
+		case taxonomy.Deleted:
+			msg = fmt.Sprintf("taxid %d was deleted (on-deleted fail)", taxid)
+		default:
+			msg = fmt.Sprintf("taxid %d not found (on-unknown fail)", taxid)
+		}
+		return result, action, &httpError{http.StatusUnprocessableEntity, msg}
+	}
+	return result, action, nil
+}
+
+func (s *server) taxon(taxid uint32, taxonName func(uint32) string) taxon {
+	return taxon{TaxId: taxid, Name: taxonName(taxid), Rank: s.taxdb.Rank(taxid)}
+}
+
+// taxonNameFunc is like the one for commands, without warnings of unknown name classes.
+func (s *server) taxonNameFunc(r *http.Request) func(uint32) string {
+	classes := formStrings(r, "name-class")
+	if nameClassParts(classes)&taxonomy.PartAllNames == 0 {
+		return s.taxdb.Name
+	}
+	return func(taxid uint32) string {
+		return s.taxdb.NameInClasses(taxid, classes...)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// endpoints
+
+func (s *server) info(r *http.Request) (interface{}, error) {
+	if r.URL.Path != "/" {
+		return nil, &httpError{http.StatusNotFound, "endpoint not found: " + r.URL.Path}
+	}
+	return map[string]interface{}{
+		"version": VERSION,
+		"taxonomy": map[string]string{
+			"version": s.version.String(),
+			"date":    s.version.Date,
+			"source":  s.version.Source,
+			"data":    s.version.DataDir,
+		},
+		"nodes":     s.taxdb.NumNodes(),
+		"names":     s.taxdb.NumNames(),
+		"merged":    s.taxdb.NumMerged(),
+		"deleted":   s.taxdb.NumDelNodes(),
+		"endpoints": []string{"/lineage", "/reformat", "/name2taxid", "/lca", "/list", "/filter"},
+	}, nil
+}
+
+type lineageResult struct {
+	taxonResult
+	Name    string  `json:"name"`
+	Rank    string  `json:"rank"`
+	Lineage []taxon `json:"lineage"`
+}
+
+func (s *server) lineage(r *http.Request) (interface{}, error) {
+	queries, err := formTaxIds(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.policy(r)
+	if err != nil {
+		return nil, err
+	}
+	taxonName := s.taxonNameFunc(r)
+
+	results := make([]lineageResult, 0, len(queries))
+	for _, q := range queries {
+		t, action, err := s.resolve(p, q)
+		if err != nil {
+			return nil, err
+		}
+		result := lineageResult{taxonResult: t, Lineage: []taxon{}}
+		switch action {
+		case actionDrop:
+			continue
+		case actionUse:
+			result.Name = taxonName(t.TaxId)
+			result.Rank = s.taxdb.Rank(t.TaxId)
+			for _, taxid := range s.taxdb.LineageTaxIds(t.TaxId) {
+				result.Lineage = append(result.Lineage, s.taxon(taxid, taxonName))
+			}
+		}
+		results = append(results, result)
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+type reformatResult struct {
+	taxonResult
+	Lineage       string `json:"lineage"`
+	LineageTaxIds string `json:"lineage_taxids"`
+}
+
+func (s *server) reformat(r *http.Request) (interface{}, error) {
+	queries, err := formTaxIds(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.policy(r)
+	if err != nil {
+		return nil, err
+	}
+
+	format := formString(r, "format", reformat2Cmd.Flags().Lookup("format").DefValue)
+	noRanks := formStrings(r, "no-ranks")
+	if len(noRanks) == 0 {
+		noRanks = taxonomy.DefaultNoRanks
+	}
+	formatter, err := taxonomy.NewLineageFormatter(format, noRanks)
+	if err != nil {
+		return nil, badRequest("%s", err)
+	}
+	formatter.MissRankRepl = formString(r, "miss-rank-repl", "")
+	formatter.MissTaxIdRepl = formString(r, "miss-taxid-repl", "")
+	if formatter.Trim, err = formBool(r, "trim"); err != nil {
+		return nil, err
+	}
+	formatter.TaxIds = true
+
+	results := make([]reformatResult, 0, len(queries))
+	for _, q := range queries {
+		t, action, err := s.resolve(p, q)
+		if err != nil {
+			return nil, err
+		}
+		result := reformatResult{taxonResult: t}
+		switch action {
+		case actionDrop:
+			continue
+		case actionKeep:
+			result.Lineage, result.LineageTaxIds = formatter.Missing()
+		default:
+			lineage, err := s.taxdb.Lineage(t.TaxId)
+			if err != nil {
+				return nil, err
+			}
+			result.Lineage, result.LineageTaxIds = formatter.Format(lineage)
+		}
+		results = append(results, result)
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+type name2taxidResult struct {
+	Query string  `json:"query"`
+	Taxa  []taxon `json:"taxa"`
+}
+
+// nameMap returns the name -> TaxIds mapping and the index for fuzzy searching.
+func (s *server) nameMap(sciName bool, fuzzy bool) (map[string][]uint32, *suggest.Service, error) {
+	i := 0
+	if sciName {
+		i = 1
+	}
+	s.onceNames[i].Do(func() {
+		s.nameMaps[i] = s.taxdb.NameMap(sciName)
+	})
+	if !fuzzy {
+		return s.nameMaps[i], nil, nil
+	}
+	s.onceFuzzy[i].Do(func() {
+		if s.config.Verbose {
+			log.Infof("creating indexing for name searching ...")
+		}
+		s.suggesters[i], s.fuzzyErrs[i] = newNameSuggester(s.nameMaps[i])
+	})
+	return s.nameMaps[i], s.suggesters[i], s.fuzzyErrs[i]
+}
+
+func (s *server) name2taxid(r *http.Request) (interface{}, error) {
+	var queries []string
+	for _, name := range r.Form["name"] {
+		if name = strings.TrimSpace(name); name != "" {
+			queries = append(queries, name)
+		}
+	}
+	if len(queries) == 0 {
+		return nil, badRequest("parameter name needed")
+	}
+
+	sciName, err := formBool(r, "sci-name")
+	if err != nil {
+		return nil, err
+	}
+	fuzzy, err := formBool(r, "fuzzy")
+	if err != nil {
+		return nil, err
+	}
+	topN := 1
+	if v := formString(r, "fuzzy-top-n", ""); v != "" {
+		if topN, err = strconv.Atoi(v); err != nil || topN <= 0 {
+			return nil, badRequest("invalid value of fuzzy-top-n: %s", v)
+		}
+	}
+
+	m, service, err := s.nameMap(sciName, fuzzy)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]name2taxidResult, 0, len(queries))
+	var taxids []uint32
+	for _, q := range queries {
+		if fuzzy {
+			if taxids, err = suggestTaxIds(service, m, q, topN); err != nil {
+				return nil, err
+			}
+		} else {
+			taxids = m[strings.ToLower(q)]
+		}
+
+		result := name2taxidResult{Query: q, Taxa: make([]taxon, 0, len(taxids))}
+		for _, taxid := range taxids {
+			result.Taxa = append(result.Taxa, s.taxon(taxid, s.taxdb.Name))
+		}
+		results = append(results, result)
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+func (s *server) lca(r *http.Request) (interface{}, error) {
+	queries, err := formTaxIds(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.policy(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// like "taxonkit lca", the LCA is 0 if any TaxId is kept
+	var lca uint32
+	var kept bool
+	taxa := make([]taxonResult, 0, len(queries))
+	taxids := make([]uint32, 0, len(queries))
+	for _, q := range queries {
+		t, action, err := s.resolve(p, q)
+		if err != nil {
+			return nil, err
+		}
+		switch action {
+		case actionDrop:
+			continue
+		case actionKeep:
+			kept = true
+		default:
+			taxids = append(taxids, t.TaxId)
+		}
+		taxa = append(taxa, t)
+	}
+	if !kept && len(taxids) > 0 {
+		lca = s.taxdb.LCAOf(taxids)
+	}
+
+	result := map[string]interface{}{"taxa": taxa, "lca": lca}
+	if lca > 0 {
+		result["name"] = s.taxdb.Name(lca)
+		result["rank"] = s.taxdb.Rank(lca)
+	}
+	return result, nil
+}
+
+type listResult struct {
+	taxonResult
+	Descendants []taxon `json:"descendants"`
+	Truncated   bool    `json:"truncated"`
+}
+
+// defaultListLimit is the default maximum number of descendants returned for a TaxId by /list.
+const defaultListLimit = 10000
+
+// formNonNegativeInt returns a non-negative integer of a parameter, or the default value.
+func formNonNegativeInt(r *http.Request, key string, value int) (int, error) {
+	v := formString(r, key, "")
+	if v == "" {
+		return value, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, badRequest("invalid value of %s: %s", key, v)
+	}
+	return i, nil
+}
+
+func (s *server) list(r *http.Request) (interface{}, error) {
+	queries, err := formTaxIds(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.policy(r)
+	if err != nil {
+		return nil, err
+	}
+	taxonName := s.taxonNameFunc(r)
+	depth, err := formNonNegativeInt(r, "depth", 0)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := formNonNegativeInt(r, "limit", defaultListLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 || limit > s.maxListSize {
+		return nil, badRequest("value of limit should be in the range of [1, %d]: %d", s.maxListSize, limit)
+	}
+
+	// there's nothing to list for kept merged, deleted or unknown TaxIds
+	results := make([]listResult, 0, len(queries))
+	for _, q := range queries {
+		t, action, err := s.resolve(p, q)
+		if err != nil {
+			return nil, err
+		}
+		if action == actionDrop {
+			continue
+		}
+		result := listResult{taxonResult: t, Descendants: []taxon{}}
+		if action == actionUse {
+			// in the same order of "taxonkit list", it stops once the limit is reached
+			var walk func(uint32, int) bool
+			walk = func(parent uint32, level int) bool {
+				if depth > 0 && level > depth {
+					return true
+				}
+				for _, child := range s.taxdb.Children(parent) {
+					if len(result.Descendants) == limit {
+						result.Truncated = true
+						return false
+					}
+					c := s.taxon(child, taxonName)
+					c.Parent = parent
+					result.Descendants = append(result.Descendants, c)
+					if !walk(child, level+1) {
+						return false
+					}
+				}
+				return true
+			}
+			walk(t.TaxId, 1)
+		}
+		results = append(results, result)
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+type filterResult struct {
+	taxonResult
+	Rank   string `json:"rank"`
+	Passed bool   `json:"passed"`
+}
+
+func (s *server) filter(r *http.Request) (interface{}, error) {
+	queries, err := formTaxIds(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.policy(r)
+	if err != nil {
+		return nil, err
+	}
+
+	lower := strings.ToLower(formString(r, "lower-than", ""))
+	higher := strings.ToLower(formString(r, "higher-than", ""))
+	if lower != "" && higher != "" {
+		return nil, badRequest("lower-than and higher-than can't be simultaneous given")
+	}
+	equals := formStrings(r, "equal-to")
+	for i, v := range equals {
+		equals[i] = strings.ToLower(v)
+	}
+	blackList := formStrings(r, "black-list")
+	for i, v := range blackList {
+		blackList[i] = strings.ToLower(v)
+	}
+	discardNoRank, err := formBool(r, "discard-noranks")
+	if err != nil {
+		return nil, err
+	}
+	saveNorank, err := formBool(r, "save-predictable-norank")
+	if err != nil {
+		return nil, err
+	}
+	if saveNorank {
+		if lower == "" {
+			return nil, badRequest("save-predictable-norank only works along with lower-than")
+		}
+		discardNoRank = true
+	}
+	discardRoot, err := formBool(r, "discard-root")
+	if err != nil {
+		return nil, err
+	}
+	var rootTaxid uint64 = 1
+	if v := formString(r, "root-taxid", ""); v != "" {
+		if rootTaxid, err = strconv.ParseUint(v, 10, 32); err != nil {
+			return nil, badRequest("invalid value of root-taxid: %s", v)
+		}
+	}
+
+	// a RankFilter is not safe for concurrent use, and it's cheap to create
+	filter, err := taxonomy.NewRankFilter(s.taxdb, s.rankOrder, s.noRanks,
+		lower, higher, equals, blackList, discardNoRank, saveNorank)
+	if err != nil {
+		return nil, badRequest("%s", err)
+	}
+
+	// records of kept merged, deleted or unknown TaxIds never pass
+	results := make([]filterResult, 0, len(queries))
+	for _, q := range queries {
+		t, action, err := s.resolve(p, q)
+		if err != nil {
+			return nil, err
+		}
+		if action == actionDrop {
+			continue
+		}
+		result := filterResult{taxonResult: t}
+		if action == actionUse {
+			result.Rank = s.taxdb.Rank(t.TaxId)
+			if id, _ := strconv.ParseUint(q, 10, 32); !(discardRoot && id == rootTaxid) {
+				if result.Passed, err = filter.IsPassed(t.TaxId); err != nil {
+					return nil, err
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return map[string]interface{}{"results": results}, nil
+}
@@ -0,0 +1,607 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/spf13/cobra"
+	"github.com/suggest-go/suggest/pkg/suggest"
+	"golang.org/x/term"
+)
+
+// shellCmd represents the shell command
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Interactive shell for exploring the taxonomy",
+	Long: `Interactive shell for exploring the taxonomy
+
+The taxonomy data is loaded once, and the tree can be explored with
+commands below, like browsing directories.
+
+` + shellHelp + `Line editing:
+
+  Commands and taxon names (scientific names) can be completed with Tab,
+  and previous commands can be recalled with the up and down arrow keys.
+  The history is saved in the file given by --history-file.
+
+  When the input is not a terminal, commands are read line by line,
+  e.g., "echo 'lineage 9606' | taxonkit shell".
+
+Examples:
+
+    $ taxonkit shell
+    taxonkit:1 (root)> name Drosophila
+    7215 [genus] Drosophila
+    32281 [subgenus] Drosophila
+    2081351 [genus] Drosophila
+    taxonkit:1 (root)> cd 7215
+    taxonkit:7215 (Drosophila)> children
+    ...
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+
+		historyFile := getFlagString(cmd, "history-file")
+		historySize := getFlagNonNegativeInt(cmd, "history-size")
+
+		taxdb := loadTaxonomy(&config, taxonomy.PartNodes|taxonomy.PartDelNodes|taxonomy.PartMerged|
+			taxonomy.PartRanks|taxonomy.PartNames|taxonomy.PartAllNames)
+
+		sh := &shell{taxdb: taxdb, current: taxdb.Root()}
+
+		fdIn, fdOut := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+		if !term.IsTerminal(fdIn) || !term.IsTerminal(fdOut) {
+			out := bufio.NewWriter(os.Stdout)
+			sh.out = out
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				quit := sh.run(scanner.Text())
+				checkError(out.Flush())
+				if quit {
+					break
+				}
+			}
+			checkError(scanner.Err())
+			return
+		}
+
+		state, err := term.MakeRaw(fdIn)
+		checkError(err)
+		defer term.Restore(fdIn, state)
+
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "")
+		if width, height, err := term.GetSize(fdOut); err == nil && width > 0 {
+			t.SetSize(width, height)
+		}
+		sh.out = t
+		t.AutoCompleteCallback = sh.complete
+
+		if historyFile != "" && historySize > 0 {
+			history, err := newShellHistory(historyFile, historySize)
+			if err != nil {
+				log.Warningf("history will not be saved: %s", err)
+			} else {
+				defer history.Close()
+				t.History = history
+			}
+		}
+
+		fmt.Fprintf(t, "TaxonKit shell, type \"help\" for the commands, \"exit\" or Ctrl-D to exit.\n")
+		var line string
+		for {
+			t.SetPrompt(sh.prompt())
+			line, err = t.ReadLine()
+			if err == io.EOF {
+				break
+			}
+			checkError(err)
+			if sh.run(line) {
+				break
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(shellCmd)
+
+	shellCmd.Flags().StringP("history-file", "", filepath.Join(defaulDataDir, "shell_history"), `file for saving the command history, "" for not saving`)
+	shellCmd.Flags().IntP("history-size", "", 1000, "maximum number of commands in the history")
+}
+
+// shellHelp is the help message of commands in the shell.
+const shellHelp = `Commands:
+
+  name <name>            TaxIds of a name, in all name classes
+  info [taxon]           TaxId, rank and name of a taxon
+  lineage [taxon]        complete lineage of a taxon
+  children [taxon]       children of a taxon
+  cd <taxon>             go to a taxon, "cd .." for the parent, "cd /" for the root
+  up                     go to the parent, the same as "cd .."
+  lca <taxid> <taxid>..  lowest common ancestor of TaxIds
+  rank-counts [taxon]    numbers of nodes of each rank in the subtree of a taxon
+  help                   show the commands
+  exit, quit             exit the shell (or Ctrl-D)
+
+  A taxon can be given with a TaxId or a name, the current one is used if
+  not given. Merged TaxIds are replaced with the new ones.
+
+`
+
+// shellCommands are commands of the shell, for the help message and completion.
+var shellCommands = []string{"cd", "children", "exit", "help", "info", "lca", "lineage", "name", "quit", "rank-counts", "up"}
+
+// shellTaxonCommands are commands accepting a taxon name, for completion.
+var shellTaxonCommands = map[string]bool{"cd": true, "children": true, "info": true, "lineage": true, "name": true, "rank-counts": true}
+
+// shell is an interactive shell for exploring the taxonomy.
+type shell struct {
+	taxdb   *taxonomy.Taxonomy
+	current uint32
+	out     io.Writer
+
+	nameMap   map[string][]uint32 // lowercase name -> TaxIds, for all name classes
+	suggester *suggest.Service
+
+	names      []string // scientific names for completion, sorted by lowercase
+	lowerNames []string
+}
+
+func (sh *shell) prompt() string {
+	return fmt.Sprintf("taxonkit:%d (%s)> ", sh.current, sh.taxdb.Name(sh.current))
+}
+
+func (sh *shell) printf(format string, a ...interface{}) {
+	fmt.Fprintf(sh.out, format, a...)
+}
+
+func (sh *shell) printTaxon(taxid uint32, indent string) {
+	sh.printf("%s%d [%s] %s\n", indent, taxid, sh.taxdb.Rank(taxid), sh.taxdb.Name(taxid))
+}
+
+// run runs a command, and returns true for exiting.
+func (sh *shell) run(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return false
+	}
+	command, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i > 0 {
+		command, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	var err error
+	switch strings.ToLower(command) {
+	case "exit", "quit":
+		return true
+	case "help":
+		sh.help()
+	case "name":
+		err = sh.name(arg)
+	case "info":
+		err = sh.info(arg)
+	case "lineage":
+		err = sh.lineage(arg)
+	case "children":
+		err = sh.children(arg)
+	case "cd":
+		err = sh.cd(arg)
+	case "up":
+		err = sh.cd("..")
+	case "lca":
+		err = sh.lca(arg)
+	case "rank-counts":
+		err = sh.rankCounts(arg)
+	default:
+		err = fmt.Errorf(`unknown command: %s, type "help" for the commands`, command)
+	}
+	if err != nil {
+		sh.printf("error: %s\n", err)
+	}
+	return false
+}
+
+func (sh *shell) help() {
+	sh.printf("%s", shellHelp)
+}
+
+// prepareNameMap creates the name -> TaxIds mapping of all name classes.
+func (sh *shell) prepareNameMap() {
+	if sh.nameMap == nil {
+		sh.nameMap = sh.taxdb.NameMap(false)
+	}
+}
+
+// lookupName returns TaxIds of a name in all name classes.
+func (sh *shell) lookupName(name string) []uint32 {
+	sh.prepareNameMap()
+	return sh.nameMap[strings.ToLower(name)]
+}
+
+// taxon returns the TaxId of a taxon given by a TaxId or a name,
+// the current one is returned for an empty argument.
+func (sh *shell) taxon(arg string) (uint32, error) {
+	if arg == "" {
+		return sh.current, nil
+	}
+
+	if id, err := strconv.ParseUint(arg, 10, 32); err == nil {
+		taxid, status := sh.taxdb.Resolve(uint32(id))
+		switch status {
+		case taxonomy.Found:
+			return taxid, nil
+		case taxonomy.Merged:
+			sh.printf("taxid %d was merged into %d\n", id, taxid)
+			return taxid, nil
+		case taxonomy.Deleted:
+			return 0, fmt.Errorf("taxid %d was deleted", id)
+		default:
+			return 0, fmt.Errorf("taxid %d not found", id)
+		}
+	}
+
+	taxids := sh.lookupName(arg)
+	switch len(taxids) {
+	case 0:
+		return 0, fmt.Errorf("name not found: %s%s", arg, sh.didYouMean(arg))
+	case 1:
+		return taxids[0], nil
+	}
+
+	items := make([]string, len(taxids))
+	for i, taxid := range taxids {
+		items[i] = fmt.Sprintf("%d [%s]", taxid, sh.taxdb.Rank(taxid))
+	}
+	return 0, fmt.Errorf("multiple TaxIds found for '%s', please use one of: %s", arg, strings.Join(items, ", "))
+}
+
+// didYouMean returns similar names.
+func (sh *shell) didYouMean(name string) string {
+	if sh.suggester == nil {
+		sh.prepareNameMap()
+		var err error
+		if sh.suggester, err = newNameSuggester(sh.nameMap); err != nil {
+			return ""
+		}
+	}
+	taxids, err := suggestTaxIds(sh.suggester, sh.nameMap, name, 3)
+	if err != nil || len(taxids) == 0 {
+		return ""
+	}
+	items := make([]string, 0, len(taxids))
+	for _, taxid := range taxids {
+		items = append(items, fmt.Sprintf("%s (%d)", sh.taxdb.Name(taxid), taxid))
+	}
+	return ", did you mean: " + strings.Join(items, ", ")
+}
+
+func (sh *shell) name(arg string) error {
+	if arg == "" {
+		return fmt.Errorf("a name needed")
+	}
+	taxids := sh.lookupName(arg)
+	if len(taxids) == 0 {
+		return fmt.Errorf("name not found: %s%s", arg, sh.didYouMean(arg))
+	}
+	for _, taxid := range taxids {
+		sh.printTaxon(taxid, "")
+	}
+	return nil
+}
+
+func (sh *shell) info(arg string) error {
+	taxid, err := sh.taxon(arg)
+	if err != nil {
+		return err
+	}
+	sh.printTaxon(taxid, "")
+	return nil
+}
+
+func (sh *shell) lineage(arg string) error {
+	taxid, err := sh.taxon(arg)
+	if err != nil {
+		return err
+	}
+	for i, tax := range sh.taxdb.LineageTaxIds(taxid) {
+		sh.printTaxon(tax, strings.Repeat("  ", i))
+	}
+	return nil
+}
+
+func (sh *shell) children(arg string) error {
+	taxid, err := sh.taxon(arg)
+	if err != nil {
+		return err
+	}
+	children := sh.taxdb.Children(taxid)
+	for _, child := range children {
+		sh.printTaxon(child, "")
+	}
+	sh.printf("%d children\n", len(children))
+	return nil
+}
+
+func (sh *shell) cd(arg string) error {
+	switch arg {
+	case "", "/":
+		sh.current = sh.taxdb.Root()
+		return nil
+	case "..":
+		if parent, ok := sh.taxdb.Parent(sh.current); ok {
+			sh.current = parent
+		}
+		return nil
+	}
+	taxid, err := sh.taxon(arg)
+	if err != nil {
+		return err
+	}
+	sh.current = taxid
+	return nil
+}
+
+func (sh *shell) lca(arg string) error {
+	items := strings.FieldsFunc(arg, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+	if len(items) == 0 {
+		return fmt.Errorf("TaxIds needed")
+	}
+	taxids := make([]uint32, 0, len(items))
+	for _, item := range items {
+		if _, err := strconv.ParseUint(item, 10, 32); err != nil {
+			return fmt.Errorf("invalid TaxId: %s", item)
+		}
+		taxid, err := sh.taxon(item)
+		if err != nil {
+			return err
+		}
+		taxids = append(taxids, taxid)
+	}
+	lca := sh.taxdb.LCAOf(taxids)
+	if lca == 0 {
+		return fmt.Errorf("no common ancestor found")
+	}
+	sh.printTaxon(lca, "")
+	return nil
+}
+
+func (sh *shell) rankCounts(arg string) error {
+	taxid, err := sh.taxon(arg)
+	if err != nil {
+		return err
+	}
+
+	var taxids []uint32
+	if taxid == sh.taxdb.Root() {
+		taxids = sh.taxdb.TaxIds()
+	} else {
+		taxids = []uint32{taxid}
+		for i := 0; i < len(taxids); i++ {
+			taxids = append(taxids, sh.taxdb.Children(taxids[i])...)
+		}
+	}
+
+	ranks, counts := countRanks(sh.taxdb, taxids)
+	for _, rank := range ranks {
+		sh.printf("%s\t%d\n", rank, counts[rank])
+	}
+	sh.printf("%d nodes in %d ranks\n", len(taxids), len(ranks))
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+
+// maxCandidates is the maximum number of candidates shown in completion.
+const maxCandidates = 30
+
+// complete completes commands and taxon names with Tab.
+func (sh *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	prefix, suffix := line[:pos], line[pos:]
+	i := strings.IndexAny(prefix, " \t")
+	if i < 0 { // commands
+		var candidates []string
+		for _, c := range shellCommands {
+			if strings.HasPrefix(c, prefix) {
+				candidates = append(candidates, c)
+			}
+		}
+		if len(candidates) == 1 {
+			candidates[0] += " "
+		}
+		completed := sh.completeWith(prefix, candidates, candidates)
+		return completed + suffix, len(completed), true
+	}
+
+	command := prefix[:i]
+	if !shellTaxonCommands[strings.ToLower(command)] {
+		return "", 0, false
+	}
+	arg := strings.TrimLeft(prefix[i:], " \t")
+	head := prefix[:len(prefix)-len(arg)]
+	if arg == "" {
+		return "", 0, false
+	}
+	if _, err := strconv.Atoi(arg); err == nil {
+		return "", 0, false
+	}
+
+	sh.prepareNames()
+	lower := strings.ToLower(arg)
+	start := sort.SearchStrings(sh.lowerNames, lower)
+	end := start
+	for end < len(sh.lowerNames) && strings.HasPrefix(sh.lowerNames[end], lower) {
+		end++
+	}
+	completed := sh.completeWith(lower, sh.names[start:end], sh.lowerNames[start:end])
+	if completed == lower { // keep the case of the input
+		completed = arg
+	}
+	return head + completed + suffix, len(head) + len(completed), true
+}
+
+// completeWith returns the longest common prefix of candidates,
+// and shows the candidates if the prefix can't be extended.
+// lowers are the candidates in lowercase for comparing.
+func (sh *shell) completeWith(prefix string, candidates []string, lowers []string) string {
+	switch len(candidates) {
+	case 0:
+		return prefix
+	case 1:
+		return candidates[0]
+	}
+
+	common := lowers[0]
+	for _, c := range lowers[1:] {
+		j := 0
+		for j < len(common) && j < len(c) && common[j] == c[j] {
+			j++
+		}
+		common = common[:j]
+	}
+	if len(common) > len(prefix) {
+		if len(candidates[0]) == len(lowers[0]) {
+			return candidates[0][:len(common)]
+		}
+		return common
+	}
+
+	var b strings.Builder
+	for i, c := range candidates {
+		if i == maxCandidates {
+			fmt.Fprintf(&b, "... and %d more\n", len(candidates)-maxCandidates)
+			break
+		}
+		b.WriteString(c + "\n")
+	}
+	sh.printf("%s", b.String())
+	return prefix
+}
+
+// prepareNames creates the sorted list of unique scientific names for completion.
+func (sh *shell) prepareNames() {
+	if sh.names != nil {
+		return
+	}
+	taxids := sh.taxdb.TaxIds()
+	lowers := make(map[string]string, len(taxids))
+	var name string
+	for _, taxid := range taxids {
+		if name = sh.taxdb.Name(taxid); name != "" {
+			lowers[strings.ToLower(name)] = name
+		}
+	}
+	sh.lowerNames = make([]string, 0, len(lowers))
+	for lower := range lowers {
+		sh.lowerNames = append(sh.lowerNames, lower)
+	}
+	sort.Strings(sh.lowerNames)
+	sh.names = make([]string, len(sh.lowerNames))
+	for i, lower := range sh.lowerNames {
+		sh.names[i] = lowers[lower]
+	}
+}
+
+// ---------------------------------------------------------------------------
+
+// shellHistory is the command history saved in a file, it implements term.History.
+type shellHistory struct {
+	entries []string // the most recent one is the last
+	max     int
+	fh      *os.File
+}
+
+// newShellHistory reads the history from a file, and appends new entries to it.
+func newShellHistory(file string, max int) (*shellHistory, error) {
+	h := &shellHistory{max: max}
+
+	fh, err := os.Open(file)
+	if err == nil {
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			h.add(scanner.Text())
+		}
+		fh.Close()
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// rewrite the file, so it does not grow infinitely
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	if h.fh, err = os.Create(file); err != nil {
+		return nil, err
+	}
+	for _, entry := range h.entries {
+		if _, err = fmt.Fprintln(h.fh, entry); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (h *shellHistory) add(entry string) bool {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry {
+		return false
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > h.max {
+		h.entries = h.entries[len(h.entries)-h.max:]
+	}
+	return true
+}
+
+// Add adds an entry and saves it to the file.
+func (h *shellHistory) Add(entry string) {
+	if h.add(entry) {
+		fmt.Fprintln(h.fh, h.entries[len(h.entries)-1])
+	}
+}
+
+// Len returns the number of entries.
+func (h *shellHistory) Len() int { return len(h.entries) }
+
+// At returns an entry, 0 for the most recent one.
+func (h *shellHistory) At(idx int) string { return h.entries[len(h.entries)-1-idx] }
+
+// Close closes the history file.
+func (h *shellHistory) Close() error { return h.fh.Close() }
@@ -24,12 +24,15 @@ import (
 	"bytes"
 	"encoding/csv"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
 
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/pathutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -73,7 +76,11 @@ Steps:
         | rush -j 2 -v url=$url 'axel -n 5 {url}/{}' \
             --immediate-output  -c -C download.rush
 
-    # --------- unzip ---------
+    # --------- unzip (optional) ---------
+
+    # archive files (taxdmp_*.zip, or *.tar.gz) are also accepted directly,
+    # and versions are extracted from file names, e.g., 2019-07-01 of taxdmp_2019-07-01.zip.
+    # But reading unzipped files is faster.
 
     ls taxdmp*.zip | rush -j 1 'unzip {} names.dmp nodes.dmp merged.dmp delnodes.dmp -d {@_(.+)\.}'
 
@@ -123,14 +130,14 @@ Output format (CSV):
 		}
 
 		dirs := checkArchives(config, archivePath)
-		createChangelog(config, archivePath, dirs)
+		createChangelog(config, dirs)
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(taxidlogCmd)
 
-	taxidlogCmd.Flags().StringP("archive", "i", "", "directory containing uncompressed dumped archives")
+	taxidlogCmd.Flags().StringP("archive", "i", "", "directory containing uncompressed dumped archives, or taxdump archive files (taxdmp_*.zip or *.tar.gz)")
 }
 
 // TaxidChangeCode represents code of taxid change type
@@ -287,7 +294,7 @@ func (c TaxidChange) String() string {
 	return buf.String()
 }
 
-func createChangelog(config Config, path string, dirs []string) {
+func createChangelog(config Config, dirs []dumpVersion) {
 	outfh, err := xopen.Wopen(config.OutFile)
 	checkError(err)
 	defer outfh.Close()
@@ -310,8 +317,11 @@ func createChangelog(config Config, path string, dirs []string) {
 	taxid2ranks := make(map[int16]map[uint32]string, len(dirs))
 
 	// versions
-	sort.Strings(dirs)
-	versions := dirs
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].name < dirs[j].name })
+	versions := make([]string, len(dirs))
+	for i, dir := range dirs {
+		versions[i] = dir.name
+	}
 
 	var ok bool
 	var changes []TaxidChange
@@ -321,7 +331,7 @@ func createChangelog(config Config, path string, dirs []string) {
 	var toRecord bool
 	for version, dir := range dirs {
 		if config.Verbose {
-			log.Infof("parsing archive (%2d / %2d): %s", version+1, len(dirs), dir)
+			log.Infof("parsing archive (%2d / %2d): %s", version+1, len(dirs), dir.path)
 		}
 
 		if config.Verbose {
@@ -334,55 +344,42 @@ func createChangelog(config Config, path string, dirs []string) {
 		var delTaxids []uint32
 		var merges [][2]uint32
 
+		readers := openDumpVersion(dir, "nodes.dmp", "names.dmp", "delnodes.dmp", "merged.dmp")
+		check := func(file string, err error) {
+			if err != nil {
+				checkError(fmt.Errorf("%s: %s: %s", dir.path, file, err))
+			}
+		}
+
 		var wg sync.WaitGroup
 		wg.Add(4)
 		go func() {
-			_path := filepath.Join(path, dir, "nodes.dmp")
-			_pathGz := _path + ".gz"
-			if existed, err := pathutil.Exists(_pathGz); err != nil {
-				checkError(fmt.Errorf("checking %s: %s", _pathGz, err))
-			} else if existed {
-				taxid2lineageTaxids, taxid2rank = getTaxid2LineageTaxids(_pathGz)
-			} else {
-				taxid2lineageTaxids, taxid2rank = getTaxid2LineageTaxids(_path)
-			}
-			wg.Done()
+			defer wg.Done()
+			defer readers[0].Close()
+			var err error
+			taxid2lineageTaxids, taxid2rank, err = getTaxid2LineageTaxids(readers[0])
+			check("nodes.dmp", err)
 		}()
 		go func() {
-			_path := filepath.Join(path, dir, "names.dmp")
-			_pathGz := _path + ".gz"
-			if existed, err := pathutil.Exists(_pathGz); err != nil {
-				checkError(fmt.Errorf("checking %s: %s", _pathGz, err))
-			} else if existed {
-				taxid2name = getTaxonNames(_pathGz)
-			} else {
-				taxid2name = getTaxonNames(_path)
-			}
-			wg.Done()
+			defer wg.Done()
+			defer readers[1].Close()
+			var err error
+			taxid2name, err = taxonomy.ParseNames(readers[1])
+			check("names.dmp", err)
 		}()
 		go func() {
-			_path := filepath.Join(path, dir, "delnodes.dmp")
-			_pathGz := _path + ".gz"
-			if existed, err := pathutil.Exists(_pathGz); err != nil {
-				checkError(fmt.Errorf("checking %s: %s", _pathGz, err))
-			} else if existed {
-				delTaxids = getDelnodes(_pathGz)
-			} else {
-				delTaxids = getDelnodes(_path)
-			}
-			wg.Done()
+			defer wg.Done()
+			defer readers[2].Close()
+			var err error
+			delTaxids, err = getDelnodes(readers[2])
+			check("delnodes.dmp", err)
 		}()
 		go func() {
-			_path := filepath.Join(path, dir, "merged.dmp")
-			_pathGz := _path + ".gz"
-			if existed, err := pathutil.Exists(_pathGz); err != nil {
-				checkError(fmt.Errorf("checking %s: %s", _pathGz, err))
-			} else if existed {
-				merges = getMergedNodes(_pathGz)
-			} else {
-				merges = getMergedNodes(_path)
-			}
-			wg.Done()
+			defer wg.Done()
+			defer readers[3].Close()
+			var err error
+			merges, err = getMergedNodes(readers[3])
+			check("merged.dmp", err)
 		}()
 		wg.Wait()
 
@@ -693,7 +690,17 @@ func createChangelog(config Config, path string, dirs []string) {
 	}
 }
 
-func checkArchives(config Config, path string) []string {
+// dumpVersion is a version of taxdump files, in a directory or a taxdump archive.
+type dumpVersion struct {
+	name    string // version, e.g., 2019-07-01
+	path    string // directory or archive file
+	archive *taxonomy.Archive
+}
+
+// reVersionInArchive extracts versions from archive names like taxdmp_2019-07-01.zip.
+var reVersionInArchive = regexp.MustCompile(`_(.+?)(\.tar\.gz|\.tgz|\.zip)$`)
+
+func checkArchives(config Config, path string) []dumpVersion {
 	checkFile(path)
 
 	_, err := ioutil.ReadFile(path)
@@ -708,8 +715,9 @@ func checkArchives(config Config, path string) []string {
 		log.Warning(err)
 	}
 
-	var filename string
-	dirs := make([]string, 0, len(files))
+	var filename, name string
+	dirs := make([]dumpVersion, 0, len(files))
+	names := make(map[string]string, len(files))
 	for _, file := range files {
 		filename = file.Name()
 
@@ -717,18 +725,37 @@ func checkArchives(config Config, path string) []string {
 			continue
 		}
 
+		var dir dumpVersion
 		if file.IsDir() {
-			dirs = append(dirs, filename)
+			dir = dumpVersion{name: filename, path: filepath.Join(path, filename)}
+
+			checkFile(filepath.Join(dir.path, "names.dmp"))
+			checkFile(filepath.Join(dir.path, "nodes.dmp"))
+			checkFile(filepath.Join(dir.path, "delnodes.dmp"))
+			checkFile(filepath.Join(dir.path, "merged.dmp"))
+		} else if taxonomy.IsArchive(filename) {
+			if m := reVersionInArchive.FindStringSubmatch(filename); m != nil {
+				name = m[1]
+			} else {
+				name = filename[:strings.Index(filename, ".")]
+			}
+			dir = dumpVersion{name: name, path: filepath.Join(path, filename)}
+
+			dir.archive, err = taxonomy.NewArchive(dir.path)
+			checkError(err)
+		} else {
+			continue
+		}
+
+		if another, ok := names[dir.name]; ok {
+			checkError(fmt.Errorf("duplicated version %s: %s and %s", dir.name, another, dir.path))
 		}
+		names[dir.name] = dir.path
+
+		dirs = append(dirs, dir)
 	}
 	if len(dirs) == 0 {
-		checkError(fmt.Errorf("no unzipped directories found in path: %s", path))
-	}
-	for _, dir := range dirs {
-		checkFile(filepath.Join(path, dir, "names.dmp"))
-		checkFile(filepath.Join(path, dir, "nodes.dmp"))
-		checkFile(filepath.Join(path, dir, "delnodes.dmp"))
-		checkFile(filepath.Join(path, dir, "merged.dmp"))
+		checkError(fmt.Errorf("no unzipped directories or archive files found in path: %s", path))
 	}
 
 	if config.Verbose {
@@ -738,6 +765,34 @@ func checkArchives(config Config, path string) []string {
 	return dirs
 }
 
+// openDumpVersion opens dump files of a version for reading, they should be consumed concurrently.
+// Gzipped dump files are also supported in directories.
+func openDumpVersion(dir dumpVersion, files ...string) []io.ReadCloser {
+	if dir.archive != nil {
+		return dir.archive.OpenMembers(files...)
+	}
+
+	readers := make([]io.ReadCloser, len(files))
+	for i, file := range files {
+		_path := filepath.Join(dir.path, file)
+		_pathGz := _path + ".gz"
+		if existed, err := pathutil.Exists(_pathGz); err != nil {
+			checkError(fmt.Errorf("checking %s: %s", _pathGz, err))
+		} else if existed {
+			_path = _pathGz
+		}
+
+		fh, err := xopen.Ropen(_path)
+		if err == xopen.ErrNoContent {
+			readers[i] = io.NopCloser(strings.NewReader(""))
+			continue
+		}
+		checkError(err)
+		readers[i] = fh
+	}
+	return readers
+}
+
 func checkFile(file string) {
 	if exists, err := pathutil.Exists(file); err != nil {
 		checkError(fmt.Errorf("checking %s: %s", file, err))
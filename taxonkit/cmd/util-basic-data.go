@@ -22,282 +22,216 @@ package cmd
 
 import (
 	"bufio"
+	"fmt"
+	"io"
+	"sort"
 	"strconv"
-	"sync"
+	"strings"
 
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/pathutil"
-	"github.com/shenwei356/xopen"
 )
 
 var mapInitialSize = 8 << 10
 
-func loadData(config Config, loadTree bool, recordRank bool) (
-	map[uint32]uint32,
-	map[uint32]string,
-	map[uint32]string,
-	map[uint32]struct{},
-	map[uint32]uint32,
-) {
-
-	var tree map[uint32]uint32
-	var ranks map[uint32]string
-	var names map[uint32]string
-	var delnodes map[uint32]struct{}
-	var merged map[uint32]uint32
-
-	var wg sync.WaitGroup
-
-	// tree
-	if loadTree {
-		wg.Add(1)
-		go func() {
-			if config.Verbose {
-				log.Infof("parsing nodes file: %s", config.NodesFile)
-			}
-			tree, ranks = getNodes(config.NodesFile, recordRank)
-			if config.Verbose {
-				log.Infof("%d nodes parsed", len(tree))
-			}
-			wg.Done()
-		}()
-	}
-
-	// names
-	wg.Add(1)
-	go func() {
-		if config.Verbose {
-			log.Infof("parsing names file: %s", config.NamesFile)
-		}
-		names = getTaxonNames(config.NamesFile)
-		if config.Verbose {
-			log.Infof("%d names parsed", len(names))
+// loadTaxonomy returns the taxonomy of the data directory, which is backed by
+// the index file if it's up to date, otherwise by the dump files.
+// The given parts are loaded in parallel right now, others are loaded on demand.
+func loadTaxonomy(opt *Config, parts taxonomy.Part) *taxonomy.Taxonomy {
+	if opt.Archive != "" {
+		if opt.Verbose {
+			log.Infof("loading Taxonomy from archive: %s", opt.Archive)
 		}
-		wg.Done()
-	}()
+		t, err := taxonomy.OpenArchive(opt.Archive)
+		checkError(err)
+		return prepareTaxonomy(opt, t, parts)
+	}
 
-	wg.Add(1)
-	go func() {
-		if config.Verbose {
-			log.Infof("parsing delnodes file: %s", config.DelNodesFile)
-		}
-		delnodes = getDelnodesMap(config.DelNodesFile)
-		if config.Verbose {
-			log.Infof("%d delnodes parsed", len(delnodes))
-		}
-		wg.Done()
-	}()
+	files := taxonomy.Files{
+		Nodes:    opt.NodesFile,
+		Names:    opt.NamesFile,
+		DelNodes: opt.DelNodesFile,
+		Merged:   opt.MergedFile,
+		Division: opt.DivisionFile,
+		GenCode:  opt.GenCodeFile,
+
+		TaxIdLineage:    opt.TaxIdLineageFile,
+		FullNameLineage: opt.FullNameLineageFile,
+		Host:            opt.HostFile,
+		TypeMaterial:    opt.TypeMaterialFile,
+	}
 
-	wg.Add(1)
-	go func() {
-		if config.Verbose {
-			log.Infof("parsing merged file: %s", config.MergedFile)
-		}
-		merged = getMergedNodesMap(config.MergedFile)
-		if config.Verbose {
-			log.Infof("%d merged nodes parsed", len(merged))
+	// optional files
+	for _, file := range []*string{&files.Division, &files.GenCode,
+		&files.TaxIdLineage, &files.FullNameLineage, &files.Host, &files.TypeMaterial} {
+		existed, err := pathutil.Exists(*file)
+		checkError(err)
+		if !existed {
+			*file = ""
 		}
-		wg.Done()
-	}()
-
-	wg.Wait()
-
-	return tree, ranks, names, delnodes, merged
-}
-
-// taxid -> name
-func getTaxonNames(file string) map[uint32]string {
-	fh, err := xopen.Ropen(file)
-	checkError(err)
-	defer func() {
-		checkError(fh.Close())
-	}()
-
-	taxid2name := make(map[uint32]string, mapInitialSize)
+	}
 
-	items := make([]string, 8)
-	scanner := bufio.NewScanner(fh)
-	var id int
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 8, &items)
-		if len(items) < 8 {
-			continue
-		}
-		if items[6] != "scientific name" {
-			continue
-		}
-		id, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
+	// nodes.dmp and names.dmp can be replaced by taxidlineage.dmp and fullnamelineage.dmp of new_taxdump
+	if files.TaxIdLineage != "" {
+		existed, err := pathutil.Exists(files.Nodes)
+		checkError(err)
+		if !existed {
+			log.Warningf("nodes file not found: %s, using %s without ranks", files.Nodes, files.TaxIdLineage)
+			files.Nodes = ""
 		}
-
-		taxid2name[uint32(id)] = items[2]
 	}
-	if err := scanner.Err(); err != nil {
+	if files.FullNameLineage != "" {
+		existed, err := pathutil.Exists(files.Names)
 		checkError(err)
+		if !existed {
+			log.Warningf("names file not found: %s, using scientific names in %s", files.Names, files.FullNameLineage)
+			files.Names = ""
+		}
 	}
 
-	return taxid2name
-}
-
-// child -> parent. taxid -> rank
-func getNodes(file string, recordRank bool) (map[uint32]uint32, map[uint32]string) {
-	tree := make(map[uint32]uint32, mapInitialSize)
-	var ranks map[uint32]string
-	if recordRank {
-		ranks = make(map[uint32]string, mapInitialSize)
-	}
-
-	fh, err := xopen.Ropen(file)
-	checkError(err)
-	defer func() {
-		checkError(fh.Close())
-	}()
-
-	items := make([]string, 6)
-	scanner := bufio.NewScanner(fh)
-	var _child, _parent int
-	var child, parent uint32
-	var rank string
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 6, &items)
-		if len(items) < 6 {
-			continue
+	t := openTaxonomyIndex(opt, files)
+	if t == nil {
+		if opt.Verbose {
+			log.Infof("loading Taxonomy from: %s", opt.DataDir)
 		}
 
-		_child, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
+		existed, err := pathutil.Exists(files.DelNodes)
+		checkError(err)
+		if !existed {
+			log.Warningf("delnodes file not found: %s, deleted taxids will not be checked", files.DelNodes)
+			files.DelNodes = ""
 		}
 
-		_parent, err = strconv.Atoi(items[2])
-		if err != nil {
-			continue
+		existed, err = pathutil.Exists(files.Merged)
+		checkError(err)
+		if !existed {
+			log.Warningf("merged file not found: %s, merged taxids will not be checked", files.Merged)
+			files.Merged = ""
 		}
-		child, parent, rank = uint32(_child), uint32(_parent), items[4]
 
-		// ----------------------------------
-
-		tree[child] = parent
-		if recordRank {
-			ranks[child] = rank
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
+		t = taxonomy.Open(files)
 	}
 
-	return tree, ranks
+	return prepareTaxonomy(opt, t, parts)
 }
 
-func getRanks(file string) map[uint32]string {
-	ranks := make(map[uint32]string, mapInitialSize)
+// prepareTaxonomy loads the given parts of a taxonomy.
+func prepareTaxonomy(opt *Config, t *taxonomy.Taxonomy, parts taxonomy.Part) *taxonomy.Taxonomy {
+	t.OnLoadError(func(err error) {
+		checkError(fmt.Errorf("failed to load taxonomy data: %s", err))
+	})
+	checkError(t.Load(parts))
 
-	fh, err := xopen.Ropen(file)
-	checkError(err)
-	defer func() {
-		checkError(fh.Close())
-	}()
-
-	items := make([]string, 6)
-	scanner := bufio.NewScanner(fh)
-	var _child int
-	var child uint32
-	var rank string
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 6, &items)
-		if len(items) < 6 {
-			continue
-		}
+	if opt.Verbose {
+		logTaxonomy(t)
+	}
+	return t
+}
 
-		_child, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
+// logTaxonomy reports the sizes of loaded parts.
+func logTaxonomy(t *taxonomy.Taxonomy) {
+	loaded := t.Loaded()
+	if loaded&taxonomy.PartNodes > 0 {
+		if loaded&taxonomy.PartRanks > 0 {
+			log.Infof("  %d nodes in %d ranks loaded", t.NumNodes(), len(t.Ranks()))
+		} else {
+			log.Infof("  %d nodes loaded", t.NumNodes())
 		}
-
-		child, rank = uint32(_child), items[4]
-
-		// ----------------------------------
-
-		ranks[child] = rank
+	} else if loaded&taxonomy.PartRanks > 0 {
+		log.Infof("  %d ranks loaded", len(t.Ranks()))
 	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
+	if loaded&taxonomy.PartNames > 0 {
+		log.Infof("  %d names loaded", t.NumNames())
 	}
-
-	return ranks
-}
-
-func getDelnodes(file string) []uint32 {
-	taxids := make([]uint32, 0, 1<<10)
-
-	existed, err := pathutil.Exists(file)
-	if err != nil {
-		checkError(err)
+	if loaded&taxonomy.PartAllNames > 0 {
+		log.Infof("  %d names in %d name classes loaded", t.NumAllNames(), len(t.NameClasses()))
+	}
+	if loaded&taxonomy.PartDelNodes > 0 {
+		log.Infof("  %d deleted nodes loaded", t.NumDelNodes())
 	}
-	if !existed {
-		log.Warningf("delnodes file not found: %s, deleted taxids will not be checked", file)
-		return taxids
+	if loaded&taxonomy.PartMerged > 0 {
+		log.Infof("  %d merged nodes loaded", t.NumMerged())
 	}
+	if loaded&taxonomy.PartHosts > 0 {
+		log.Infof("  potential hosts of %d nodes loaded", t.NumHosts())
+	}
+	if loaded&taxonomy.PartTypeMaterial > 0 {
+		log.Infof("  type materials of %d nodes loaded", t.NumTypeMaterials())
+	}
+}
 
-	fh, err := xopen.Ropen(file)
-	if err == xopen.ErrNoContent {
-		return taxids
-	} else {
-		checkError(err)
+// nameClassParts returns the parts needed for names in the name classes.
+func nameClassParts(classes []string) taxonomy.Part {
+	if len(classes) == 0 || len(classes) == 1 && classes[0] == taxonomy.ScientificName {
+		return taxonomy.PartNames
 	}
-	defer func() {
-		checkError(fh.Close())
-	}()
+	return taxonomy.PartNames | taxonomy.PartAllNames
+}
 
-	items := make([]string, 2)
+// taxonNameFunc returns a function for getting names of TaxIds in the name classes,
+// which falls back to scientific names.
+func taxonNameFunc(taxdb *taxonomy.Taxonomy, classes []string) func(uint32) string {
+	if nameClassParts(classes)&taxonomy.PartAllNames == 0 {
+		return taxdb.Name
+	}
 
-	scanner := bufio.NewScanner(fh)
-	var id int
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 2, &items)
-		if len(items) < 2 {
-			continue
-		}
-		id, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
+	known := taxdb.NameClasses()
+	for _, class := range classes {
+		if _, ok := known[class]; !ok {
+			available := make([]string, 0, len(known))
+			for c := range known {
+				available = append(available, c)
+			}
+			sort.Strings(available)
+			log.Warningf(`name class "%s" not found, available: "%s"`, class, strings.Join(available, `", "`))
 		}
-
-		taxids = append(taxids, uint32(id))
-	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
 	}
 
-	return taxids
+	return func(taxid uint32) string {
+		return taxdb.NameInClasses(taxid, classes...)
+	}
 }
 
-func getDelnodesMap(file string) map[uint32]struct{} {
-	taxids := make(map[uint32]struct{}, 1<<10)
-
-	existed, err := pathutil.Exists(file)
-	if err != nil {
-		checkError(err)
+// checkNewTaxdumpPart warns if a part only provided by new_taxdump is not available.
+func checkNewTaxdumpPart(taxdb *taxonomy.Taxonomy, part taxonomy.Part, file string) {
+	if taxdb.Available()&part == 0 {
+		log.Warningf("%s not found in the data directory, please use files in new_taxdump.tar.gz: "+
+			"https://ftp.ncbi.nlm.nih.gov/pub/taxonomy/new_taxdump/", file)
 	}
-	if !existed {
-		log.Warningf("delnodes file not found: %s, deleted taxids will not be checked", file)
-		return taxids
+}
+
+// typeMaterialFunc returns a function for getting type material identifiers of TaxIds,
+// only these of the given types are returned if types is not empty.
+func typeMaterialFunc(taxdb *taxonomy.Taxonomy, types []string) func(uint32) []string {
+	_types := make(map[string]interface{}, len(types))
+	for _, t := range types {
+		_types[strings.ToLower(t)] = struct{}{}
 	}
 
-	fh, err := xopen.Ropen(file)
-	if err == xopen.ErrNoContent {
-		return taxids
-	} else {
-		checkError(err)
+	return func(taxid uint32) []string {
+		materials := taxdb.TypeMaterials(taxid)
+		ids := make([]string, 0, len(materials))
+		for _, m := range materials {
+			if len(_types) > 0 {
+				if _, ok := _types[strings.ToLower(m.Type)]; !ok {
+					continue
+				}
+			}
+			ids = append(ids, m.Identifier)
+		}
+		return ids
 	}
-	defer func() {
-		checkError(fh.Close())
-	}()
+}
+
+// getDelnodes parses deleted TaxIds in delnodes.dmp, in the order of the file.
+func getDelnodes(r io.Reader) ([]uint32, error) {
+	taxids := make([]uint32, 0, 1<<10)
 
 	items := make([]string, 2)
 
-	scanner := bufio.NewScanner(fh)
+	scanner := bufio.NewScanner(r)
 	var id int
+	var err error
 	for scanner.Scan() {
 		stringSplitN(scanner.Text(), "\t", 2, &items)
 		if len(items) < 2 {
@@ -308,41 +242,24 @@ func getDelnodesMap(file string) map[uint32]struct{} {
 			continue
 		}
 
-		taxids[uint32(id)] = struct{}{}
+		taxids = append(taxids, uint32(id))
 	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
+	if err = scanner.Err(); err != nil {
+		return nil, err
 	}
 
-	return taxids
+	return taxids, nil
 }
 
-func getMergedNodes(file string) [][2]uint32 {
+// getMergedNodes parses merged TaxIds in merged.dmp, in the order of the file.
+func getMergedNodes(r io.Reader) ([][2]uint32, error) {
 	merges := make([][2]uint32, 0, 1<<10)
 
-	existed, err := pathutil.Exists(file)
-	if err != nil {
-		checkError(err)
-	}
-	if !existed {
-		log.Warningf("merged file not found: %s, merged taxids will not be checked", file)
-		return merges
-	}
-
-	fh, err := xopen.Ropen(file)
-	if err == xopen.ErrNoContent {
-		return merges
-	} else {
-		checkError(err)
-	}
-	defer func() {
-		checkError(fh.Close())
-	}()
-
 	items := make([]string, 4)
 
-	scanner := bufio.NewScanner(fh)
+	scanner := bufio.NewScanner(r)
 	var from, to int
+	var err error
 	for scanner.Scan() {
 		stringSplitN(scanner.Text(), "\t", 4, &items)
 		if len(items) < 4 {
@@ -359,58 +276,9 @@ func getMergedNodes(file string) [][2]uint32 {
 
 		merges = append(merges, [2]uint32{uint32(from), uint32(to)})
 	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
-	}
-
-	return merges
-}
-
-func getMergedNodesMap(file string) map[uint32]uint32 {
-	merges := make(map[uint32]uint32, 1<<10)
-
-	existed, err := pathutil.Exists(file)
-	if err != nil {
-		checkError(err)
-	}
-	if !existed {
-		log.Warningf("merged file not found: %s, merged taxids will not be checked", file)
-		return merges
-	}
-
-	fh, err := xopen.Ropen(file)
-	if err == xopen.ErrNoContent {
-		return merges
-	} else {
-		checkError(err)
-	}
-	defer func() {
-		checkError(fh.Close())
-	}()
-
-	items := make([]string, 4)
-
-	scanner := bufio.NewScanner(fh)
-	var from, to int
-	for scanner.Scan() {
-		stringSplitN(scanner.Text(), "\t", 4, &items)
-		if len(items) < 4 {
-			continue
-		}
-		from, err = strconv.Atoi(items[0])
-		if err != nil {
-			continue
-		}
-		to, err = strconv.Atoi(items[2])
-		if err != nil {
-			continue
-		}
-
-		merges[uint32(from)] = uint32(to)
-	}
-	if err := scanner.Err(); err != nil {
-		checkError(err)
+	if err = scanner.Err(); err != nil {
+		return nil, err
 	}
 
-	return merges
+	return merges, nil
 }
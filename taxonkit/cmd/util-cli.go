@@ -30,7 +30,9 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
+	"github.com/shenwei356/taxonkit/taxonomy"
 	"github.com/shenwei356/util/pathutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -46,33 +48,105 @@ type Config struct {
 	Threads      int
 	OutFile      string
 	DataDir      string
+	TaxdumpDir   string // directory of the dump files, a version directory of DataDir for installed ones
 	NodesFile    string
 	NamesFile    string
 	DelNodesFile string
 	MergedFile   string
+	DivisionFile string
+	GenCodeFile  string
+
+	// files of new_taxdump
+	TaxIdLineageFile    string
+	FullNameLineageFile string
+	HostFile            string
+	TypeMaterialFile    string
+
+	Archive      string // taxdump archive file, used instead of the dump files if not empty
+	IndexFile    string
 	Verbose      bool
 	LineBuffered bool
+	OutFormat    outFormat
+
+	TaxIdPolicy *TaxIdPolicy // how to handle merged, deleted and unknown TaxIds
+}
+
+// getDatabasePath returns the path of a named database, or exits if not found.
+func getDatabasePath(name string) string {
+	path, ok := databasePath(name)
+	if !ok {
+		checkError(fmt.Errorf(`database not found: %s, please check with "taxonkit db list"`, name))
+	}
+	return path
 }
 
 func errDataNotFound(dataDir string) {
 	checkError(fmt.Errorf(`taxonomy data not found, please download and uncompress ftp://ftp.ncbi.nih.gov/pub/taxonomy/taxdump.tar.gz, and copy "names.dmp", "nodes.dmp", "delnodes.dmp", and "merged.dmp" to %s`, dataDir))
 }
 
+// getDataDir returns the data directory or the taxdump archive to use.
+func getDataDir(cmd *cobra.Command) string {
+	// priority: --db/--data-dir > TAXONKIT_DB > config file > the default database in the registry
+	var val, dataDir string
+	dbName := getFlagString(cmd, "db")
+	dbGiven := cmd.Flags().Lookup("db").Changed
+	dataDirGiven := cmd.Flags().Lookup("data-dir").Changed // users explicitly set the option
+	if dbGiven && dataDirGiven {
+		checkError(fmt.Errorf("flag --db and --data-dir can not be given simultaneously"))
+	}
+	if dbGiven {
+		dataDir = getDatabasePath(dbName)
+	} else if dataDirGiven {
+		dataDir = getFlagString(cmd, "data-dir")
+	} else if val = os.Getenv("TAXONKIT_DB"); val != "" {
+		dataDir = val
+	} else if dbName != "" { // from the config file
+		dataDir = getDatabasePath(dbName)
+	} else {
+		dataDir = getFlagString(cmd, "data-dir")
+		if dataDir == defaulDataDir {
+			if path, ok := defaultDatabase(); ok {
+				dataDir = path
+			}
+		}
+	}
+	dataDir = resolveDatabase(dataDir)
+	dataDir, err := homedir.Expand(dataDir)
+	checkError(err)
+	return dataDir
+}
+
 func getConfigs(cmd *cobra.Command) Config {
+	return getConfigsOfDataDir(cmd, getDataDir(cmd))
+}
+
+// getConfigsOfDataDir returns the configs with the given data directory or taxdump archive,
+// ignoring --data-dir, --db and other sources of the data directory.
+func getConfigsOfDataDir(cmd *cobra.Command, dataDir string) Config {
 	threads := getFlagPositiveInt(cmd, "threads")
 
 	runtime.GOMAXPROCS(threads)
 	sorts.MaxProcs = threads
 
-	var val, dataDir string
-	if val = os.Getenv("TAXONKIT_DB"); val != "" {
-		if cmd.Flags().Lookup("data-dir").Changed { // users explicitly set the option
-			dataDir = getFlagString(cmd, "data-dir")
-		} else {
-			dataDir = val
+	// a taxdump archive file, e.g., taxdump.tar.gz
+	if taxonomy.IsArchive(dataDir) {
+		existed, err := pathutil.Exists(dataDir)
+		checkError(err)
+		if !existed {
+			checkError(fmt.Errorf("taxdump archive not found: %s", dataDir))
+		}
+		return Config{
+			Threads: threads,
+			OutFile: getFlagString(cmd, "out-file"),
+			DataDir: dataDir,
+			Archive: dataDir,
+
+			Verbose:      getFlagBool(cmd, "verbose"),
+			LineBuffered: getFlagBool(cmd, "line-buffered"),
+			OutFormat:    getOutFormat(cmd),
+
+			TaxIdPolicy: getTaxIdPolicy(cmd),
 		}
-	} else {
-		dataDir = getFlagString(cmd, "data-dir")
 	}
 
 	whiteList := []string{"create-taxdump", "taxid-changelog"}
@@ -92,34 +166,65 @@ func getConfigs(cmd *cobra.Command) Config {
 		errDataNotFound(dataDir)
 	}
 
-	nodesFile := filepath.Join(dataDir, "nodes.dmp")
+	// the version in use of taxdump files installed by "taxonkit db install",
+	// which is resolved only once, so all files are from the same version.
+	taxdumpDir, err := currentTaxdumpDir(dataDir)
+	checkError(err)
+
+	// taxidlineage.dmp and fullnamelineage.dmp of new_taxdump can be used
+	// when nodes.dmp or names.dmp is absent.
+	taxidLineageFile := filepath.Join(taxdumpDir, "taxidlineage.dmp")
+	fullNameLineageFile := filepath.Join(taxdumpDir, "fullnamelineage.dmp")
+
+	nodesFile := filepath.Join(taxdumpDir, "nodes.dmp")
 	existed, err = pathutil.Exists(nodesFile)
 	checkError(err)
 	if !existed && !skipCheckingDataDir {
-		errDataNotFound(dataDir)
+		existed, err = pathutil.Exists(taxidLineageFile)
+		checkError(err)
+		if !existed {
+			errDataNotFound(dataDir)
+		}
 	}
 
-	namesFile := filepath.Join(dataDir, "names.dmp")
+	namesFile := filepath.Join(taxdumpDir, "names.dmp")
 	existed, err = pathutil.Exists(namesFile)
 	checkError(err)
 	if !existed && !skipCheckingDataDir {
-		errDataNotFound(dataDir)
+		existed, err = pathutil.Exists(fullNameLineageFile)
+		checkError(err)
+		if !existed {
+			errDataNotFound(dataDir)
+		}
 	}
 
-	delNodesFile := filepath.Join(dataDir, "delnodes.dmp")
-	mergedFile := filepath.Join(dataDir, "merged.dmp")
+	delNodesFile := filepath.Join(taxdumpDir, "delnodes.dmp")
+	mergedFile := filepath.Join(taxdumpDir, "merged.dmp")
 
 	return Config{
 		Threads:      threads,
 		OutFile:      getFlagString(cmd, "out-file"),
 		DataDir:      dataDir,
+		TaxdumpDir:   taxdumpDir,
 		NodesFile:    nodesFile,
 		NamesFile:    namesFile,
 		DelNodesFile: delNodesFile,
 		MergedFile:   mergedFile,
+		DivisionFile: filepath.Join(taxdumpDir, "division.dmp"),
+		GenCodeFile:  filepath.Join(taxdumpDir, "gencode.dmp"),
+
+		TaxIdLineageFile:    taxidLineageFile,
+		FullNameLineageFile: fullNameLineageFile,
+		HostFile:            filepath.Join(taxdumpDir, "host.dmp"),
+		TypeMaterialFile:    filepath.Join(taxdumpDir, "typematerial.dmp"),
+
+		IndexFile: filepath.Join(taxdumpDir, taxonomy.IndexFileName),
 
 		Verbose:      getFlagBool(cmd, "verbose"),
 		LineBuffered: getFlagBool(cmd, "line-buffered"),
+		OutFormat:    getOutFormat(cmd),
+
+		TaxIdPolicy: getTaxIdPolicy(cmd),
 	}
 }
 
@@ -0,0 +1,200 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mitchellh/go-homedir"
+	"github.com/shenwei356/util/pathutil"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// configFileName is the name of the config file in the default data directory.
+const configFileName = "config.toml"
+
+// UserConfig is the content of the config file, which sets default values of flags,
+// and names of taxonomy databases. Keys of flags are their long names.
+//
+//	[global]
+//	data-dir = "~/.taxonkit/"
+//	threads = 8
+//
+//	[command.reformat2]
+//	format = "{domain|superkingdom};{phylum};{class};{order};{family};{genus};{species}"
+//
+//	[command.filter]
+//	black-list = ["no rank", "clade"]
+//
+//	[databases]
+//	gtdb = "~/db/gtdb-taxdump/R220"
+type UserConfig struct {
+	// default values of flags for all commands, only applied to commands with the flags.
+	Global map[string]interface{} `toml:"global"`
+	// default values of flags for each command, keys are the command paths without
+	// "taxonkit", e.g., "reformat2", they override these in Global.
+	Commands map[string]map[string]interface{} `toml:"command"`
+	// names of databases -> data directories or taxdump archive files.
+	Databases map[string]string `toml:"databases"`
+
+	file string
+}
+
+// userConfig is loaded before running any command, it's empty if the config file does not exist.
+var userConfig = &UserConfig{}
+
+// configFile returns the path of the config file, which can be set by
+// the environment variable TAXONKIT_CONFIG.
+func configFile() (file string, fromEnv bool) {
+	if val := os.Getenv("TAXONKIT_CONFIG"); val != "" {
+		return val, true
+	}
+	return filepath.Join(defaulDataDir, configFileName), false
+}
+
+// loadUserConfig reads the config file, a missing file is only an error
+// when it's given by TAXONKIT_CONFIG.
+func loadUserConfig() (*UserConfig, error) {
+	file, fromEnv := configFile()
+
+	existed, err := pathutil.Exists(file)
+	if err != nil {
+		return nil, err
+	}
+	if !existed {
+		if fromEnv {
+			return nil, fmt.Errorf("config file not found: %s", file)
+		}
+		return &UserConfig{}, nil
+	}
+
+	config := &UserConfig{file: file}
+	md, err := toml.DecodeFile(file, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %s", file, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return nil, fmt.Errorf("unknown keys in config file %s: %s", file, strings.Join(keys, ", "))
+	}
+
+	for name, path := range config.Databases {
+		if config.Databases[name], err = homedir.Expand(path); err != nil {
+			return nil, fmt.Errorf("config file %s: database %s: %s", file, name, err)
+		}
+	}
+
+	return config, nil
+}
+
+// commandKey returns the key of a command in the config file, e.g., "reformat2".
+func commandKey(cmd *cobra.Command) string {
+	return strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+}
+
+// applyUserConfig sets default values of flags from the config file,
+// flags explicitly given in the command line are not changed.
+// Values from the config file do not mark flags as changed, so they
+// are still overridden by environment variables like TAXONKIT_DB.
+func applyUserConfig(cmd *cobra.Command, config *UserConfig) error {
+	flags := cmd.Flags()
+
+	apply := func(values map[string]interface{}, strict bool) error {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			flag := flags.Lookup(key)
+			if flag == nil {
+				if strict {
+					return fmt.Errorf("config file %s: unknown flag for command \"%s\": %s", config.file, commandKey(cmd), key)
+				}
+				continue // global options are only for commands with the flags
+			}
+			if flag.Changed {
+				continue
+			}
+
+			if err := setFlagValue(flag, values[key]); err != nil {
+				return fmt.Errorf("config file %s: flag %s: %s", config.file, key, err)
+			}
+			flag.Changed = false
+		}
+		return nil
+	}
+
+	if err := apply(config.Global, false); err != nil {
+		return err
+	}
+	if values, ok := config.Commands[commandKey(cmd)]; ok {
+		if err := apply(values, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFlagValue sets a flag with a value from the config file.
+func setFlagValue(flag *pflag.Flag, value interface{}) error {
+	list, isList := value.([]interface{})
+
+	if sv, ok := flag.Value.(pflag.SliceValue); ok {
+		if !isList {
+			list = []interface{}{value}
+		}
+		vals := make([]string, len(list))
+		for i, v := range list {
+			vals[i] = fmt.Sprintf("%v", v)
+		}
+		return sv.Replace(vals)
+	}
+
+	if isList {
+		return fmt.Errorf("a single value expected")
+	}
+	return flag.Value.Set(fmt.Sprintf("%v", value))
+}
+
+// resolveDatabase returns the path of a database name in the registry or the config file,
+// a name is only used when no file or directory of it exists.
+func resolveDatabase(dataDir string) string {
+	path, ok := databasePath(dataDir)
+	if !ok {
+		return dataDir
+	}
+	existed, err := pathutil.Exists(dataDir)
+	checkError(err)
+	if existed {
+		return dataDir
+	}
+	return path
+}
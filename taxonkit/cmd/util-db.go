@@ -0,0 +1,288 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/shenwei356/util/pathutil"
+)
+
+// dbRegistryFileName is the name of the database registry file in the default data directory.
+const dbRegistryFileName = "databases.toml"
+
+// dbDirName is the directory in the default data directory for storing databases.
+const dbDirName = "db"
+
+// dbMetadataFileName is the name of the metadata file of taxdump files installed by "taxonkit db install".
+const dbMetadataFileName = "taxdump-info.toml"
+
+// Versions of taxdump files installed by "taxonkit db install" are stored in
+// "versions/<date>_<version ID>/" of a data directory, and the one in use is
+// named in the pointer file "taxdump-current", which is switched atomically
+// by renaming. The version for rollback is named in "taxdump-previous".
+const (
+	taxdumpVersionsDirName  = "versions"
+	currentPointerFileName  = "taxdump-current"
+	previousPointerFileName = "taxdump-previous"
+)
+
+// readTaxdumpPointer returns the version named in a pointer file of a data directory,
+// an empty string is returned if the pointer file does not exist.
+func readTaxdumpPointer(dir, pointer string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, pointer))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	version := strings.TrimSpace(string(data))
+	if version == "" || version == "." || version == ".." || filepath.Base(version) != version {
+		return "", fmt.Errorf("invalid taxdump version in %s: %s", filepath.Join(dir, pointer), version)
+	}
+	return version, nil
+}
+
+// writeTaxdumpPointer points a pointer file of a data directory to a version.
+// It's written to a temporary file and renamed, so readers see either the old
+// version or the new one.
+func writeTaxdumpPointer(dir, pointer, version string) error {
+	fh, err := os.CreateTemp(dir, pointer+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintln(fh, version); err != nil {
+		fh.Close()
+		os.Remove(fh.Name())
+		return err
+	}
+	if err = fh.Close(); err != nil {
+		os.Remove(fh.Name())
+		return err
+	}
+	if err = os.Chmod(fh.Name(), 0644); err != nil {
+		os.Remove(fh.Name())
+		return err
+	}
+	if err = os.Rename(fh.Name(), filepath.Join(dir, pointer)); err != nil {
+		os.Remove(fh.Name())
+		return err
+	}
+	return nil
+}
+
+// taxdumpVersionDir returns the directory of a taxdump version in a data directory.
+func taxdumpVersionDir(dir, version string) string {
+	return filepath.Join(dir, taxdumpVersionsDirName, version)
+}
+
+// currentTaxdumpDir returns the directory of taxdump files in use in a data directory,
+// i.e., the version directory named in "taxdump-current", or the data directory itself
+// for files not installed by "taxonkit db install".
+func currentTaxdumpDir(dir string) (string, error) {
+	version, err := readTaxdumpPointer(dir, currentPointerFileName)
+	if err != nil || version == "" {
+		return dir, err
+	}
+	return taxdumpVersionDir(dir, version), nil
+}
+
+// DBMetadata records where taxdump files installed or created by taxonkit come from.
+type DBMetadata struct {
+	Source    string    `toml:"source,omitempty"`
+	Date      string    `toml:"date,omitempty"`
+	MD5       string    `toml:"md5,omitempty"`
+	Installed time.Time `toml:"installed,omitempty"`
+
+	// version ID of the dump files, and states of the files when it was computed
+	VersionID    string         `toml:"version_id,omitempty"`
+	VersionFiles []versionStamp `toml:"version_files,omitempty"`
+}
+
+// readDBMetadata reads the metadata file in a data directory, nil is returned if it does not exist.
+func readDBMetadata(dir string) (*DBMetadata, error) {
+	file := filepath.Join(dir, dbMetadataFileName)
+	existed, err := pathutil.Exists(file)
+	if err != nil || !existed {
+		return nil, err
+	}
+	meta := &DBMetadata{}
+	if _, err = toml.DecodeFile(file, meta); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata file %s: %s", file, err)
+	}
+	return meta, nil
+}
+
+// writeDBMetadata writes the metadata file in a data directory.
+// It's written to a temporary file and renamed, as other processes might be reading it.
+func writeDBMetadata(dir string, meta *DBMetadata) error {
+	fh, err := os.CreateTemp(dir, dbMetadataFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err = toml.NewEncoder(fh).Encode(meta); err != nil {
+		fh.Close()
+		os.Remove(fh.Name())
+		return err
+	}
+	if err = fh.Close(); err != nil {
+		os.Remove(fh.Name())
+		return err
+	}
+	if err = os.Chmod(fh.Name(), 0644); err != nil {
+		os.Remove(fh.Name())
+		return err
+	}
+	if err = os.Rename(fh.Name(), filepath.Join(dir, dbMetadataFileName)); err != nil {
+		os.Remove(fh.Name())
+		return err
+	}
+	return nil
+}
+
+// Database is a named taxonomy database in the registry.
+type Database struct {
+	Path        string    `toml:"path"`   // data directory or taxdump archive file
+	Source      string    `toml:"source"` // where the data comes from, e.g., a URL
+	Date        string    `toml:"date"`   // release date of the data
+	Added       time.Time `toml:"added"`
+	Description string    `toml:"description,omitempty"`
+	Managed     bool      `toml:"managed,omitempty"` // whether the files are stored in the db directory
+}
+
+// DBRegistry records named taxonomy databases.
+type DBRegistry struct {
+	Default   string               `toml:"default,omitempty"`
+	Databases map[string]*Database `toml:"databases"`
+
+	file string
+}
+
+var reDBName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// checkDBName checks if a database name is valid, which is also used as a directory name.
+func checkDBName(name string) error {
+	if !reDBName.MatchString(name) {
+		return fmt.Errorf("invalid database name: %s, only letters, digits, '.', '_' and '-' are allowed", name)
+	}
+	return nil
+}
+
+// dbRegistryFile returns the path of the database registry file.
+func dbRegistryFile() string {
+	return filepath.Join(defaulDataDir, dbRegistryFileName)
+}
+
+// managedDBDir returns the directory for storing files of a database.
+func managedDBDir(name string) string {
+	return filepath.Join(defaulDataDir, dbDirName, name)
+}
+
+// loadDBRegistry reads the database registry, a missing file means an empty registry.
+func loadDBRegistry() (*DBRegistry, error) {
+	file := dbRegistryFile()
+	registry := &DBRegistry{Databases: make(map[string]*Database), file: file}
+
+	existed, err := pathutil.Exists(file)
+	if err != nil {
+		return nil, err
+	}
+	if !existed {
+		return registry, nil
+	}
+
+	if _, err = toml.DecodeFile(file, registry); err != nil {
+		return nil, fmt.Errorf("failed to parse database registry %s: %s", file, err)
+	}
+	if registry.Databases == nil {
+		registry.Databases = make(map[string]*Database)
+	}
+	return registry, nil
+}
+
+// Save writes the registry to a temporary file first, then renames it.
+func (r *DBRegistry) Save() error {
+	if err := os.MkdirAll(filepath.Dir(r.file), 0777); err != nil {
+		return err
+	}
+
+	tmp := r.file + ".tmp"
+	fh, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = toml.NewEncoder(fh).Encode(r); err != nil {
+		fh.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = fh.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, r.file)
+}
+
+// Names returns sorted names of databases.
+func (r *DBRegistry) Names() []string {
+	names := make([]string, 0, len(r.Databases))
+	for name := range r.Databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// databasePath returns the path of a named database, from the registry
+// or the config file.
+func databasePath(name string) (string, bool) {
+	registry, err := loadDBRegistry()
+	checkError(err)
+	if db, ok := registry.Databases[name]; ok {
+		return db.Path, true
+	}
+	if path, ok := userConfig.Databases[name]; ok {
+		return path, true
+	}
+	return "", false
+}
+
+// defaultDatabase returns the path of the default database in the registry.
+func defaultDatabase() (string, bool) {
+	registry, err := loadDBRegistry()
+	checkError(err)
+	if registry.Default == "" {
+		return "", false
+	}
+	db, ok := registry.Databases[registry.Default]
+	if !ok {
+		log.Warningf("default database not found in registry %s: %s", registry.file, registry.Default)
+		return "", false
+	}
+	return db.Path, true
+}
@@ -0,0 +1,234 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/breader"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// addHeaderFlag adds the flag -H/--header to a command.
+// It should be called after other flags are added, as the shorthand is
+// omitted if it's already used, e.g., -H/--higher-than of "taxonkit filter".
+func addHeaderFlag(cmd *cobra.Command) {
+	shorthand := "H"
+	if cmd.Flags().ShorthandLookup(shorthand) != nil {
+		shorthand = ""
+	}
+	cmd.Flags().BoolP("header", shorthand, false,
+		"the first line of each input file is a header line, which is passed through with names of new columns appended. "+
+			"fields can also be given by column names")
+}
+
+// inputField is a field of tab-delimited input given by a flag,
+// as a 1-based index, or a column name when the input has a header line.
+type inputField struct {
+	flag  string
+	name  string // column name
+	index int    // 0-based index, -1 for unset fields
+}
+
+// getFlagField returns the field given by a flag. 0 is allowed with allowZero,
+// which means the field is not used.
+func getFlagField(cmd *cobra.Command, flag string, allowZero bool) *inputField {
+	value := getFlagString(cmd, flag)
+	if value == "" {
+		checkError(fmt.Errorf("value of flag --%s should not be empty", flag))
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil { // a column name
+		if !getFlagBool(cmd, "header") {
+			checkError(fmt.Errorf("column name given to flag --%s, please also use --header", flag))
+		}
+		return &inputField{flag: flag, name: value, index: -1}
+	}
+
+	if i < 0 || (i == 0 && !allowZero) {
+		checkError(fmt.Errorf("value of flag --%s should be a positive integer or a column name", flag))
+	}
+	return &inputField{flag: flag, index: i - 1}
+}
+
+// Resolve returns the 0-based index of the field in the columns of a header line.
+func (f *inputField) Resolve(columns []string) int {
+	if f.name == "" {
+		return f.index
+	}
+	for i, c := range columns {
+		if c == f.name {
+			return i
+		}
+	}
+	checkError(fmt.Errorf(`column "%s" (--%s) not found in the header line: %s`, f.name, f.flag, strings.Join(columns, ", ")))
+	return -1
+}
+
+// readLines processes lines of a file with fn in parallel, and returns results
+// in order, like breader.NewBufferedReader. With hasHeader, the first line is
+// read and passed to onHeader before other lines are processed, so fields given
+// by column names can be resolved in onHeader.
+func readLines(file string, hasHeader bool, onHeader func(header string),
+	threads int, chunkSize int, fn func(line string) (interface{}, bool, error)) (<-chan breader.Chunk, error) {
+	if !hasHeader {
+		reader, err := breader.NewBufferedReader(file, threads, chunkSize, fn)
+		if err != nil {
+			return nil, err
+		}
+		return reader.Ch, nil
+	}
+
+	fh, err := xopen.Ropen(file)
+	if err == xopen.ErrNoContent {
+		onHeader("")
+		ch := make(chan breader.Chunk)
+		close(ch)
+		return ch, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := fh.ReadString('\n')
+	if err != nil && err != io.EOF {
+		fh.Close()
+		return nil, err
+	}
+	onHeader(strings.TrimRight(header, "\r\n"))
+	if err == io.EOF {
+		fh.Close()
+		ch := make(chan breader.Chunk)
+		close(ch)
+		return ch, nil
+	}
+
+	return processLines(fh, threads, chunkSize, fn), nil
+}
+
+// processLines reads the rest of lines from an opened file, processes chunks
+// of lines with fn in parallel, and sends the results in order.
+// Like breader, the returned channel is closed after the first error,
+// and reading is stopped then.
+func processLines(fh *xopen.Reader, threads int, chunkSize int, fn func(line string) (interface{}, bool, error)) <-chan breader.Chunk {
+	if threads < 1 {
+		threads = 1
+	}
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+
+	// results of chunks are received in the order of reading
+	results := make(chan chan breader.Chunk, threads)
+	// done is closed when an error is sent, to stop the reading
+	done := make(chan struct{})
+	go func() {
+		defer close(results)
+		defer fh.Close()
+
+		tokens := make(chan struct{}, threads)
+		var id uint64
+		var line string
+		var err error
+		lines := make([]string, 0, chunkSize)
+		for {
+			line, err = fh.ReadString('\n')
+			if line != "" {
+				lines = append(lines, line)
+			}
+			if len(lines) == chunkSize || (err != nil && len(lines) > 0) {
+				result := make(chan breader.Chunk, 1)
+				select {
+				case results <- result:
+				case <-done:
+					return
+				}
+				select {
+				case tokens <- struct{}{}:
+				case <-done:
+					return
+				}
+				go func(id uint64, lines []string) {
+					defer func() { <-tokens }()
+					data := make([]interface{}, 0, len(lines))
+					for _, line := range lines {
+						d, ok, err := fn(line)
+						if err != nil {
+							result <- breader.Chunk{ID: id, Data: data, Err: err}
+							return
+						}
+						if ok {
+							data = append(data, d)
+						}
+					}
+					result <- breader.Chunk{ID: id, Data: data}
+				}(id, lines)
+				id++
+				lines = make([]string, 0, chunkSize)
+			}
+			if err != nil {
+				if err != io.EOF {
+					result := make(chan breader.Chunk, 1)
+					result <- breader.Chunk{ID: id, Err: err}
+					select {
+					case results <- result:
+					case <-done:
+					}
+				}
+				return
+			}
+		}
+	}()
+
+	ch := make(chan breader.Chunk, threads)
+	go func() {
+		defer close(ch)
+		defer close(done)
+		for result := range results {
+			chunk := <-result
+			ch <- chunk
+			if chunk.Err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// WriteHeader writes the header line of the input, with names of new columns appended.
+// Nothing is written for JSON Lines, where fields are named.
+func (w *recordWriter) WriteHeader(header string, names ...string) {
+	if w.format == outJSONL {
+		return
+	}
+
+	fields := make([]outField, 0, len(names)+1)
+	fields = append(fields, outField{Value: outInput(header)})
+	for _, name := range names {
+		fields = append(fields, outField{Value: name})
+	}
+	w.Write(fields...)
+}
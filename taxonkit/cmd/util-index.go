@@ -0,0 +1,68 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+)
+
+// indexSourceFiles returns the dump files recorded in the index, the order matters.
+func indexSourceFiles(config Config) []string {
+	return []string{config.NodesFile, config.NamesFile, config.DelNodesFile, config.MergedFile}
+}
+
+// openTaxonomyIndex returns the taxonomy backed by the index file in the data directory,
+// only if it exists and all dump files are unchanged since it was built.
+// Otherwise nil is returned and callers should parse the dump files.
+func openTaxonomyIndex(config *Config, files taxonomy.Files) *taxonomy.Taxonomy {
+	if config.IndexFile == "" {
+		return nil
+	}
+
+	err := taxonomy.CheckIndex(config.IndexFile, indexSourceFiles(*config))
+	if err != nil {
+		var outdated *taxonomy.OutdatedIndexError
+		switch {
+		case os.IsNotExist(err): // no index
+		case errors.As(err, &outdated):
+			if config.Verbose {
+				log.Infof("index file is outdated, please rebuild it with \"taxonkit index\": %s", config.IndexFile)
+				log.Infof("  changed file: %s", outdated.File)
+			}
+		default:
+			log.Warningf("ignore index file %s: %s", config.IndexFile, err)
+		}
+		return nil
+	}
+
+	if config.Verbose {
+		log.Infof("loading data from index file: %s", config.IndexFile)
+	}
+	t, err := taxonomy.OpenIndex(config.IndexFile, files)
+	if err != nil {
+		log.Warningf("ignore index file %s: %s", config.IndexFile, err)
+		return nil
+	}
+	return t
+}
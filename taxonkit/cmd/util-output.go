@@ -0,0 +1,228 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// outFormat is the format of output records.
+type outFormat int
+
+const (
+	outTSV   outFormat = iota // tab-separated values, the default
+	outCSV                    // comma-separated values, with quoting
+	outJSONL                  // JSON Lines, one object per record
+)
+
+// outFormatCommands are commands supporting --out-format.
+var outFormatCommands = map[string]struct{}{
+	"lineage":    {},
+	"reformat":   {},
+	"reformat2":  {},
+	"name2taxid": {},
+	"lca":        {},
+	"filter":     {},
+	"gencode":    {},
+	"list":       {},
+}
+
+func getOutFormat(cmd *cobra.Command) outFormat {
+	var format outFormat
+	switch s := strings.ToLower(getFlagString(cmd, "out-format")); s {
+	case "tsv":
+		return outTSV
+	case "csv":
+		format = outCSV
+	case "jsonl":
+		format = outJSONL
+	default:
+		checkError(fmt.Errorf(`invalid value of flag --out-format: %s, available: "tsv", "csv" or "jsonl"`, s))
+	}
+	if _, ok := outFormatCommands[cmd.Name()]; !ok {
+		checkError(fmt.Errorf("flag --out-format is not supported by the command: %s", cmd.Name()))
+	}
+	return format
+}
+
+// outInput is the input line echoed in the output.
+// It's written as it is in TSV, split into columns by tabs in CSV,
+// and saved as a string of the key "input" in JSON Lines.
+type outInput string
+
+// outField is a named field of an output record.
+//
+// Supported values: string, outInput, int, uint32, []string, []uint32
+// and nil. Lists are joined with Sep in TSV and CSV, and saved as arrays
+// in JSON Lines. nil is an empty string in TSV and CSV, and null in JSON.
+type outField struct {
+	Key   string
+	Value interface{}
+	Sep   string // separator of list values in TSV and CSV
+
+	JSONOnly bool // only output in JSON Lines, e.g., the status of a TaxId
+}
+
+// recordWriter writes records in TSV, CSV or JSON Lines format.
+type recordWriter struct {
+	fh           *xopen.Writer
+	format       outFormat
+	lineBuffered bool
+
+	buf bytes.Buffer
+	enc *json.Encoder
+	csv *csv.Writer
+	row []string
+}
+
+func newRecordWriter(fh *xopen.Writer, config Config) *recordWriter {
+	w := &recordWriter{
+		fh:           fh,
+		format:       config.OutFormat,
+		lineBuffered: config.LineBuffered,
+	}
+	switch w.format {
+	case outCSV:
+		w.csv = csv.NewWriter(fh)
+		w.row = make([]string, 0, 16)
+	case outJSONL:
+		w.enc = json.NewEncoder(&w.buf)
+		w.enc.SetEscapeHTML(false)
+	}
+	return w
+}
+
+// Write writes a record.
+func (w *recordWriter) Write(fields ...outField) {
+	switch w.format {
+	case outCSV:
+		w.row = w.row[:0]
+		for _, f := range fields {
+			if f.JSONOnly {
+				continue
+			}
+			if v, ok := f.Value.(outInput); ok {
+				w.row = append(w.row, strings.Split(string(v), "\t")...)
+				continue
+			}
+			w.row = append(w.row, fieldString(f))
+		}
+		checkError(w.csv.Write(w.row))
+		w.csv.Flush()
+		checkError(w.csv.Error())
+	case outJSONL:
+		w.buf.Reset()
+		w.buf.WriteByte('{')
+		var first = true
+		for _, f := range fields {
+			if first {
+				first = false
+			} else {
+				w.buf.WriteByte(',')
+			}
+			key := f.Key
+			if _, ok := f.Value.(outInput); ok {
+				key = "input"
+			}
+			w.writeJSON(key)
+			w.buf.WriteByte(':')
+			w.writeJSON(f.Value)
+		}
+		w.buf.WriteString("}\n")
+		w.fh.Write(w.buf.Bytes())
+	default:
+		w.buf.Reset()
+		var first = true
+		for _, f := range fields {
+			if f.JSONOnly {
+				continue
+			}
+			if first {
+				first = false
+			} else {
+				w.buf.WriteByte('\t')
+			}
+			w.buf.WriteString(fieldString(f))
+		}
+		w.buf.WriteByte('\n')
+		w.fh.Write(w.buf.Bytes())
+	}
+
+	if w.lineBuffered {
+		w.fh.Flush()
+	}
+}
+
+// List returns a list value for outField. Items are joined for TSV and CSV
+// in the caller, i.e., the worker goroutines, and kept as a slice for JSON Lines.
+func (w *recordWriter) List(items []string, sep string) interface{} {
+	if w.format == outJSONL {
+		return items
+	}
+	return strings.Join(items, sep)
+}
+
+func fieldString(f outField) string {
+	switch v := f.Value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case outInput:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case []string:
+		return strings.Join(v, f.Sep)
+	case []uint32:
+		items := make([]string, len(v))
+		for i, id := range v {
+			items[i] = strconv.FormatUint(uint64(id), 10)
+		}
+		return strings.Join(items, f.Sep)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// writeJSON appends the JSON encoding of a value to the buffer.
+func (w *recordWriter) writeJSON(value interface{}) {
+	switch v := value.(type) {
+	case int:
+		w.buf.WriteString(strconv.Itoa(v))
+		return
+	case uint32:
+		w.buf.WriteString(strconv.FormatUint(uint64(v), 10))
+		return
+	}
+	checkError(w.enc.Encode(value))
+	w.buf.Truncate(w.buf.Len() - 1) // the newline added by Encode
+}
@@ -0,0 +1,330 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// taxIdAction is the action for merged, deleted or unknown TaxIds.
+type taxIdAction int
+
+const (
+	// actionUse means using the TaxId, or the new one of a merged TaxId ("replace").
+	actionUse taxIdAction = iota
+	// actionKeep means keeping the record as it is, without taxonomic information.
+	actionKeep
+	// actionDrop means dropping the record, or the TaxId in a list of TaxIds.
+	actionDrop
+	// actionFail means stopping with an error.
+	actionFail
+)
+
+func (a taxIdAction) String() string {
+	switch a {
+	case actionKeep:
+		return "keep"
+	case actionDrop:
+		return "drop"
+	case actionFail:
+		return "fail"
+	default:
+		return "replace"
+	}
+}
+
+func parseTaxIdAction(cmd *cobra.Command, flag string, replaceable bool) taxIdAction {
+	action, err := parseTaxIdActionString(getFlagString(cmd, flag), replaceable)
+	if err != nil {
+		checkError(fmt.Errorf("invalid value of flag --%s: %s", flag, err))
+	}
+	return action
+}
+
+// parseTaxIdActionString parses an action, "replace" is only valid for merged TaxIds.
+func parseTaxIdActionString(s string, replaceable bool) (taxIdAction, error) {
+	s = strings.ToLower(s)
+	switch s {
+	case "keep":
+		return actionKeep, nil
+	case "drop":
+		return actionDrop, nil
+	case "fail":
+		return actionFail, nil
+	case "replace":
+		if replaceable {
+			return actionUse, nil
+		}
+	}
+	if replaceable {
+		return actionKeep, fmt.Errorf(`%s, available: "replace", "keep", "drop", "fail"`, s)
+	}
+	return actionKeep, fmt.Errorf(`%s, available: "keep", "drop", "fail"`, s)
+}
+
+// TaxIdPolicy decides how to handle merged, deleted and unknown TaxIds
+// in all commands, which is set by the global flags --on-merged, --on-deleted,
+// --on-unknown and --strict.
+//
+// Each distinct merged, deleted or unknown TaxId is only warned once,
+// a summary is printed at exit, and all of them can be saved to the
+// file given by --unresolved-report.
+type TaxIdPolicy struct {
+	OnMerged  taxIdAction
+	OnDeleted taxIdAction
+	OnUnknown taxIdAction
+	Strict    bool   // exit with a non-zero status if any merged, deleted or unknown TaxId is met
+	Report    string // file for saving merged, deleted and unknown TaxIds
+	Verbose   bool
+
+	mu         sync.Mutex
+	unresolved map[uint32]*unresolvedTaxId
+}
+
+// unresolvedTaxId is a merged, deleted or unknown TaxId met in the input.
+type unresolvedTaxId struct {
+	taxid    uint32
+	newtaxid uint32 // the new TaxId of a merged TaxId
+	status   taxonomy.Status
+	action   taxIdAction
+	count    int // number of occurrences
+}
+
+// taxIdPolicy is the policy of the current run, for summarizing at exit.
+var taxIdPolicy *TaxIdPolicy
+
+func getTaxIdPolicy(cmd *cobra.Command) *TaxIdPolicy {
+	taxIdPolicy = &TaxIdPolicy{
+		OnMerged:  parseTaxIdAction(cmd, "on-merged", true),
+		OnDeleted: parseTaxIdAction(cmd, "on-deleted", false),
+		OnUnknown: parseTaxIdAction(cmd, "on-unknown", false),
+		Strict:    getFlagBool(cmd, "strict"),
+		Report:    getFlagString(cmd, "unresolved-report"),
+		Verbose:   getFlagBool(cmd, "verbose"),
+
+		unresolved: make(map[uint32]*unresolvedTaxId, 1024),
+	}
+	return taxIdPolicy
+}
+
+// Resolve checks a TaxId and applies the policy.
+// It returns the TaxId to use, which is the new one for a merged TaxId,
+// the status of the TaxId, and the action. For actionFail, the process exits
+// after writing the summary and the report.
+func (p *TaxIdPolicy) Resolve(taxdb *taxonomy.Taxonomy, taxid uint32) (uint32, taxonomy.Status, taxIdAction) {
+	newtaxid, status := taxdb.Resolve(taxid)
+
+	action := p.action(status)
+	if status == taxonomy.Found {
+		return newtaxid, status, action
+	}
+
+	p.mu.Lock()
+	if u, ok := p.unresolved[taxid]; ok {
+		u.count++
+	} else {
+		p.unresolved[taxid] = &unresolvedTaxId{taxid, newtaxid, status, action, 1}
+		if action != actionFail {
+			warnTaxId(taxid, newtaxid, status)
+		}
+	}
+	if action == actionFail {
+		// the lock is held, so other goroutines can't write the report at the same time
+		p.report()
+		switch status {
+		case taxonomy.Merged:
+			checkError(fmt.Errorf("taxid %d was merged into %d (--on-merged fail)", taxid, newtaxid))
+		case taxonomy.Deleted:
+			checkError(fmt.Errorf("taxid %d was deleted (--on-deleted fail)", taxid))
+		default:
+			checkError(fmt.Errorf("taxid %d not found (--on-unknown fail)", taxid))
+		}
+	}
+	p.mu.Unlock()
+
+	if status == taxonomy.Merged && action != actionUse {
+		newtaxid = taxid
+	}
+	return newtaxid, status, action
+}
+
+// action returns the action for TaxIds of a status.
+func (p *TaxIdPolicy) action(status taxonomy.Status) taxIdAction {
+	switch status {
+	case taxonomy.Found:
+		return actionUse
+	case taxonomy.Merged:
+		return p.OnMerged
+	case taxonomy.Deleted:
+		return p.OnDeleted
+	default:
+		return p.OnUnknown
+	}
+}
+
+// warnTaxId logs a warning for merged, deleted or unknown TaxIds.
+func warnTaxId(taxid uint32, newtaxid uint32, status taxonomy.Status) {
+	switch status {
+	case taxonomy.Merged:
+		log.Warningf("taxid %d was merged into %d", taxid, newtaxid)
+	case taxonomy.Deleted:
+		log.Warningf("taxid %d was deleted", taxid)
+	case taxonomy.NotFound:
+		log.Warningf("taxid %d not found", taxid)
+	}
+}
+
+// categories of merged, deleted and unknown TaxIds, in the order of the summary and report.
+var unresolvedCategories = []string{"merged", "deleted", "unknown"}
+
+// category returns the index of the category in unresolvedCategories.
+func (u *unresolvedTaxId) category() int {
+	switch u.status {
+	case taxonomy.Merged:
+		return 0
+	case taxonomy.Deleted:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// summarize prints the numbers of merged, deleted and unknown TaxIds,
+// writes the report file, and exits with an error in the strict mode
+// if any of them is met.
+func (p *TaxIdPolicy) summarize() {
+	if p == nil {
+		return
+	}
+
+	n := p.report()
+	if p.Strict && n[0]+n[1]+n[2] > 0 {
+		checkError(fmt.Errorf("strict mode: %d merged, %d deleted and %d unknown taxids met", n[0], n[1], n[2]))
+	}
+}
+
+// report prints the numbers of merged, deleted and unknown TaxIds,
+// and writes the report file. It returns the numbers of the three categories.
+func (p *TaxIdPolicy) report() (n [3]int) {
+	list := make([]*unresolvedTaxId, 0, len(p.unresolved))
+	var occurrences [3]int // merged, deleted, unknown
+	for _, u := range p.unresolved {
+		list = append(list, u)
+		n[u.category()]++
+		occurrences[u.category()] += u.count
+	}
+
+	if p.Report != "" {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].category() == list[j].category() {
+				return list[i].taxid < list[j].taxid
+			}
+			return list[i].category() < list[j].category()
+		})
+
+		outfh, err := xopen.Wopen(p.Report)
+		checkError(err)
+		var replacement string
+		for _, u := range list {
+			replacement = ""
+			if u.status == taxonomy.Merged {
+				replacement = strconv.Itoa(int(u.newtaxid))
+			}
+			fmt.Fprintf(outfh, "%d\t%s\t%s\t%s\t%d\n", u.taxid, unresolvedCategories[u.category()], replacement, u.action, u.count)
+		}
+		checkError(outfh.Close())
+	}
+
+	if len(list) == 0 {
+		return n
+	}
+
+	// only non-zero categories
+	items := make([]string, 0, len(unresolvedCategories))
+	for i, category := range unresolvedCategories {
+		if n[i] > 0 {
+			items = append(items, fmt.Sprintf("%d %s (%d occurrences)", n[i], category, occurrences[i]))
+		}
+	}
+	log.Warningf("summary: %s taxids", strings.Join(items, ", "))
+	if p.Report != "" {
+		log.Warningf("merged, deleted and unknown taxids are saved to %s", p.Report)
+	} else if p.Verbose {
+		log.Infof("you can save them to a file with --unresolved-report")
+	}
+	return n
+}
+
+// getTaxIdRegex returns the regular expression given by --taxid-regex, or nil if it's not given.
+func getTaxIdRegex(cmd *cobra.Command) *regexp.Regexp {
+	s := getFlagString(cmd, "taxid-regex")
+	if s == "" {
+		return nil
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		checkError(fmt.Errorf("invalid value of flag --taxid-regex: %s", err))
+	}
+	if re.NumSubexp() == 0 {
+		checkError(fmt.Errorf(`a capture group is needed in the value of flag --taxid-regex, e.g., "taxid=(\d+)"`))
+	}
+	return re
+}
+
+// extractTaxIds returns TaxIds captured by the first group of a regular expression,
+// for all matches in the text. Captured strings which are not TaxIds are ignored.
+func extractTaxIds(re *regexp.Regexp, text string) []uint32 {
+	matches := re.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	taxids := make([]uint32, 0, len(matches))
+	for _, m := range matches {
+		taxid, err := strconv.ParseUint(strings.TrimSpace(m[1]), 10, 32)
+		if err != nil {
+			continue
+		}
+		taxids = append(taxids, uint32(taxid))
+	}
+	return taxids
+}
+
+// regexField returns the text for extracting TaxIds: the field of a tab-delimited line,
+// or the whole line for a negative field index.
+func regexField(line string, field int) string {
+	if field < 0 {
+		return line
+	}
+	data := strings.Split(line, "\t")
+	if len(data) <= field {
+		field = len(data) - 1
+	}
+	return data[field]
+}
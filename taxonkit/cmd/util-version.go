@@ -0,0 +1,228 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/shenwei356/taxonkit/taxonomy"
+	"github.com/shenwei356/xopen"
+)
+
+// taxonomyVersion identifies a snapshot of taxonomy data.
+type taxonomyVersion struct {
+	ID      string // xxhash of nodes.dmp, names.dmp, delnodes.dmp and merged.dmp
+	Date    string // release date, or the modification date of the dump files
+	Source  string
+	DataDir string
+}
+
+// String returns the version string, e.g., 2024-05-01_d2a38e12a1c3f6b0.
+func (v taxonomyVersion) String() string {
+	return v.Date + "_" + v.ID
+}
+
+// Header returns a comment line of the version.
+func (v taxonomyVersion) Header() string {
+	return fmt.Sprintf("# taxonomy version: %s, source: %s, data: %s\n", v, v.Source, v.DataDir)
+}
+
+// versionFiles are the dump files for computing the version id, the order matters.
+var versionFiles = []string{"nodes.dmp", "names.dmp", "delnodes.dmp", "merged.dmp"}
+
+// getTaxonomyVersion returns the version of the taxonomy data in use.
+// The ID is computed from the contents of the dump files, so it's the same
+// for the same files in different directories or archives. It's computed once
+// and saved in the metadata file along with stamps of the files, and only
+// recomputed when the files are changed.
+// The date and source come from the metadata file or the database registry.
+func getTaxonomyVersion(config Config) taxonomyVersion {
+	v := taxonomyVersion{DataDir: config.DataDir, Source: config.DataDir}
+
+	var err error
+	var meta *DBMetadata
+	if config.Archive != "" {
+		info, err := os.Stat(config.Archive)
+		checkError(err)
+		v.Date = info.ModTime().Format("2006-01-02")
+
+		archive, err := taxonomy.NewArchive(config.Archive)
+		checkError(err)
+		v.ID, err = hashVersionFiles(archive.OpenMembers(versionFiles...), nil)
+		checkError(err)
+	} else {
+		files := indexSourceFiles(config)
+		stamps, err := stampVersionFiles(files)
+		checkError(err)
+		var latest int64
+		for _, s := range stamps {
+			if s.ModTime > latest {
+				latest = s.ModTime
+			}
+		}
+		v.Date = time.Unix(0, latest).Format("2006-01-02")
+
+		meta, err = readDBMetadata(config.TaxdumpDir)
+		checkError(err)
+		if meta != nil && meta.VersionID != "" && slices.Equal(meta.VersionFiles, stamps) {
+			v.ID = meta.VersionID
+		} else {
+			v.ID, err = hashVersionFiles(openVersionFiles(files))
+			checkError(err)
+
+			// saving it for later use, the data directory might be read-only
+			saved := &DBMetadata{}
+			if meta != nil {
+				*saved = *meta
+			}
+			saved.VersionID, saved.VersionFiles = v.ID, stamps
+			if err = writeDBMetadata(config.TaxdumpDir, saved); err != nil {
+				if config.Verbose {
+					log.Warningf("failed to save the taxonomy version ID: %s", err)
+				}
+			} else {
+				meta = saved
+			}
+		}
+	}
+
+	// date and source
+	if meta != nil && meta.Date != "" {
+		v.Date, v.Source = meta.Date, meta.Source
+		return v
+	}
+
+	registry, err := loadDBRegistry()
+	checkError(err)
+	dataDir, _ := filepath.Abs(config.DataDir)
+	for _, name := range registry.Names() {
+		db := registry.Databases[name]
+		if db.Path == dataDir {
+			v.Date, v.Source = db.Date, db.Source
+			break
+		}
+	}
+
+	return v
+}
+
+// versionStamp records the size and modification time of a dump file,
+// for checking whether the version ID in the metadata file is up to date.
+type versionStamp struct {
+	File    string `toml:"file"`
+	Size    int64  `toml:"size"` // -1 for missing files
+	ModTime int64  `toml:"mtime"`
+}
+
+// stampVersionFiles returns stamps of nodes.dmp, names.dmp, delnodes.dmp and merged.dmp.
+func stampVersionFiles(files []string) ([]versionStamp, error) {
+	sources, err := taxonomy.StatIndexSources(files)
+	if err != nil {
+		return nil, err
+	}
+	stamps := make([]versionStamp, len(sources))
+	for i, s := range sources {
+		stamps[i] = versionStamp{File: versionFiles[i], Size: s.Size, ModTime: s.ModTime}
+	}
+	return stamps, nil
+}
+
+// setVersionID computes the version ID of dump files and saves it in the metadata.
+// files are paths of nodes.dmp, names.dmp, delnodes.dmp and merged.dmp.
+func setVersionID(meta *DBMetadata, files []string) error {
+	// stat before hashing, in case the files are changed during hashing
+	stamps, err := stampVersionFiles(files)
+	if err != nil {
+		return err
+	}
+	id, err := hashVersionFiles(openVersionFiles(files))
+	if err != nil {
+		return err
+	}
+	meta.VersionID, meta.VersionFiles = id, stamps
+	return nil
+}
+
+// openVersionFiles opens dump files for computing the version ID,
+// readers of files failed to open are nil, with the errors in errs.
+func openVersionFiles(files []string) (readers []io.ReadCloser, errs []error) {
+	readers = make([]io.ReadCloser, len(files))
+	errs = make([]error, len(files))
+	for i, file := range files {
+		fh, err := xopen.Ropen(file)
+		switch {
+		case err == nil:
+			readers[i] = fh
+		case err == xopen.ErrNoContent:
+			readers[i] = io.NopCloser(strings.NewReader(""))
+		case os.IsNotExist(err):
+			errs[i] = fmt.Errorf("%s: %w", file, fs.ErrNotExist)
+		default:
+			errs[i] = err
+		}
+	}
+	return readers, errs
+}
+
+// hashVersionFiles computes the version ID from contents of dump files,
+// missing files are skipped. openErrs are errors of opening the files, if any.
+func hashVersionFiles(readers []io.ReadCloser, openErrs []error) (string, error) {
+	// readers of an archive should be consumed concurrently
+	sums := make([]uint64, len(readers))
+	errs := make([]error, len(readers))
+	var wg sync.WaitGroup
+	for i, r := range readers {
+		if openErrs != nil && openErrs[i] != nil {
+			errs[i] = openErrs[i]
+			continue
+		}
+		wg.Add(1)
+		go func(i int, r io.ReadCloser) {
+			defer wg.Done()
+			defer r.Close()
+			h := xxhash.New()
+			_, errs[i] = io.Copy(h, r)
+			sums[i] = h.Sum64()
+		}(i, r)
+	}
+	wg.Wait()
+
+	h := xxhash.New()
+	for i, err := range errs {
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\t%016x\n", versionFiles[i], sums[i])
+	}
+	return fmt.Sprintf("%016x", h.Sum64()), nil
+}
@@ -37,11 +37,22 @@ var versionCmd = &cobra.Command{
 	Short: "print version information and check for update",
 	Long: `print version information and check for update
 
+The version of the taxonomy data is also printed if --data-dir or --db is given.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		app := "taxonkit"
 		fmt.Printf("%s v%s\n", app, VERSION)
 
+		if cmd.Flags().Changed("data-dir") || cmd.Flags().Changed("db") {
+			config := getConfigs(cmd)
+			v := getTaxonomyVersion(config)
+			fmt.Printf("\ntaxonomy version: %s\n", v)
+			fmt.Printf("source: %s\n", v.Source)
+			fmt.Printf("date: %s\n", v.Date)
+			fmt.Printf("data: %s\n", v.DataDir)
+		}
+
 		if !getFlagBool(cmd, "check-update") {
 			return
 		}
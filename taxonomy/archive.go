@@ -0,0 +1,240 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/xopen"
+)
+
+// IsArchive tells whether a file is a taxdump archive by the file extension,
+// i.e., .tar.gz, .tgz or .zip.
+func IsArchive(file string) bool {
+	file = strings.ToLower(file)
+	return strings.HasSuffix(file, ".tar.gz") || strings.HasSuffix(file, ".tgz") ||
+		strings.HasSuffix(file, ".zip")
+}
+
+// Archive is a compressed taxdump archive, e.g., taxdump.tar.gz,
+// or taxdmp_*.zip in the NCBI taxdump archive.
+// Dump files in it are streamed without being extracted to disk.
+type Archive struct {
+	file  string
+	isZip bool
+}
+
+// NewArchive checks and returns an archive file.
+func NewArchive(file string) (*Archive, error) {
+	if !IsArchive(file) {
+		return nil, fmt.Errorf("taxonomy: unsupported archive format: %s", file)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, fmt.Errorf("taxonomy: %s", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("taxonomy: archive should be a file: %s", file)
+	}
+	return &Archive{file: file, isZip: strings.HasSuffix(strings.ToLower(file), ".zip")}, nil
+}
+
+// File returns the path of the archive.
+func (a *Archive) File() string { return a.file }
+
+// OpenMembers opens dump files in the archive for reading, members are matched by base names.
+// A tar.gz archive is decompressed only once for all the members, so the returned readers
+// should be consumed concurrently, and closed after use.
+// Reading a missing member returns an error wrapping fs.ErrNotExist.
+func (a *Archive) OpenMembers(names ...string) []io.ReadCloser {
+	if a.isZip {
+		return a.openZipMembers(names)
+	}
+	return a.openTarMembers(names)
+}
+
+func (a *Archive) memberNotFound(name string) error {
+	return fmt.Errorf("taxonomy: %s not found in %s: %w", name, a.file, fs.ErrNotExist)
+}
+
+func (a *Archive) openTarMembers(names []string) []io.ReadCloser {
+	readers := make([]io.ReadCloser, len(names))
+	writers := make(map[string][]*io.PipeWriter, len(names))
+	for i, name := range names {
+		r, w := io.Pipe()
+		readers[i] = r
+		writers[name] = append(writers[name], w)
+	}
+
+	go func() {
+		err := a.streamTar(writers)
+		for name, ws := range writers { // not found
+			for _, w := range ws {
+				if err != nil {
+					w.CloseWithError(err)
+				} else {
+					w.CloseWithError(a.memberNotFound(name))
+				}
+			}
+		}
+	}()
+
+	return readers
+}
+
+// streamTar writes the wanted members to the pipes one by one,
+// and stops when all of them are written.
+func (a *Archive) streamTar(writers map[string][]*io.PipeWriter) error {
+	fh, err := xopen.Ropen(a.file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	tr := tar.NewReader(fh)
+	var hdr *tar.Header
+	var name string
+	for len(writers) > 0 {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name = path.Base(hdr.Name)
+		ws, ok := writers[name]
+		if !ok {
+			continue
+		}
+		delete(writers, name)
+
+		if err = copyToPipes(ws, tr); err != nil {
+			for _, w := range ws {
+				w.CloseWithError(err)
+			}
+			return err
+		}
+		for _, w := range ws {
+			w.Close()
+		}
+	}
+	return nil
+}
+
+// copyToPipes copies data to pipes whose readers are not closed.
+func copyToPipes(ws []*io.PipeWriter, r io.Reader) error {
+	buf := make([]byte, 64<<10)
+	closed := make([]bool, len(ws))
+	var n int
+	var err error
+	for {
+		n, err = r.Read(buf)
+		if n > 0 {
+			for i, w := range ws {
+				if !closed[i] {
+					if _, e := w.Write(buf[:n]); e != nil { // the reader is closed
+						closed[i] = true
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (a *Archive) openZipMembers(names []string) []io.ReadCloser {
+	readers := make([]io.ReadCloser, len(names))
+
+	zr, err := zip.OpenReader(a.file)
+	if err != nil {
+		for i := range readers {
+			readers[i] = &errReader{err: err}
+		}
+		return readers
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			files[path.Base(f.Name)] = f
+		}
+	}
+
+	// the zip file is closed after all members are closed
+	var wg sync.WaitGroup
+	for i, name := range names {
+		f, ok := files[name]
+		if !ok {
+			readers[i] = &errReader{err: a.memberNotFound(name)}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			readers[i] = &errReader{err: err}
+			continue
+		}
+		wg.Add(1)
+		readers[i] = &zipMember{ReadCloser: rc, done: wg.Done}
+	}
+	go func() {
+		wg.Wait()
+		zr.Close()
+	}()
+
+	return readers
+}
+
+type zipMember struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (m *zipMember) Close() error {
+	err := m.ReadCloser.Close()
+	m.once.Do(m.done)
+	return err
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func (r *errReader) Close() error { return nil }
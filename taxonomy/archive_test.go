@@ -0,0 +1,245 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+var testdataMembers = []string{"nodes.dmp", "names.dmp", "delnodes.dmp", "merged.dmp"}
+
+// writeTestArchive creates a tar.gz or zip archive of the test data,
+// with members in a directory "taxdump/".
+func writeTestArchive(t *testing.T, file string) {
+	t.Helper()
+	fh, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	var add func(name string, data []byte) error
+	var closeArchive func() error
+	if filepath.Ext(file) == ".zip" {
+		zw := zip.NewWriter(fh)
+		if _, err = zw.Create("taxdump/"); err != nil {
+			t.Fatal(err)
+		}
+		add = func(name string, data []byte) error {
+			w, err := zw.Create("taxdump/" + name)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
+		}
+		closeArchive = zw.Close
+	} else {
+		gw := gzip.NewWriter(fh)
+		tw := tar.NewWriter(gw)
+		if err = tw.WriteHeader(&tar.Header{Name: "taxdump/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+			t.Fatal(err)
+		}
+		add = func(name string, data []byte) error {
+			err := tw.WriteHeader(&tar.Header{Name: "taxdump/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))})
+			if err != nil {
+				return err
+			}
+			_, err = tw.Write(data)
+			return err
+		}
+		closeArchive = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gw.Close()
+		}
+	}
+
+	for _, name := range testdataMembers {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = add(name, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = closeArchive(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsArchive(t *testing.T) {
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"taxdump.tar.gz", true},
+		{"new_taxdump.TAR.GZ", true},
+		{"taxdump.tgz", true},
+		{"taxdmp_2024-05-01.zip", true},
+		{"nodes.dmp", false},
+		{"nodes.dmp.gz", false},
+		{"taxdump.tar", false},
+		{"/home/user/.taxonkit", false},
+	}
+	for _, test := range tests {
+		if got := IsArchive(test.file); got != test.want {
+			t.Errorf("IsArchive(%s) = %v, want %v", test.file, got, test.want)
+		}
+	}
+}
+
+func TestNewArchive(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewArchive(filepath.Join(dir, "taxdump.txt")); err == nil {
+		t.Errorf("NewArchive of an unsupported format: no error")
+	}
+	if _, err := NewArchive(filepath.Join(dir, "missing.tar.gz")); err == nil {
+		t.Errorf("NewArchive of a missing file: no error")
+	}
+	subdir := filepath.Join(dir, "taxdump.zip")
+	if err := os.Mkdir(subdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewArchive(subdir); err == nil {
+		t.Errorf("NewArchive of a directory: no error")
+	}
+}
+
+func TestArchiveOpenMembers(t *testing.T) {
+	for _, name := range []string{"taxdump.tar.gz", "taxdump.zip"} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), name)
+			writeTestArchive(t, file)
+			archive, err := NewArchive(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// a missing member, and a member requested twice
+			names := []string{"names.dmp", "host.dmp", "nodes.dmp", "merged.dmp", "delnodes.dmp", "nodes.dmp"}
+			readers := archive.OpenMembers(names...)
+			if len(readers) != len(names) {
+				t.Fatalf("%d readers returned for %d members", len(readers), len(names))
+			}
+
+			// readers of a tar.gz archive should be consumed concurrently
+			data := make([][]byte, len(readers))
+			errs := make([]error, len(readers))
+			var wg sync.WaitGroup
+			for i, r := range readers {
+				wg.Add(1)
+				go func(i int, r io.ReadCloser) {
+					defer wg.Done()
+					defer r.Close()
+					data[i], errs[i] = io.ReadAll(r)
+				}(i, r)
+			}
+			wg.Wait()
+
+			for i, name := range names {
+				if name == "host.dmp" {
+					if !errors.Is(errs[i], fs.ErrNotExist) {
+						t.Errorf("%s: error %v, want one wrapping fs.ErrNotExist", name, errs[i])
+					}
+					continue
+				}
+				if errs[i] != nil {
+					t.Errorf("%s: %s", name, errs[i])
+					continue
+				}
+				want, err := os.ReadFile(filepath.Join("testdata", name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(data[i], want) {
+					t.Errorf("%s: content differs from testdata/%s", name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveEarlyClose(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "taxdump.tar.gz")
+	writeTestArchive(t, file)
+	archive, err := NewArchive(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// closing a reader without reading does not block other readers
+	readers := archive.OpenMembers("nodes.dmp", "names.dmp")
+	readers[0].Close()
+	data, err := io.ReadAll(readers[1])
+	readers[1].Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "names.dmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(data, want) {
+		t.Errorf("names.dmp: content differs from testdata/names.dmp")
+	}
+}
+
+func TestOpenArchive(t *testing.T) {
+	taxdb := openTestdata(t)
+	for _, name := range []string{"taxdump.tar.gz", "taxdump.zip"} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), name)
+			writeTestArchive(t, file)
+			arcdb, err := OpenArchive(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// division.dmp, gencode.dmp and others are optional
+			if err = arcdb.Load(PartNodes | PartRanks | PartNames | PartDelNodes | PartMerged); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, taxid := range append(taxdb.TaxIds(), 12, 30, 3, 4, 99) {
+				newtaxid, status := arcdb.Resolve(taxid)
+				wantTaxid, wantStatus := taxdb.Resolve(taxid)
+				if newtaxid != wantTaxid || status != wantStatus {
+					t.Errorf("Resolve(%d) = %d, %s, want %d, %s", taxid, newtaxid, status, wantTaxid, wantStatus)
+				}
+				if list, want := arcdb.LineageNames(taxid), taxdb.LineageNames(taxid); !slices.Equal(list, want) {
+					t.Errorf("LineageNames(%d) = %q, want %q", taxid, list, want)
+				}
+			}
+		})
+	}
+}
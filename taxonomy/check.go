@@ -0,0 +1,419 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package taxonomy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/xopen"
+)
+
+// IssueType is the type of a problem in taxdump files.
+type IssueType string
+
+// Types of problems found by Check.
+const (
+	IssueMalformedLine      IssueType = "malformed-line"
+	IssueDuplicatedTaxId    IssueType = "duplicated-taxid"
+	IssueParentNotFound     IssueType = "parent-not-found"
+	IssueNoRoot             IssueType = "no-root"
+	IssueMultipleRoots      IssueType = "multiple-roots"
+	IssueCycle              IssueType = "cycle"
+	IssueNoSciName          IssueType = "no-scientific-name"
+	IssueMultipleSciNames   IssueType = "multiple-scientific-names"
+	IssueNameOfUnknownTaxId IssueType = "name-of-unknown-taxid"
+	IssueDeletedButLive     IssueType = "deleted-but-live"
+	IssueMergedButLive      IssueType = "merged-but-live"
+	IssueMergedAndDeleted   IssueType = "merged-and-deleted"
+	IssueMergedIntoDeleted  IssueType = "merged-into-deleted"
+	IssueMergedIntoMerged   IssueType = "merged-into-merged"
+	IssueMergedIntoUnknown  IssueType = "merged-into-unknown"
+)
+
+// Issue is a structural problem in taxdump files.
+type Issue struct {
+	File    string // base name of the file, e.g., nodes.dmp
+	Line    int    // 1-based line number, 0 for problems of the whole file
+	TaxId   uint32 // 0 if not available
+	Type    IssueType
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", i.File, i.Line, i.Type, i.Message)
+}
+
+// Check scans taxdump files and reports structural problems, including
+// malformed lines, duplicated TaxIds, missing parents, cycles, zero or multiple roots,
+// nodes without scientific names, and inconsistent deleted and merged TaxIds.
+// Empty paths of DelNodes and Merged are skipped. Issues are sorted by file and line.
+func Check(files Files) ([]Issue, error) {
+	return check(func(names []string) []io.ReadCloser {
+		paths := map[string]string{
+			"nodes.dmp":    files.Nodes,
+			"names.dmp":    files.Names,
+			"delnodes.dmp": files.DelNodes,
+			"merged.dmp":   files.Merged,
+		}
+		readers := make([]io.ReadCloser, len(names))
+		for i, name := range names {
+			file := paths[name]
+			if file == "" {
+				readers[i] = &errReader{err: fmt.Errorf("%s: %w", name, fs.ErrNotExist)}
+				continue
+			}
+			fh, err := xopen.Ropen(file)
+			if err == xopen.ErrNoContent {
+				readers[i] = io.NopCloser(strings.NewReader(""))
+				continue
+			} else if err != nil {
+				readers[i] = &errReader{err: err}
+				continue
+			}
+			readers[i] = fh
+		}
+		return readers
+	}, files.Nodes == "" || files.Names == "")
+}
+
+// CheckArchive is like Check, but scans files in a taxdump archive.
+func CheckArchive(file string) ([]Issue, error) {
+	archive, err := NewArchive(file)
+	if err != nil {
+		return nil, err
+	}
+	return check(func(names []string) []io.ReadCloser { return archive.OpenMembers(names...) }, false)
+}
+
+// node records for checking.
+type checkNode struct {
+	parent uint32
+	line   int
+}
+
+func check(open func(names []string) []io.ReadCloser, missing bool) ([]Issue, error) {
+	if missing {
+		return nil, fmt.Errorf("taxonomy: nodes.dmp and names.dmp are needed for checking")
+	}
+
+	files := []string{"nodes.dmp", "names.dmp", "delnodes.dmp", "merged.dmp"}
+	readers := open(files)
+
+	var nodes map[uint32]checkNode
+	sciNames := make(map[uint32]int, mapInitialSize)  // taxid -> number of scientific names
+	nameLines := make(map[uint32]int, mapInitialSize) // taxid -> the first line in names.dmp
+	delNodes := make(map[uint32]int, 1024)            // taxid -> line
+	merged := make(map[uint32][2]int, 1024)           // taxid -> [new taxid, line]
+
+	issues := make([][]Issue, len(files))
+	errs := make([]error, len(files))
+
+	var wg sync.WaitGroup
+	wg.Add(len(files))
+	go func() { // nodes.dmp
+		defer wg.Done()
+		defer readers[0].Close()
+		nodes = make(map[uint32]checkNode, mapInitialSize)
+		var taxid, parent uint32
+		errs[0] = scanDmp(readers[0], "nodes.dmp", 3, &issues[0], func(line int, items []string) *Issue {
+			ids, issue := parseIds(items[:2])
+			if issue != nil {
+				return issue
+			}
+			taxid, parent = ids[0], ids[1]
+			if node, ok := nodes[taxid]; ok {
+				return &Issue{TaxId: taxid, Type: IssueDuplicatedTaxId,
+					Message: fmt.Sprintf("taxid %d is also in line %d", taxid, node.line)}
+			}
+			nodes[taxid] = checkNode{parent: parent, line: line}
+			return nil
+		})
+	}()
+	go func() { // names.dmp
+		defer wg.Done()
+		defer readers[1].Close()
+		var taxid uint32
+		errs[1] = scanDmp(readers[1], "names.dmp", 4, &issues[1], func(line int, items []string) *Issue {
+			ids, issue := parseIds(items[:1])
+			if issue != nil {
+				return issue
+			}
+			taxid = ids[0]
+			if _, ok := nameLines[taxid]; !ok {
+				nameLines[taxid] = line
+			}
+			if items[3] != ScientificName {
+				return nil
+			}
+			sciNames[taxid]++
+			if sciNames[taxid] > 1 {
+				return &Issue{TaxId: taxid, Type: IssueMultipleSciNames,
+					Message: fmt.Sprintf("taxid %d has another scientific name: %s", taxid, items[1])}
+			}
+			return nil
+		})
+	}()
+	go func() { // delnodes.dmp
+		defer wg.Done()
+		defer readers[2].Close()
+		errs[2] = scanDmp(readers[2], "delnodes.dmp", 1, &issues[2], func(line int, items []string) *Issue {
+			ids, issue := parseIds(items[:1])
+			if issue != nil {
+				return issue
+			}
+			if l, ok := delNodes[ids[0]]; ok {
+				return &Issue{TaxId: ids[0], Type: IssueDuplicatedTaxId,
+					Message: fmt.Sprintf("taxid %d is also in line %d", ids[0], l)}
+			}
+			delNodes[ids[0]] = line
+			return nil
+		})
+	}()
+	go func() { // merged.dmp
+		defer wg.Done()
+		defer readers[3].Close()
+		errs[3] = scanDmp(readers[3], "merged.dmp", 2, &issues[3], func(line int, items []string) *Issue {
+			ids, issue := parseIds(items[:2])
+			if issue != nil {
+				return issue
+			}
+			if m, ok := merged[ids[0]]; ok {
+				return &Issue{TaxId: ids[0], Type: IssueDuplicatedTaxId,
+					Message: fmt.Sprintf("taxid %d is also in line %d", ids[0], m[1])}
+			}
+			merged[ids[0]] = [2]int{int(ids[1]), line}
+			return nil
+		})
+	}()
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if i >= 2 && errors.Is(err, fs.ErrNotExist) { // optional files
+			continue
+		}
+		return nil, fmt.Errorf("taxonomy: %s: %s", files[i], err)
+	}
+
+	var all []Issue
+	for _, _issues := range issues {
+		all = append(all, _issues...)
+	}
+
+	all = append(all, checkTree(nodes)...)
+
+	// names
+	for taxid, node := range nodes {
+		if sciNames[taxid] == 0 {
+			all = append(all, Issue{File: "nodes.dmp", Line: node.line, TaxId: taxid, Type: IssueNoSciName,
+				Message: fmt.Sprintf("taxid %d has no scientific name in names.dmp", taxid)})
+		}
+	}
+	for taxid, line := range nameLines {
+		if _, ok := nodes[taxid]; !ok {
+			all = append(all, Issue{File: "names.dmp", Line: line, TaxId: taxid, Type: IssueNameOfUnknownTaxId,
+				Message: fmt.Sprintf("taxid %d is not in nodes.dmp", taxid)})
+		}
+	}
+
+	// deleted and merged
+	for taxid, line := range delNodes {
+		if node, ok := nodes[taxid]; ok {
+			all = append(all, Issue{File: "delnodes.dmp", Line: line, TaxId: taxid, Type: IssueDeletedButLive,
+				Message: fmt.Sprintf("taxid %d is also in line %d of nodes.dmp", taxid, node.line)})
+		}
+	}
+	var to uint32
+	for taxid, m := range merged {
+		to = uint32(m[0])
+		if node, ok := nodes[taxid]; ok {
+			all = append(all, Issue{File: "merged.dmp", Line: m[1], TaxId: taxid, Type: IssueMergedButLive,
+				Message: fmt.Sprintf("taxid %d is also in line %d of nodes.dmp", taxid, node.line)})
+		}
+		if line, ok := delNodes[taxid]; ok {
+			all = append(all, Issue{File: "merged.dmp", Line: m[1], TaxId: taxid, Type: IssueMergedAndDeleted,
+				Message: fmt.Sprintf("taxid %d is also in line %d of delnodes.dmp", taxid, line)})
+		}
+		if _, ok := nodes[to]; ok {
+			continue
+		}
+		if line, ok := delNodes[to]; ok {
+			all = append(all, Issue{File: "merged.dmp", Line: m[1], TaxId: taxid, Type: IssueMergedIntoDeleted,
+				Message: fmt.Sprintf("taxid %d is merged into %d, which is deleted in line %d of delnodes.dmp", taxid, to, line)})
+		} else if m2, ok := merged[to]; ok {
+			all = append(all, Issue{File: "merged.dmp", Line: m[1], TaxId: taxid, Type: IssueMergedIntoMerged,
+				Message: fmt.Sprintf("taxid %d is merged into %d, which is merged into %d in line %d", taxid, to, m2[0], m2[1])})
+		} else {
+			all = append(all, Issue{File: "merged.dmp", Line: m[1], TaxId: taxid, Type: IssueMergedIntoUnknown,
+				Message: fmt.Sprintf("taxid %d is merged into %d, which is not in nodes.dmp", taxid, to)})
+		}
+	}
+
+	order := make(map[string]int, len(files))
+	for i, file := range files {
+		order[file] = i
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.File != b.File {
+			return order[a.File] < order[b.File]
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.TaxId != b.TaxId {
+			return a.TaxId < b.TaxId
+		}
+		return a.Type < b.Type
+	})
+
+	return all, nil
+}
+
+// checkTree checks missing parents, roots and cycles.
+func checkTree(nodes map[uint32]checkNode) []Issue {
+	var issues []Issue
+
+	roots := make([]uint32, 0, 1)
+	for taxid, node := range nodes {
+		if taxid == node.parent {
+			roots = append(roots, taxid)
+			continue
+		}
+		if _, ok := nodes[node.parent]; !ok {
+			issues = append(issues, Issue{File: "nodes.dmp", Line: node.line, TaxId: taxid, Type: IssueParentNotFound,
+				Message: fmt.Sprintf("parent %d of taxid %d is not found", node.parent, taxid)})
+		}
+	}
+	switch {
+	case len(roots) == 0 && len(nodes) > 0:
+		issues = append(issues, Issue{File: "nodes.dmp", Type: IssueNoRoot,
+			Message: "no root node, whose parent is itself"})
+	case len(roots) > 1:
+		sort.Slice(roots, func(i, j int) bool { return roots[i] < roots[j] })
+		for _, taxid := range roots {
+			issues = append(issues, Issue{File: "nodes.dmp", Line: nodes[taxid].line, TaxId: taxid, Type: IssueMultipleRoots,
+				Message: fmt.Sprintf("taxid %d is one of %d root nodes", taxid, len(roots))})
+		}
+	}
+
+	// cycles. 1: visiting, 2: visited
+	state := make(map[uint32]uint8, len(nodes))
+	path := make([]uint32, 0, 64)
+	var node checkNode
+	var ok bool
+	for taxid := range nodes {
+		if state[taxid] > 0 {
+			continue
+		}
+
+		path = path[:0]
+		cur := taxid
+		for {
+			if state[cur] == 2 {
+				break
+			}
+			if state[cur] == 1 { // cycle found
+				i := len(path) - 1
+				for path[i] != cur {
+					i--
+				}
+				cycle := path[i:]
+				ids := make([]string, len(cycle))
+				for j, id := range cycle {
+					ids[j] = strconv.Itoa(int(id))
+				}
+				// reported at the node with the smallest line number
+				first := cycle[0]
+				for _, id := range cycle {
+					if nodes[id].line < nodes[first].line {
+						first = id
+					}
+				}
+				issues = append(issues, Issue{File: "nodes.dmp", Line: nodes[first].line, TaxId: first, Type: IssueCycle,
+					Message: fmt.Sprintf("taxids in a cycle: %s", strings.Join(ids, " -> "))})
+				break
+			}
+			if node, ok = nodes[cur]; !ok || node.parent == cur { // missing parent or root
+				break
+			}
+			state[cur] = 1
+			path = append(path, cur)
+			cur = node.parent
+		}
+		for _, id := range path {
+			state[id] = 2
+		}
+	}
+
+	return issues
+}
+
+// scanDmp scans a dump file, n is the minimum number of columns.
+// fn parses a line and returns an issue if any, whose File and Line are filled by scanDmp.
+func scanDmp(r io.Reader, file string, n int, issues *[]Issue, fn func(line int, items []string) *Issue) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 1<<16), 1<<30)
+
+	var text string
+	var items []string
+	var line int
+	for scanner.Scan() {
+		line++
+		text = scanner.Text()
+		if text == "" {
+			continue
+		}
+		items = strings.Split(strings.TrimSuffix(strings.TrimSuffix(text, "\r"), "\t|"), "\t|\t")
+		if len(items) < n {
+			*issues = append(*issues, Issue{File: file, Line: line, Type: IssueMalformedLine,
+				Message: fmt.Sprintf("%d columns expected, %d given", n, len(items))})
+			continue
+		}
+		if issue := fn(line, items); issue != nil {
+			issue.File, issue.Line = file, line
+			*issues = append(*issues, *issue)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseIds parses TaxIds of a line.
+func parseIds(items []string) ([]uint32, *Issue) {
+	ids := make([]uint32, len(items))
+	for i, s := range items {
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, &Issue{Type: IssueMalformedLine, Message: fmt.Sprintf("invalid taxid: %q", s)}
+		}
+		ids[i] = uint32(id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,530 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"unsafe"
+)
+
+// tree is the compact form of nodes, ranks and scientific names.
+//
+// Nodes are stored in arrays in ascending order of TaxIds, and referred to by
+// their indexes. TaxIds are mapped to indexes with a lookup table, or with
+// binary search when TaxIds are too sparse for a table, e.g., the hash values
+// used by "taxonkit create-taxdump". Parents are saved as indexes, so walking
+// a lineage needs no map lookups. Ranks are interned as uint16 ids, and
+// scientific names are concatenated in a single byte slice.
+//
+// Arrays of a tree created from an index file, except parents, are backed by
+// the memory-mapped file.
+type tree struct {
+	taxids  []uint32 // in ascending order
+	parents []uint32 // indexes of parents, noParent for parents not in the tree
+	table   []uint32 // taxid -> index + 1, 0 for absent TaxIds. nil for sparse TaxIds
+	root    uint32   // index of the root node, noParent if not found
+
+	orphans map[uint32]uint32 // index -> parent TaxId, for parents not in the tree
+
+	rankIDs []uint16 // nil if ranks are not loaded
+	ranks   []string // rank id -> rank
+
+	nameOffsets []uint32 // the name of the i-th node is nameData[nameOffsets[i]:nameOffsets[i+1]], nil if names are not loaded
+	nameData    []byte
+	numNames    int
+}
+
+const noParent = ^uint32(0)
+
+// newTree creates a tree from the child -> parent mapping.
+func newTree(nodes map[uint32]uint32) *tree {
+	taxids := make([]uint32, 0, len(nodes))
+	for taxid := range nodes {
+		taxids = append(taxids, taxid)
+	}
+	sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+
+	tr := &tree{taxids: taxids}
+	tr.buildTable()
+	tr.setParents(func(i int) uint32 { return nodes[taxids[i]] })
+	return tr
+}
+
+// nodeList is nodes in nodes.dmp in the order of the file,
+// which is parsed without creating maps.
+type nodeList struct {
+	taxids  []uint32
+	parents []uint32
+	rankIDs []uint16 // nil if ranks are not parsed
+	ranks   []string
+}
+
+// parseNodeList parses nodes.dmp into a nodeList.
+func parseNodeList(r io.Reader, withRank bool) (*nodeList, error) {
+	l := &nodeList{
+		taxids:  make([]uint32, 0, mapInitialSize),
+		parents: make([]uint32, 0, mapInitialSize),
+	}
+	var rank2id map[string]uint16
+	if withRank {
+		l.rankIDs = make([]uint16, 0, mapInitialSize)
+		l.ranks = make([]string, 0, 128)
+		rank2id = make(map[string]uint16, 128)
+	}
+
+	items := make([]string, 6)
+	scanner := bufio.NewScanner(r)
+	var child, parent int
+	var id uint16
+	var ok bool
+	var err error
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 6, &items)
+		if len(items) < 6 {
+			continue
+		}
+		if child, err = strconv.Atoi(items[0]); err != nil {
+			continue
+		}
+		if parent, err = strconv.Atoi(items[2]); err != nil {
+			continue
+		}
+		l.taxids = append(l.taxids, uint32(child))
+		l.parents = append(l.parents, uint32(parent))
+
+		if withRank {
+			if id, ok = rank2id[items[4]]; !ok {
+				if len(l.ranks) > 1<<16-1 {
+					return nil, fmt.Errorf("taxonomy: too many ranks: %d", len(l.ranks))
+				}
+				id = uint16(len(l.ranks))
+				rank2id[items[4]] = id
+				l.ranks = append(l.ranks, items[4])
+			}
+			l.rankIDs = append(l.rankIDs, id)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// listOrder returns the positions of records in ascending order of TaxIds.
+// For duplicated TaxIds, only the last one is kept, like assigning them to a map.
+func listOrder(taxids []uint32) []int {
+	order := make([]int, len(taxids))
+	sorted := true
+	for i := range order {
+		order[i] = i
+		if i > 0 && taxids[i] <= taxids[i-1] {
+			sorted = false
+		}
+	}
+	if sorted { // it's true for NCBI taxdump files
+		return order
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return taxids[order[i]] < taxids[order[j]] })
+	j := 0
+	for i, k := range order {
+		if i+1 < len(order) && taxids[order[i+1]] == taxids[k] {
+			continue
+		}
+		order[j] = k
+		j++
+	}
+	return order[:j]
+}
+
+// newTreeFromList creates a tree from a nodeList.
+func newTreeFromList(l *nodeList) *tree {
+	order := listOrder(l.taxids)
+	taxids := make([]uint32, len(order))
+	for i, k := range order {
+		taxids[i] = l.taxids[k]
+	}
+
+	tr := &tree{taxids: taxids}
+	tr.buildTable()
+	tr.setParents(func(i int) uint32 { return l.parents[order[i]] })
+	if l.rankIDs != nil {
+		tr.ranks = l.ranks
+		tr.rankIDs = make([]uint16, len(order))
+		for i, k := range order {
+			tr.rankIDs[i] = l.rankIDs[k]
+		}
+	}
+	return tr
+}
+
+// nameList is scientific names in names.dmp in the order of the file,
+// which is parsed without creating maps.
+type nameList struct {
+	taxids  []uint32
+	offsets []uint32 // the i-th name is data[offsets[i]:offsets[i+1]]
+	data    []byte
+}
+
+// parseNameList parses scientific names in names.dmp into a nameList.
+func parseNameList(r io.Reader) (*nameList, error) {
+	l := &nameList{
+		taxids:  make([]uint32, 0, mapInitialSize),
+		offsets: make([]uint32, 1, mapInitialSize),
+		data:    make([]byte, 0, mapInitialSize<<5),
+	}
+
+	items := make([]string, 8)
+	scanner := bufio.NewScanner(r)
+	var id int
+	var err error
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 8, &items)
+		if len(items) < 8 {
+			continue
+		}
+		if items[6] != ScientificName {
+			continue
+		}
+		if id, err = strconv.Atoi(items[0]); err != nil {
+			continue
+		}
+		if len(l.data)+len(items[2]) > 1<<32-1 {
+			return nil, fmt.Errorf("taxonomy: too many names: more than %d bytes", len(l.data))
+		}
+		l.taxids = append(l.taxids, uint32(id))
+		l.data = append(l.data, items[2]...)
+		l.offsets = append(l.offsets, uint32(len(l.data)))
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// setNamesFromList fills scientific names from a nameList.
+func (tr *tree) setNamesFromList(l *nameList) {
+	tr.numNames = 0
+
+	// names.dmp has the same TaxIds as nodes.dmp in the same order,
+	// so the data can be directly used.
+	same := len(l.taxids) == len(tr.taxids)
+	if same {
+		for i, taxid := range l.taxids {
+			if taxid != tr.taxids[i] {
+				same = false
+				break
+			}
+		}
+	}
+	if same {
+		tr.nameOffsets, tr.nameData = l.offsets, l.data
+		for i := range tr.taxids {
+			if l.offsets[i] != l.offsets[i+1] {
+				tr.numNames++
+			}
+		}
+		return
+	}
+
+	// positions of names of nodes, the last one is used for duplicated TaxIds
+	pos := make([]int32, len(tr.taxids))
+	for i := range pos {
+		pos[i] = -1
+	}
+	var size int
+	for k, taxid := range l.taxids {
+		if i, ok := tr.index(taxid); ok {
+			if pos[i] >= 0 {
+				size -= int(l.offsets[pos[i]+1] - l.offsets[pos[i]])
+			}
+			pos[i] = int32(k)
+			size += int(l.offsets[k+1] - l.offsets[k])
+		}
+	}
+
+	tr.nameOffsets = make([]uint32, len(tr.taxids)+1)
+	tr.nameData = make([]byte, 0, size)
+	for i, k := range pos {
+		if k >= 0 && l.offsets[k] != l.offsets[k+1] {
+			tr.nameData = append(tr.nameData, l.data[l.offsets[k]:l.offsets[k+1]]...)
+			tr.numNames++
+		}
+		tr.nameOffsets[i+1] = uint32(len(tr.nameData))
+	}
+}
+
+// newTreeFromIndex creates a tree from an index, with ranks and names.
+func newTreeFromIndex(idx *index) *tree {
+	tr := &tree{taxids: idx.Taxids}
+	tr.buildTable()
+	tr.setParents(func(i int) uint32 { return idx.Parents[i] })
+	return tr
+}
+
+// buildTable creates the taxid -> index table if TaxIds are dense enough,
+// i.e., the table is at most 4 times the size of TaxIds.
+func (tr *tree) buildTable() {
+	n := len(tr.taxids)
+	if n == 0 || n >= int(noParent) {
+		return
+	}
+	max := uint64(tr.taxids[n-1])
+	if max >= uint64(n)<<2+1<<16 {
+		return
+	}
+	tr.table = make([]uint32, max+1)
+	for i, taxid := range tr.taxids {
+		tr.table[taxid] = uint32(i + 1)
+	}
+}
+
+func (tr *tree) setParents(parent func(i int) uint32) {
+	tr.parents = make([]uint32, len(tr.taxids))
+	tr.root = noParent
+	var p uint32
+	var j int
+	var ok bool
+	for i := range tr.taxids {
+		p = parent(i)
+		if j, ok = tr.index(p); !ok {
+			if tr.orphans == nil {
+				tr.orphans = make(map[uint32]uint32, 8)
+			}
+			tr.orphans[uint32(i)] = p
+			tr.parents[i] = noParent
+			continue
+		}
+		tr.parents[i] = uint32(j)
+		if j == i && tr.root == noParent {
+			tr.root = uint32(i)
+		}
+	}
+}
+
+// setRanks fills ranks from the taxid -> rank mapping.
+func (tr *tree) setRanks(ranks map[uint32]string) error {
+	rank2id := make(map[string]uint16, 128)
+	tr.ranks = make([]string, 0, 128)
+	tr.rankIDs = make([]uint16, len(tr.taxids))
+
+	var rank string
+	var id uint16
+	var ok bool
+	for i, taxid := range tr.taxids {
+		rank = ranks[taxid]
+		if id, ok = rank2id[rank]; !ok {
+			if len(tr.ranks) > 1<<16-1 {
+				return fmt.Errorf("taxonomy: too many ranks: %d", len(tr.ranks))
+			}
+			id = uint16(len(tr.ranks))
+			rank2id[rank] = id
+			tr.ranks = append(tr.ranks, rank)
+		}
+		tr.rankIDs[i] = id
+	}
+	return nil
+}
+
+// setRanksFromList fills ranks from a nodeList,
+// which is used when nodes are loaded before ranks.
+func (tr *tree) setRanksFromList(l *nodeList) error {
+	tr.ranks = l.ranks
+	tr.rankIDs = make([]uint16, len(tr.taxids))
+
+	// nodes missing in the list have an empty rank
+	const noRank = 1<<16 - 1
+	for i := range tr.rankIDs {
+		tr.rankIDs[i] = noRank
+	}
+	for k, taxid := range l.taxids {
+		if i, ok := tr.index(taxid); ok {
+			tr.rankIDs[i] = l.rankIDs[k]
+		}
+	}
+
+	var empty uint16 = noRank
+	for i, id := range tr.rankIDs {
+		if id != noRank {
+			continue
+		}
+		if empty == noRank {
+			if len(tr.ranks) >= noRank {
+				return fmt.Errorf("taxonomy: too many ranks: %d", len(tr.ranks))
+			}
+			empty = uint16(len(tr.ranks))
+			tr.ranks = append(tr.ranks, "")
+		}
+		tr.rankIDs[i] = empty
+	}
+	return nil
+}
+
+// setNames fills scientific names from the taxid -> name mapping.
+func (tr *tree) setNames(names map[uint32]string) error {
+	var size int
+	for _, taxid := range tr.taxids {
+		size += len(names[taxid])
+	}
+	if size > 1<<32-1 {
+		return fmt.Errorf("taxonomy: too many names: %d bytes", size)
+	}
+
+	tr.nameOffsets = make([]uint32, len(tr.taxids)+1)
+	tr.nameData = make([]byte, 0, size)
+	tr.numNames = 0
+	var name string
+	for i, taxid := range tr.taxids {
+		name = names[taxid]
+		if name != "" {
+			tr.numNames++
+		}
+		tr.nameData = append(tr.nameData, name...)
+		tr.nameOffsets[i+1] = uint32(len(tr.nameData))
+	}
+	return nil
+}
+
+// setNamesFromIndex uses scientific names in an index,
+// the tree should be created from the same index.
+func (tr *tree) setNamesFromIndex(idx *index) {
+	tr.nameOffsets, tr.nameData = idx.nameOffsets, idx.nameData
+	tr.numNames = 0
+	for i := range tr.taxids {
+		if tr.nameOffsets[i] != tr.nameOffsets[i+1] {
+			tr.numNames++
+		}
+	}
+}
+
+// index returns the index of a TaxId.
+func (tr *tree) index(taxid uint32) (int, bool) {
+	if tr == nil {
+		return 0, false
+	}
+	if tr.table != nil {
+		if int(taxid) >= len(tr.table) || tr.table[taxid] == 0 {
+			return 0, false
+		}
+		return int(tr.table[taxid] - 1), true
+	}
+	i := sort.Search(len(tr.taxids), func(i int) bool { return tr.taxids[i] >= taxid })
+	if i < len(tr.taxids) && tr.taxids[i] == taxid {
+		return i, true
+	}
+	return 0, false
+}
+
+// parent returns the parent TaxId of the i-th node.
+func (tr *tree) parent(i int) uint32 {
+	if p := tr.parents[i]; p != noParent {
+		return tr.taxids[p]
+	}
+	return tr.orphans[uint32(i)]
+}
+
+// rank returns the rank of the i-th node.
+func (tr *tree) rank(i int) string {
+	if tr.rankIDs == nil {
+		return ""
+	}
+	return tr.ranks[tr.rankIDs[i]]
+}
+
+// name returns the scientific name of the i-th node.
+// The returned string shares the memory of the tree.
+func (tr *tree) name(i int) string {
+	if tr.nameOffsets == nil {
+		return ""
+	}
+	s, e := tr.nameOffsets[i], tr.nameOffsets[i+1]
+	if s == e {
+		return ""
+	}
+	return unsafe.String(&tr.nameData[s], e-s)
+}
+
+// lineage returns indexes of nodes in the lineage of the i-th node, from the top to the node.
+// The root node 1 is not included unless it's the query.
+// nil is returned if any node in the lineage has a parent not in the tree.
+func (tr *tree) lineage(i int) []uint32 {
+	list := make([]uint32, 0, 16)
+	c := uint32(i)
+	var p uint32
+	for {
+		list = append(list, c)
+
+		p = tr.parents[c]
+		if p == noParent {
+			return nil
+		}
+		if p == c || tr.taxids[p] == 1 {
+			break
+		}
+		c = p
+	}
+	reverseUint32s(list)
+	return list
+}
+
+// depth returns the number of ancestors of the i-th node,
+// -1 is returned if any node in the lineage has a parent not in the tree.
+func (tr *tree) depth(i uint32) int {
+	var d int
+	var p uint32
+	for {
+		p = tr.parents[i]
+		if p == noParent {
+			return -1
+		}
+		if p == i {
+			return d
+		}
+		d++
+		i = p
+	}
+}
+
+// lca returns the index of the lowest common ancestor of two nodes,
+// noParent is returned if they are not in the same tree.
+func (tr *tree) lca(a, b uint32) uint32 {
+	da, db := tr.depth(a), tr.depth(b)
+	if da < 0 || db < 0 {
+		return noParent
+	}
+	for ; da > db; da-- {
+		a = tr.parents[a]
+	}
+	for ; db > da; db-- {
+		b = tr.parents[b]
+	}
+	for a != b {
+		if tr.parents[a] == a { // different roots
+			return noParent
+		}
+		a, b = tr.parents[a], tr.parents[b]
+	}
+	return a
+}
@@ -0,0 +1,235 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// mapTaxonomy is the map-based storage of nodes, ranks and scientific names
+// used before the dense tree, which is kept for comparison in benchmarks.
+type mapTaxonomy struct {
+	nodes  map[uint32]uint32 // child -> parent
+	ranks  map[uint32]string
+	names  map[uint32]string
+	merged map[uint32]uint32
+}
+
+// lineage returns the lineage of a TaxId in the same way as the map-based Taxonomy.Lineage.
+func (t *mapTaxonomy) lineage(taxid uint32) *Lineage {
+	var child, parent, newtaxid uint32
+	var ok bool
+
+	child = taxid
+	list := make([]uint32, 0, 16)
+	for {
+		parent, ok = t.nodes[child]
+		if !ok {
+			if newtaxid, ok = t.merged[child]; ok {
+				child = newtaxid
+				parent = t.nodes[child]
+			} else {
+				return nil
+			}
+		}
+		list = append(list, child)
+		if parent == 1 {
+			break
+		}
+		child = parent
+	}
+	reverseUint32s(list)
+
+	l := &Lineage{TaxId: list[len(list)-1], TaxIds: list}
+	l.Names = make([]string, len(list))
+	l.Ranks = make([]string, len(list))
+	for i, tax := range list {
+		l.Names[i] = t.names[tax]
+		l.Ranks[i] = t.ranks[tax]
+	}
+	return l
+}
+
+var benchRanks = []string{"domain", "phylum", "class", "order", "family", "genus", "species", "strain"}
+
+// randomTaxonomy creates a random taxonomy of n nodes in both forms, and TaxIds for queries.
+// Nodes of lower ranks are more than those of higher ones, like the NCBI taxonomy,
+// and some nodes have the rank of "no rank" or "clade".
+func randomTaxonomy(n int, nQueries int) (*mapTaxonomy, *Taxonomy, []uint32) {
+	r := rand.New(rand.NewSource(11))
+
+	m := &mapTaxonomy{
+		nodes:  make(map[uint32]uint32, n),
+		ranks:  make(map[uint32]string, n),
+		names:  make(map[uint32]string, n),
+		merged: make(map[uint32]uint32),
+	}
+	m.nodes[1], m.ranks[1], m.names[1] = 1, "no rank", "root"
+
+	// TaxIds of each level, a level is a rank or a no-rank level
+	levels := [][]uint32{{1}}
+	taxid := uint32(1)
+	for len(m.nodes) < n {
+		for l := 1; l <= 2*len(benchRanks) && len(m.nodes) < n; l++ {
+			if len(levels) <= l {
+				levels = append(levels, nil)
+			}
+			// lower levels get more new nodes
+			for k := 0; k < l*l && len(m.nodes) < n; k++ {
+				taxid += uint32(1 + r.Intn(3))
+				parents := levels[l-1]
+				parent := parents[r.Intn(len(parents))]
+				m.nodes[taxid] = parent
+				if l%2 == 0 {
+					m.ranks[taxid] = benchRanks[l/2-1]
+				} else if r.Intn(2) == 0 {
+					m.ranks[taxid] = "no rank"
+				} else {
+					m.ranks[taxid] = "clade"
+				}
+				m.names[taxid] = fmt.Sprintf("taxon %d", taxid)
+				levels[l] = append(levels[l], taxid)
+			}
+		}
+	}
+
+	// merged TaxIds
+	for i := 0; i < n/100; i++ {
+		taxid++
+		m.merged[taxid] = levels[len(levels)-1][r.Intn(len(levels[len(levels)-1]))]
+	}
+
+	t := New(m.nodes, m.ranks, m.names, nil, m.merged)
+
+	queries := make([]uint32, nQueries)
+	for i := range queries {
+		if i%50 == 0 {
+			queries[i] = taxid - uint32(r.Intn(n/100)) // merged
+			continue
+		}
+		level := levels[1+r.Intn(len(levels)-1)]
+		queries[i] = level[r.Intn(len(level))]
+	}
+	return m, t, queries
+}
+
+func TestDenseTreeMatchesMaps(t *testing.T) {
+	m, taxdb, queries := randomTaxonomy(10000, 2000)
+
+	f, err := NewLineageFormatter("{domain};{phylum};{class};{order};{family};{genus};{species};{strain}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.TaxIds = true
+
+	for _, taxid := range queries {
+		want := m.lineage(taxid)
+		l, err := taxdb.Lineage(taxid)
+		if err != nil {
+			t.Fatalf("Lineage(%d): %s", taxid, err)
+		}
+		if l.TaxId != want.TaxId || !slices.Equal(l.TaxIds, want.TaxIds) ||
+			!slices.Equal(l.Names, want.Names) || !slices.Equal(l.Ranks, want.Ranks) {
+			t.Fatalf("Lineage(%d) = %v, want %v", taxid, l, want)
+		}
+
+		flineage, iflineage, err := taxdb.Reformat(taxid, f)
+		if err != nil {
+			t.Fatalf("Reformat(%d): %s", taxid, err)
+		}
+		wantF, wantI := f.Format(want)
+		if flineage != wantF || iflineage != wantI {
+			t.Fatalf("Reformat(%d) = %q, %q, want %q, %q", taxid, flineage, iflineage, wantF, wantI)
+		}
+	}
+}
+
+var (
+	benchOnce    sync.Once
+	benchMap     *mapTaxonomy
+	benchTaxdb   *Taxonomy
+	benchQueries []uint32
+)
+
+// benchTaxonomy returns a random taxonomy of 2.6 million nodes,
+// which is about the size of the NCBI taxonomy.
+func benchTaxonomy(b *testing.B) (*mapTaxonomy, *Taxonomy, []uint32) {
+	benchOnce.Do(func() {
+		benchMap, benchTaxdb, benchQueries = randomTaxonomy(2600000, 1<<16)
+	})
+	b.ResetTimer()
+	return benchMap, benchTaxdb, benchQueries
+}
+
+func BenchmarkLineage(b *testing.B) {
+	b.Run("map", func(b *testing.B) {
+		m, _, queries := benchTaxonomy(b)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if m.lineage(queries[i%len(queries)]) == nil {
+				b.Fatal("lineage not found")
+			}
+		}
+	})
+	b.Run("dense", func(b *testing.B) {
+		_, taxdb, queries := benchTaxonomy(b)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := taxdb.Lineage(queries[i%len(queries)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkReformat2 formats lineages with the default format of "taxonkit reformat2".
+func BenchmarkReformat2(b *testing.B) {
+	newFormatter := func(b *testing.B) *LineageFormatter {
+		f, err := NewLineageFormatter("{domain|acellular root|superkingdom};{phylum};{class};{order};{family};{genus};{species}", nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return f
+	}
+
+	b.Run("map", func(b *testing.B) {
+		f := newFormatter(b)
+		m, _, queries := benchTaxonomy(b)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			f.Format(m.lineage(queries[i%len(queries)]))
+		}
+	})
+	b.Run("dense", func(b *testing.B) {
+		f := newFormatter(b)
+		_, taxdb, queries := benchTaxonomy(b)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := taxdb.Reformat(queries[i%len(queries)], f); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,435 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// RankFilter filters TaxIds by taxonomic rank range.
+//
+// Results are cached, so a RankFilter is not safe for concurrent use.
+type RankFilter struct {
+	taxondb *Taxonomy
+
+	dbRanks   map[string]interface{}
+	rankOrder map[string]int
+
+	lower  string
+	higher string
+	equals []string
+
+	oLower  int
+	oHigher int
+	oEquals map[int]interface{}
+
+	limitLower  bool
+	limitHigher bool
+	limitEqual  bool
+
+	noRanks    map[string]interface{}
+	blackLists map[string]interface{}
+
+	discardNorank   bool
+	saveKnownNoRank bool
+
+	cache map[uint32]bool
+}
+
+// NewRankFilter creates a RankFilter.
+//
+// rankOrder and noRanks can be read from a rank file with ReadRankOrder.
+// lower and higher are exclusive, and can be used along with equals.
+// Ranks in blackList are discarded. Ranks without order are discarded if discardNorank is true.
+// When filtering with lower, saveKnownNoRank saves some special ranks without order,
+// where rank of the closest higher node is still lower than rank cutoff.
+func NewRankFilter(taxondb *Taxonomy, rankOrder map[string]int, noRanks map[string]interface{},
+	lower string, higher string, equals []string, blackList []string, discardNorank bool, saveKnownNoRank bool) (*RankFilter, error) {
+
+	if lower != "" && higher != "" {
+		return nil, fmt.Errorf("higher and lower can't be simultaneous given")
+	}
+
+	taxondb.ensure(PartRanks)
+	if taxondb.tree == nil || taxondb.tree.rankIDs == nil {
+		return nil, ErrRanksNotLoaded
+	}
+
+	blackListMap := make(map[string]interface{})
+	for _, r := range blackList {
+		blackListMap[r] = struct{}{}
+	}
+	dbRanks := taxondb.rankSet
+	f := &RankFilter{
+		taxondb:         taxondb,
+		dbRanks:         dbRanks,
+		rankOrder:       rankOrder,
+		lower:           lower,
+		higher:          higher,
+		equals:          equals,
+		noRanks:         noRanks,
+		blackLists:      blackListMap,
+		discardNorank:   discardNorank,
+		saveKnownNoRank: saveKnownNoRank,
+		cache:           make(map[uint32]bool, 1024),
+	}
+
+	var err error
+	if lower != "" {
+		f.oLower, err = getRankOrder(dbRanks, rankOrder, lower)
+		if err != nil {
+			return nil, err
+		}
+		f.limitLower = true
+	}
+	if higher != "" {
+		f.oHigher, err = getRankOrder(dbRanks, rankOrder, higher)
+		if err != nil {
+			return nil, err
+		}
+		f.limitHigher = true
+	}
+	if len(equals) > 0 {
+		f.oEquals = make(map[int]interface{}, len(equals))
+		var oe int
+		var ok, equalNorank bool
+		for _, equal := range equals {
+			oe, err = getRankOrder(dbRanks, rankOrder, equal)
+			if err != nil {
+				return nil, err
+			}
+			f.oEquals[oe] = struct{}{}
+
+			if _, ok = noRanks[equal]; ok {
+				equalNorank = true
+			}
+		}
+		f.limitEqual = true
+
+		if !f.limitLower && !f.limitHigher && !equalNorank {
+			f.discardNorank = true
+		}
+	}
+	return f, nil
+}
+
+func getRankOrder(dbRanks map[string]interface{}, rankOrder map[string]int, rank string) (int, error) {
+	var ok bool
+	if _, ok = rankOrder[rank]; !ok {
+		return -1, fmt.Errorf("rank order not defined in rank file: %s", rank)
+	}
+	if _, ok = dbRanks[rank]; !ok {
+		return -1, fmt.Errorf("rank order not found in taxonomy database: %s", rank)
+	}
+
+	return rankOrder[rank], nil
+}
+
+// IsPassed tells whether a TaxId passes the filter.
+// Merged TaxIds are checked with the new ones, deleted or unknown TaxIds never pass.
+func (f *RankFilter) IsPassed(taxid uint32) (bool, error) {
+	rank := f.taxondb.Rank(taxid)
+	if rank == "" {
+		return false, nil
+	}
+
+	rank = strings.ToLower(rank)
+
+	if v, ok := f.cache[taxid]; ok {
+		return v, nil
+	}
+
+	if _, ok := f.blackLists[rank]; ok {
+		f.cache[taxid] = false
+		return false, nil
+	}
+
+	var isNoRank bool
+	_, ok := f.noRanks[rank]
+	if ok {
+		if f.discardNorank {
+			isNoRank = true
+			if !f.saveKnownNoRank {
+				f.cache[taxid] = false
+				return false, nil
+			}
+		} else { // all nonrank will be outputted if !discardNorank
+			f.cache[taxid] = true
+			return true, nil
+		}
+	}
+
+	// checking taxid
+	var status Status
+	taxid, status = f.taxondb.Resolve(taxid)
+	if status == Deleted || status == NotFound {
+		return false, nil
+	}
+
+	var pass bool
+
+	if isNoRank && f.limitLower && f.saveKnownNoRank {
+		var _rank string
+		var _ok bool
+		var _order int
+
+		parent, _ := f.taxondb.Parent(taxid)
+		for {
+			if parent == 1 {
+				f.cache[taxid] = false
+				return false, nil
+			}
+
+			_rank = f.taxondb.Rank(parent)
+			_order, _ok = f.rankOrder[_rank]
+			if _ok {
+				pass = _order <= f.oLower
+
+				f.cache[taxid] = pass
+				return pass, nil
+			}
+			parent, _ = f.taxondb.Parent(parent)
+		}
+	}
+
+	order := f.rankOrder[rank]
+	// order, ok := f.rankOrder[rank]
+	// if !ok {
+	// 	return false, fmt.Errorf("rank order not defined in rank file: %s", rank)
+	// }
+
+	if f.limitEqual {
+		if _, pass = f.oEquals[order]; pass {
+			// pass = true
+		} else if f.limitLower {
+			pass = order < f.oLower
+		} else if f.limitHigher {
+			pass = order > f.oHigher
+		} else {
+			pass = false
+		}
+	} else if f.limitLower {
+		pass = order < f.oLower
+	} else if f.limitHigher {
+		pass = order > f.oHigher
+	} else {
+		pass = true // no any filter
+	}
+
+	f.cache[taxid] = pass
+	return pass, nil
+}
+
+// ReadRankOrder reads taxonomic rank order from a rank file,
+// returning rank -> order, and ranks without order.
+// The format is described in DefaultRanksText.
+func ReadRankOrder(file string) (map[string]int, map[string]interface{}, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read rank order list from '%s': %s", file, err)
+	}
+	defer fh.Close()
+
+	return readRankOrder(fh, file)
+}
+
+// DefaultRankOrder returns the rank order in DefaultRanksText.
+func DefaultRankOrder() (map[string]int, map[string]interface{}, error) {
+	return readRankOrder(strings.NewReader(DefaultRanksText), "default rank order")
+}
+
+func readRankOrder(r io.Reader, file string) (map[string]int, map[string]interface{}, error) {
+	ranks := make([][]string, 0, 128)
+	noranks := make(map[string]interface{}, 10)
+
+	scanner := bufio.NewScanner(r)
+	var record, item string
+	for scanner.Scan() {
+		record = strings.TrimSpace(scanner.Text())
+		if record == "" || record[0] == '#' {
+			continue
+		}
+
+		items := make([]string, 0, 1)
+
+		for _, item = range strings.Split(record, ",") {
+			if len(item) == 0 {
+				continue
+			}
+			item = strings.ToLower(strings.TrimSpace(item))
+
+			if item[0] == '!' {
+				noranks[item[1:]] = struct{}{}
+			} else {
+				items = append(items, item)
+			}
+		}
+
+		if len(items) > 0 {
+			ranks = append(ranks, items)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("read rank order list from '%s': %s", file, err)
+	}
+
+	if len(ranks) == 0 {
+		return nil, nil, fmt.Errorf("no ranks found in file: %s", file)
+	}
+
+	rankOrder := make(map[string]int, len(ranks))
+	order := 1
+	var ok bool
+	var rank string
+	for i := len(ranks) - 1; i >= 0; i-- {
+		for _, rank = range ranks[i] {
+			if _, ok = rankOrder[rank]; ok {
+				return nil, nil, fmt.Errorf("duplicated rank: %s", ranks[i])
+			}
+			rankOrder[rank] = order
+		}
+		order++
+	}
+	return rankOrder, noranks, nil
+}
+
+// WriteDefaultRankOrderFile writes DefaultRanksText to a file.
+func WriteDefaultRankOrderFile(file string) error {
+	return os.WriteFile(file, []byte(DefaultRanksText), 0644)
+}
+
+// DefaultRanksFile is the default file name of rank order in a data directory.
+const DefaultRanksFile = "ranks.txt"
+
+// DefaultRanksText is the content of the default rank file.
+const DefaultRanksText = `
+# This file defines taxonomic rank order for taxdump/taxonkit.
+# 
+# Here'are the rules:
+#     1. Blank lines or lines starting with "#" are ignored.
+#     2. Ranks are in decending order and case ignored.
+#     3. Ranks with same order should be in one line separated with comma (",", no space).
+#     4. Ranks without order should be assigned a prefix symbol "!" for each rank.
+# 
+# Deault ranks reference from https://en.wikipedia.org/wiki/Taxonomic_rank ,
+# and contains some ranks from NCIB Taxonomy database.
+#
+
+!no rank
+!clade
+
+
+life
+
+acellular root,cellular root
+domain,superkingdom,realm,empire
+
+kingdom
+subkingdom
+infrakingdom
+parvkingdom
+
+superphylum,superdivision
+phylum,division
+subphylum,subdivision
+infraphylum,infradivision
+microphylum,microdivision
+
+superclass
+class
+subclass
+infraclass
+parvclass
+
+superlegion
+legion
+sublegion
+infralegion
+
+supercohort
+cohort
+subcohort
+infracohort
+
+gigaorder
+magnorder,megaorder
+grandorder,capaxorder
+mirorder,hyperorder
+superorder
+# series
+order
+# parvorder
+nanorder
+hypoorder
+minorder
+suborder
+infraorder
+parvorder
+
+# section
+# subsection
+
+gigafamily
+megafamily
+grandfamily
+hyperfamily
+superfamily
+epifamily
+# series
+group
+family
+subfamily
+infrafamily
+
+supertribe
+tribe
+subtribe
+infratribe
+
+genus
+subgenus
+section
+subsection
+series
+subseries
+
+
+superspecies,species group
+species subgroup
+species
+
+subspecies,forma specialis,pathovar
+
+pathogroup,serogroup
+biotype,serotype,genotype
+
+variety,varietas,morph,aberration
+subvariety,subvarietas,submorph,subaberration
+form,forma
+subform,subforma
+
+strain
+isolate
+`
@@ -0,0 +1,196 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/util/stringutil"
+)
+
+var reRankPlaceHolder = regexp.MustCompile(`\{([^\{\}]+?)\}`)
+
+// DefaultNoRanks are rank names of no-rank.
+var DefaultNoRanks = []string{"no rank", "clade"}
+
+// LineageFormatter reformats lineages in chosen ranks with a format
+// containing rank placeholders, e.g., "{domain|acellular root|superkingdom};{phylum};{species}".
+//
+//  1. The format can contain some escape characters like "\t".
+//  2. "|" can be used to set multiple ranks in a placeholder,
+//     and the first valid one will be outputted.
+type LineageFormatter struct {
+	// MissRankRepl is the replacement string for missing ranks.
+	MissRankRepl string
+	// MissTaxIdRepl is the replacement string for missing TaxIds.
+	MissTaxIdRepl string
+	// Trim means do not replace missing ranks lower than the rank of the current node.
+	Trim bool
+	// TaxIds means also formatting TaxIds of the lineage.
+	TaxIds bool
+
+	format  string
+	matches [][]string
+	noRanks map[string]interface{}
+
+	unescape func(string) string
+}
+
+// NewLineageFormatter creates a LineageFormatter.
+// noRanks are rank names of no-rank, a lineage might have many "no rank" ranks,
+// only the last one below known ranks is kept. DefaultNoRanks is used if it's nil.
+func NewLineageFormatter(format string, noRanks []string) (*LineageFormatter, error) {
+	if !reRankPlaceHolder.MatchString(format) {
+		return nil, fmt.Errorf("placeholder of simplified rank not found in output format: %s", format)
+	}
+	matches := reRankPlaceHolder.FindAllStringSubmatch(format, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no placeholder given %s", format)
+	}
+
+	if noRanks == nil {
+		noRanks = DefaultNoRanks
+	}
+	noRanksMap := make(map[string]interface{}, len(noRanks))
+	for _, rank := range noRanks {
+		noRanksMap[strings.ToLower(rank)] = struct{}{}
+	}
+
+	return &LineageFormatter{
+		format:   format,
+		matches:  matches,
+		noRanks:  noRanksMap,
+		unescape: stringutil.UnEscaper(),
+	}, nil
+}
+
+// Missing returns the formatted lineage and TaxIds for deleted or unknown TaxIds.
+func (f *LineageFormatter) Missing() (string, string) {
+	return f.unescape(reRankPlaceHolder.ReplaceAllString(f.format, f.MissRankRepl)),
+		f.unescape(reRankPlaceHolder.ReplaceAllString(f.format, f.MissTaxIdRepl))
+}
+
+var poolRank2idx = &sync.Pool{New: func() interface{} {
+	tmp := make(map[string]int, 64)
+	return &tmp
+}}
+
+// Format formats a lineage, which must contain ranks.
+// The second value is empty unless f.TaxIds is true.
+func (f *LineageFormatter) Format(l *Lineage) (string, string) {
+	var ok bool
+
+	rank2idx := poolRank2idx.Get().(*map[string]int)
+	clear(*rank2idx)
+	var meetKnownRanks bool
+	var lastKnownRank string
+	for i, rank := range l.Ranks {
+		rank = strings.ToLower(rank)
+
+		if _, ok = f.noRanks[rank]; ok {
+			if meetKnownRanks {
+				(*rank2idx)[rank] = i
+			}
+		} else {
+			meetKnownRanks = true
+			(*rank2idx)[rank] = i
+			lastKnownRank = rank
+		}
+	}
+
+	flineage := f.format
+	var iflineage string
+	if f.TaxIds {
+		iflineage = f.format
+	}
+
+	var i int
+	var _matches []string
+	var _match string
+	var matched bool
+	var foundLastKnownRank bool
+	var repl, irepl string
+	for _, match := range f.matches {
+		_matches = strings.Split(match[1], "|")
+
+		matched = false
+		for _, _match = range _matches {
+			if _match == "" {
+				continue
+			}
+
+			_match = strings.ToLower(_match)
+			if i, ok = (*rank2idx)[_match]; !ok {
+				continue
+			}
+
+			flineage = strings.ReplaceAll(flineage, match[0], l.Names[i])
+			if f.TaxIds {
+				iflineage = strings.ReplaceAll(iflineage, match[0], strconv.Itoa(int(l.TaxIds[i])))
+			}
+			matched = true
+
+			if _match == lastKnownRank {
+				foundLastKnownRank = true
+			}
+			break
+		}
+
+		if !matched {
+			if !foundLastKnownRank {
+				repl, irepl = f.MissRankRepl, f.MissTaxIdRepl
+			} else if f.Trim {
+				repl, irepl = "", ""
+			} else {
+				repl, irepl = f.MissRankRepl, f.MissTaxIdRepl
+			}
+
+			flineage = strings.ReplaceAll(flineage, match[0], repl)
+			if f.TaxIds {
+				iflineage = strings.ReplaceAll(iflineage, match[0], irepl)
+			}
+		}
+	}
+
+	poolRank2idx.Put(rank2idx)
+
+	return f.unescape(flineage), f.unescape(iflineage)
+}
+
+// Reformat reformats the lineage of a TaxId.
+// For deleted or unknown TaxIds, the results of f.Missing() are returned
+// along with a *TaxIdError.
+func (t *Taxonomy) Reformat(taxid uint32, f *LineageFormatter) (string, string, error) {
+	if !t.HasRanks() {
+		return "", "", ErrRanksNotLoaded
+	}
+	l, err := t.Lineage(taxid)
+	if err != nil {
+		flineage, iflineage := f.Missing()
+		return flineage, iflineage, err
+	}
+	flineage, iflineage := f.Format(l)
+	return flineage, iflineage, nil
+}
@@ -0,0 +1,228 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Division is a GenBank division in division.dmp.
+type Division struct {
+	Id   int
+	Code string // three-letter code, e.g., "BCT"
+	Name string
+}
+
+// GeneticCode is a genetic code in gencode.dmp.
+type GeneticCode struct {
+	Id           int
+	Abbreviation string
+	Name         string
+}
+
+// Codes are the division, genetic code and mitochondrial genetic code ids of a TaxId.
+type Codes struct {
+	Division    int
+	GenCode     int
+	MitoGenCode int
+}
+
+// nodeCodes are codes of a node in nodes.dmp, and whether they're inherited from the parent.
+type nodeCodes struct {
+	div, gc, mgc                      uint8
+	inheritDiv, inheritGC, inheritMGC bool
+}
+
+// GenCodes returns the division and genetic code ids of a TaxId.
+// Codes flagged as inherited in nodes.dmp are taken from the nearest ancestor
+// which specifies them. If the TaxId was merged, codes of the new one are returned.
+// It returns false for deleted or unknown TaxIds.
+func (t *Taxonomy) GenCodes(taxid uint32) (Codes, bool) {
+	t.ensure(PartNodes | PartGenCodes | PartMerged)
+
+	i, ok := t.nodeIndex(taxid)
+	if !ok {
+		return Codes{}, false
+	}
+	taxid = t.tree.taxids[i]
+	c, ok := t.codes[taxid]
+	if !ok {
+		return Codes{}, false
+	}
+
+	codes := Codes{Division: int(c.div), GenCode: int(c.gc), MitoGenCode: int(c.mgc)}
+
+	div, gc, mgc := c.inheritDiv, c.inheritGC, c.inheritMGC
+	var parent uint32
+	child := taxid
+	for div || gc || mgc {
+		parent, ok = t.Parent(child)
+		if !ok || parent == child { // root
+			break
+		}
+		if c, ok = t.codes[parent]; !ok {
+			break
+		}
+		if div && !c.inheritDiv {
+			codes.Division, div = int(c.div), false
+		}
+		if gc && !c.inheritGC {
+			codes.GenCode, gc = int(c.gc), false
+		}
+		if mgc && !c.inheritMGC {
+			codes.MitoGenCode, mgc = int(c.mgc), false
+		}
+		child = parent
+	}
+
+	return codes, true
+}
+
+// Division returns a division in division.dmp.
+func (t *Taxonomy) Division(id int) (Division, bool) {
+	t.ensure(PartGenCodes)
+	d, ok := t.divisions[id]
+	return d, ok
+}
+
+// GeneticCode returns a genetic code in gencode.dmp.
+func (t *Taxonomy) GeneticCode(id int) (GeneticCode, bool) {
+	t.ensure(PartGenCodes)
+	g, ok := t.geneticCodes[id]
+	return g, ok
+}
+
+// readNodeCodes parses division and genetic code ids in nodes.dmp.
+func readNodeCodes(r io.Reader) (map[uint32]nodeCodes, error) {
+	var err error
+
+	codes := make(map[uint32]nodeCodes, mapInitialSize)
+
+	items := make([]string, 20)
+	scanner := bufio.NewScanner(r)
+	var id int
+	var vals [3]int
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 20, &items)
+		if len(items) < 19 {
+			continue
+		}
+
+		id, err = strconv.Atoi(items[0])
+		if err != nil {
+			continue
+		}
+		// division id, genetic code id, mitochondrial genetic code id
+		for i, j := range [3]int{8, 12, 16} {
+			vals[i], err = strconv.Atoi(items[j])
+			if err != nil || vals[i] < 0 || vals[i] > 255 {
+				return nil, fmt.Errorf("invalid code of taxid %d: %s", id, items[j])
+			}
+		}
+
+		codes[uint32(id)] = nodeCodes{
+			div:        uint8(vals[0]),
+			gc:         uint8(vals[1]),
+			mgc:        uint8(vals[2]),
+			inheritDiv: items[10] == "1",
+			inheritGC:  items[14] == "1",
+			inheritMGC: items[18] == "1",
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// ReadDivisions parses division.dmp.
+func ReadDivisions(file string) (map[int]Division, error) {
+	var divisions map[int]Division
+	err := readFile(file, func(r io.Reader) (err error) {
+		divisions, err = ParseDivisions(r)
+		return err
+	})
+	return divisions, err
+}
+
+// ParseDivisions is like ReadDivisions, but parses data from a reader.
+func ParseDivisions(r io.Reader) (map[int]Division, error) {
+	divisions := make(map[int]Division, 16)
+	err := readDmp(r, 3, func(id int, items []string) {
+		divisions[id] = Division{Id: id, Code: items[1], Name: items[2]}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return divisions, nil
+}
+
+// ReadGeneticCodes parses gencode.dmp.
+func ReadGeneticCodes(file string) (map[int]GeneticCode, error) {
+	var codes map[int]GeneticCode
+	err := readFile(file, func(r io.Reader) (err error) {
+		codes, err = ParseGeneticCodes(r)
+		return err
+	})
+	return codes, err
+}
+
+// ParseGeneticCodes is like ReadGeneticCodes, but parses data from a reader.
+func ParseGeneticCodes(r io.Reader) (map[int]GeneticCode, error) {
+	codes := make(map[int]GeneticCode, 32)
+	err := readDmp(r, 3, func(id int, items []string) {
+		codes[id] = GeneticCode{Id: id, Abbreviation: items[1], Name: items[2]}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// readDmp parses a small dump file whose first column is an integer id,
+// n is the number of needed columns.
+func readDmp(r io.Reader, n int, fn func(id int, items []string)) error {
+	var err error
+
+	items := make([]string, n<<1)
+	fields := make([]string, n)
+	scanner := bufio.NewScanner(r)
+	var id int
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", n<<1, &items)
+		if len(items) < n<<1-1 {
+			continue
+		}
+		id, err = strconv.Atoi(items[0])
+		if err != nil {
+			continue
+		}
+		for i := range fields {
+			fields[i] = items[i<<1]
+		}
+		fn(id, fields)
+	}
+	return scanner.Err()
+}
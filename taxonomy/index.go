@@ -0,0 +1,515 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"unsafe"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// The binary index is a compact copy of nodes.dmp (taxids, parents and ranks),
+// scientific names in names.dmp, delnodes.dmp and merged.dmp.
+// All integers are little-endian, and every section is aligned to 8 bytes,
+// so arrays can be directly used from the memory-mapped file.
+//
+//	magic          [8]byte
+//	version        uint32
+//	nSources       uint32
+//	sources        [nSources]{size int64, mtime int64}
+//	nNodes         uint32
+//	nRanks         uint32
+//	nDelNodes      uint32
+//	nMerged        uint32
+//	nameDataLen    uint64
+//	ranks          [nRanks]{len uint16, bytes}
+//	taxids         [nNodes]uint32, in ascending order
+//	parents        [nNodes]uint32
+//	rankIDs        [nNodes]uint16
+//	nameOffsets    [nNodes+1]uint32
+//	nameData       [nameDataLen]byte
+//	delNodes       [nDelNodes]uint32
+//	mergedFrom     [nMerged]uint32, in ascending order
+//	mergedTo       [nMerged]uint32
+
+// IndexFileName is the default file name of the index in a data directory.
+const IndexFileName = "taxonkit.idx"
+
+var indexMagic = [8]byte{'T', 'A', 'X', 'O', 'N', 'K', 'I', 'T'}
+
+// indexVersion should be increased when the layout changes,
+// index files of other versions are ignored.
+const indexVersion uint32 = 1
+
+// ErrInvalidIndex means the index file is invalid or truncated.
+var ErrInvalidIndex = errors.New("taxonomy: invalid or truncated index file")
+
+// ErrIndexVersion means the index file was created by another version.
+var ErrIndexVersion = errors.New("taxonomy: unsupported index version, please rebuild it")
+
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// IndexSource records the size and modification time of a dump file,
+// which are used to check whether the index is up to date.
+type IndexSource struct {
+	Size    int64 // -1 for missing files
+	ModTime int64
+}
+
+// index is the in-memory form of an index file.
+type index struct {
+	Sources []IndexSource
+
+	Taxids  []uint32
+	Parents []uint32
+	RankIDs []uint16
+	Ranks   []string
+
+	nameOffsets []uint32
+	nameData    []byte
+
+	DelNodes   []uint32
+	MergedFrom []uint32
+	MergedTo   []uint32
+}
+
+// Name returns the scientific name of the i-th node.
+func (idx *index) Name(i int) string {
+	s, e := idx.nameOffsets[i], idx.nameOffsets[i+1]
+	if s == e {
+		return ""
+	}
+	return unsafe.String(&idx.nameData[s], e-s)
+}
+
+// Rank returns the rank of the i-th node.
+func (idx *index) Rank(i int) string {
+	return idx.Ranks[idx.RankIDs[i]]
+}
+
+// StatIndexSources returns the sizes and modification times of dump files,
+// missing files are allowed.
+func StatIndexSources(files []string) ([]IndexSource, error) {
+	sources := make([]IndexSource, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				sources[i] = IndexSource{Size: -1}
+				continue
+			}
+			return nil, err
+		}
+		sources[i] = IndexSource{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+	}
+	return sources, nil
+}
+
+// ----------------------------------  write ---------------------------
+
+// WriteIndex saves a Taxonomy with ranks and names available to an index file.
+// sources are the states of dump files which the Taxonomy is created from,
+// they should be obtained with StatIndexSources before parsing the files.
+func WriteIndex(file string, t *Taxonomy, sources []IndexSource) error {
+	if !t.HasRanks() {
+		return ErrRanksNotLoaded
+	}
+	if !t.HasNames() {
+		return ErrNamesNotLoaded
+	}
+	if err := t.Load(indexParts); err != nil {
+		return err
+	}
+	return writeIndex(file, buildIndex(sources, t.tree, t.delNodes, t.merged))
+}
+
+// buildIndex creates the index from the tree, and deleted and merged TaxIds.
+func buildIndex(
+	sources []IndexSource,
+	tr *tree,
+	delnodes map[uint32]struct{},
+	merged map[uint32]uint32,
+) *index {
+	idx := &index{Sources: sources}
+
+	// the layout of the tree is the same as the index
+	idx.Taxids = tr.taxids
+	idx.Parents = make([]uint32, len(tr.taxids))
+	for i := range tr.taxids {
+		idx.Parents[i] = tr.parent(i)
+	}
+	idx.RankIDs, idx.Ranks = tr.rankIDs, tr.ranks
+	idx.nameOffsets, idx.nameData = tr.nameOffsets, tr.nameData
+
+	idx.DelNodes = make([]uint32, 0, len(delnodes))
+	for taxid := range delnodes {
+		idx.DelNodes = append(idx.DelNodes, taxid)
+	}
+	sort.Slice(idx.DelNodes, func(i, j int) bool { return idx.DelNodes[i] < idx.DelNodes[j] })
+
+	idx.MergedFrom = make([]uint32, 0, len(merged))
+	for from := range merged {
+		idx.MergedFrom = append(idx.MergedFrom, from)
+	}
+	sort.Slice(idx.MergedFrom, func(i, j int) bool { return idx.MergedFrom[i] < idx.MergedFrom[j] })
+	idx.MergedTo = make([]uint32, len(idx.MergedFrom))
+	for i, from := range idx.MergedFrom {
+		idx.MergedTo[i] = merged[from]
+	}
+
+	return idx
+}
+
+// writeIndex writes the index to a temporary file first,
+// and then renames it, so readers never see a partial index.
+func writeIndex(file string, idx *index) error {
+	tmp := file + ".tmp"
+	fh, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := &indexWriter{w: bufio.NewWriterSize(fh, 1<<20)}
+
+	w.bytes(indexMagic[:])
+	w.uint32(indexVersion)
+	w.uint32(uint32(len(idx.Sources)))
+	for _, s := range idx.Sources {
+		w.uint64(uint64(s.Size))
+		w.uint64(uint64(s.ModTime))
+	}
+	w.uint32(uint32(len(idx.Taxids)))
+	w.uint32(uint32(len(idx.Ranks)))
+	w.uint32(uint32(len(idx.DelNodes)))
+	w.uint32(uint32(len(idx.MergedFrom)))
+	w.uint64(uint64(len(idx.nameData)))
+
+	for _, rank := range idx.Ranks {
+		w.uint16(uint16(len(rank)))
+		w.bytes([]byte(rank))
+	}
+	w.pad()
+
+	w.uint32s(idx.Taxids)
+	w.uint32s(idx.Parents)
+	w.uint16s(idx.RankIDs)
+	w.uint32s(idx.nameOffsets)
+	w.bytes(idx.nameData)
+	w.pad()
+	w.uint32s(idx.DelNodes)
+	w.uint32s(idx.MergedFrom)
+	w.uint32s(idx.MergedTo)
+
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if err = fh.Close(); w.err == nil {
+		w.err = err
+	}
+	if w.err != nil {
+		os.Remove(tmp)
+		return w.err
+	}
+	return os.Rename(tmp, file)
+}
+
+type indexWriter struct {
+	w   *bufio.Writer
+	n   int
+	err error
+	buf [8]byte
+}
+
+func (w *indexWriter) bytes(b []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(b)
+	w.n += len(b)
+}
+
+func (w *indexWriter) uint16(v uint16) {
+	binary.LittleEndian.PutUint16(w.buf[:2], v)
+	w.bytes(w.buf[:2])
+}
+
+func (w *indexWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:4], v)
+	w.bytes(w.buf[:4])
+}
+
+func (w *indexWriter) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[:8], v)
+	w.bytes(w.buf[:8])
+}
+
+func (w *indexWriter) uint32s(vs []uint32) {
+	for _, v := range vs {
+		w.uint32(v)
+	}
+	w.pad()
+}
+
+func (w *indexWriter) uint16s(vs []uint16) {
+	for _, v := range vs {
+		w.uint16(v)
+	}
+	w.pad()
+}
+
+// pad aligns the next section to 8 bytes.
+func (w *indexWriter) pad() {
+	if r := w.n % 8; r > 0 {
+		w.bytes(make([]byte, 8-r))
+	}
+}
+
+// ----------------------------------  read ---------------------------
+
+// readIndexHeader only reads the sources recorded in an index file,
+// it's used to check whether the index is up to date.
+func readIndexHeader(file string) ([]IndexSource, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var head [16]byte
+	if _, err = io.ReadFull(fh, head[:]); err != nil {
+		return nil, ErrInvalidIndex
+	}
+	if [8]byte(head[:8]) != indexMagic {
+		return nil, ErrInvalidIndex
+	}
+	if v := binary.LittleEndian.Uint32(head[8:12]); v != indexVersion {
+		return nil, ErrIndexVersion
+	}
+	n := int(binary.LittleEndian.Uint32(head[12:16]))
+	buf := make([]byte, n*16)
+	if _, err = io.ReadFull(fh, buf); err != nil {
+		return nil, ErrInvalidIndex
+	}
+	sources := make([]IndexSource, n)
+	for i := range sources {
+		sources[i].Size = int64(binary.LittleEndian.Uint64(buf[i*16:]))
+		sources[i].ModTime = int64(binary.LittleEndian.Uint64(buf[i*16+8:]))
+	}
+	return sources, nil
+}
+
+// readIndex memory-maps an index file. The mapping is never released,
+// because names in the index are referenced without copying.
+func readIndex(file string) (*index, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	data, err := mmap.Map(fh, mmap.RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &indexReader{data: data}
+
+	if [8]byte(r.bytes(8)) != indexMagic {
+		return nil, ErrInvalidIndex
+	}
+	if v := r.uint32(); v != indexVersion {
+		return nil, ErrIndexVersion
+	}
+
+	idx := &index{}
+	idx.Sources = make([]IndexSource, r.uint32())
+	for i := range idx.Sources {
+		idx.Sources[i].Size = int64(r.uint64())
+		idx.Sources[i].ModTime = int64(r.uint64())
+	}
+
+	nNodes := int(r.uint32())
+	nRanks := int(r.uint32())
+	nDelNodes := int(r.uint32())
+	nMerged := int(r.uint32())
+	nameDataLen := int(r.uint64())
+
+	idx.Ranks = make([]string, nRanks)
+	for i := range idx.Ranks {
+		idx.Ranks[i] = string(r.bytes(int(r.uint16())))
+	}
+	r.pad()
+
+	idx.Taxids = r.uint32s(nNodes)
+	idx.Parents = r.uint32s(nNodes)
+	idx.RankIDs = r.uint16s(nNodes)
+	idx.nameOffsets = r.uint32s(nNodes + 1)
+	idx.nameData = r.bytes(nameDataLen)
+	r.pad()
+	idx.DelNodes = r.uint32s(nDelNodes)
+	idx.MergedFrom = r.uint32s(nMerged)
+	idx.MergedTo = r.uint32s(nMerged)
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, id := range idx.RankIDs {
+		if int(id) >= nRanks {
+			return nil, ErrInvalidIndex
+		}
+	}
+	if int(idx.nameOffsets[nNodes]) != nameDataLen {
+		return nil, ErrInvalidIndex
+	}
+
+	return idx, nil
+}
+
+type indexReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *indexReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.off+n > len(r.data) {
+		r.err = ErrInvalidIndex
+		return make([]byte, n)
+	}
+	b := r.data[r.off : r.off+n : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *indexReader) uint16() uint16 { return binary.LittleEndian.Uint16(r.bytes(2)) }
+
+func (r *indexReader) uint32() uint32 { return binary.LittleEndian.Uint32(r.bytes(4)) }
+
+func (r *indexReader) uint64() uint64 { return binary.LittleEndian.Uint64(r.bytes(8)) }
+
+func (r *indexReader) pad() {
+	if m := r.off % 8; m > 0 {
+		r.bytes(8 - m)
+	}
+}
+
+// uint32s returns a slice backed by the mapped file on little-endian machines.
+func (r *indexReader) uint32s(n int) []uint32 {
+	b := r.bytes(n * 4)
+	r.pad()
+	if n == 0 || r.err != nil {
+		return []uint32{}
+	}
+	if littleEndian {
+		return unsafe.Slice((*uint32)(unsafe.Pointer(&b[0])), n)
+	}
+	vs := make([]uint32, n)
+	for i := range vs {
+		vs[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	return vs
+}
+
+func (r *indexReader) uint16s(n int) []uint16 {
+	b := r.bytes(n * 2)
+	r.pad()
+	if n == 0 || r.err != nil {
+		return []uint16{}
+	}
+	if littleEndian {
+		return unsafe.Slice((*uint16)(unsafe.Pointer(&b[0])), n)
+	}
+	vs := make([]uint16, n)
+	for i := range vs {
+		vs[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return vs
+}
+
+// ----------------------------------  load ---------------------------
+
+// OutdatedIndexError means some dump files are changed since the index was built.
+type OutdatedIndexError struct {
+	File string // the changed dump file
+}
+
+func (e *OutdatedIndexError) Error() string {
+	return fmt.Sprintf("taxonomy: index file is outdated, changed file: %s", e.File)
+}
+
+// CheckIndex checks whether an index file is up to date with the dump files
+// it was built from. files should be in the same order as when building.
+// nil is returned if the index is usable.
+func CheckIndex(file string, files []string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	recorded, err := readIndexHeader(file)
+	if err != nil {
+		return err
+	}
+
+	current, err := StatIndexSources(files)
+	if err != nil {
+		return err
+	}
+	if len(recorded) != len(current) {
+		return ErrInvalidIndex
+	}
+	for i, s := range current {
+		if s != recorded[i] || s.ModTime > info.ModTime().UnixNano() {
+			return &OutdatedIndexError{File: files[i]}
+		}
+	}
+	return nil
+}
+
+// NewFromIndex creates a Taxonomy from an index file, with nodes,
+// deleted and merged TaxIds loaded, and optionally ranks and names.
+// Other parts are not available. See OpenIndex for details.
+func NewFromIndex(file string, withRank bool, withName bool) (*Taxonomy, error) {
+	t, err := OpenIndex(file, Files{})
+	if err != nil {
+		return nil, err
+	}
+	parts := PartNodes | PartDelNodes | PartMerged
+	if withRank {
+		parts |= PartRanks
+	}
+	if withName {
+		parts |= PartNames
+	}
+	if err = t.Load(parts); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
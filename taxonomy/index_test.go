@@ -0,0 +1,212 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// copyTestdata copies the test data into a temporary directory,
+// so the files can be modified.
+func copyTestdata(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"nodes.dmp", "names.dmp", "delnodes.dmp", "merged.dmp"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func indexSourceFiles(files Files) []string {
+	return []string{files.Nodes, files.Names, files.DelNodes, files.Merged}
+}
+
+// writeTestIndex builds an index of the dump files in dir.
+func writeTestIndex(t *testing.T, dir string) string {
+	t.Helper()
+	files := testdataFiles(dir)
+	sources, err := StatIndexSources(indexSourceFiles(files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, IndexFileName)
+	if err = WriteIndex(file, Open(files), sources); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	dir := copyTestdata(t)
+	files := testdataFiles(dir)
+	file := writeTestIndex(t, dir)
+
+	if err := CheckIndex(file, indexSourceFiles(files)); err != nil {
+		t.Fatalf("CheckIndex: %s", err)
+	}
+
+	taxdb := openTestdata(t)
+	idxdb, err := OpenIndex(file, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if idxdb.NumNodes() != taxdb.NumNodes() {
+		t.Errorf("NumNodes() = %d, want %d", idxdb.NumNodes(), taxdb.NumNodes())
+	}
+	if idxdb.NumDelNodes() != taxdb.NumDelNodes() {
+		t.Errorf("NumDelNodes() = %d, want %d", idxdb.NumDelNodes(), taxdb.NumDelNodes())
+	}
+	if idxdb.NumMerged() != taxdb.NumMerged() {
+		t.Errorf("NumMerged() = %d, want %d", idxdb.NumMerged(), taxdb.NumMerged())
+	}
+
+	// all nodes, and merged, deleted and unknown TaxIds
+	taxids := append(taxdb.TaxIds(), 12, 30, 3, 4, 99)
+	for _, taxid := range taxids {
+		newtaxid, status := idxdb.Resolve(taxid)
+		wantTaxid, wantStatus := taxdb.Resolve(taxid)
+		if newtaxid != wantTaxid || status != wantStatus {
+			t.Errorf("Resolve(%d) = %d, %s, want %d, %s", taxid, newtaxid, status, wantTaxid, wantStatus)
+		}
+		if name, want := idxdb.Name(taxid), taxdb.Name(taxid); name != want {
+			t.Errorf("Name(%d) = %q, want %q", taxid, name, want)
+		}
+		if rank, want := idxdb.Rank(taxid), taxdb.Rank(taxid); rank != want {
+			t.Errorf("Rank(%d) = %q, want %q", taxid, rank, want)
+		}
+		if list, want := idxdb.LineageTaxIds(taxid), taxdb.LineageTaxIds(taxid); !slices.Equal(list, want) {
+			t.Errorf("LineageTaxIds(%d) = %v, want %v", taxid, list, want)
+		}
+		if list, want := idxdb.LineageNames(taxid), taxdb.LineageNames(taxid); !slices.Equal(list, want) {
+			t.Errorf("LineageNames(%d) = %q, want %q", taxid, list, want)
+		}
+		if lca, want := idxdb.LCA(taxid, 622), taxdb.LCA(taxid, 622); lca != want {
+			t.Errorf("LCA(%d, 622) = %d, want %d", taxid, lca, want)
+		}
+	}
+
+	// parts not in the index are parsed from the dump files
+	if taxids := idxdb.NameMap(false)["human"]; !slices.Equal(taxids, []uint32{9606}) {
+		t.Errorf(`NameMap(false)["human"] = %v, want [9606]`, taxids)
+	}
+}
+
+func TestCheckIndex(t *testing.T) {
+	dir := copyTestdata(t)
+	files := testdataFiles(dir)
+	file := writeTestIndex(t, dir)
+
+	later := time.Now().Add(time.Hour)
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, files Files)
+		file   string // base name of the changed file reported by OutdatedIndexError
+	}{
+		{
+			name: "touched",
+			modify: func(t *testing.T, files Files) {
+				if err := os.Chtimes(files.Merged, later, later); err != nil {
+					t.Fatal(err)
+				}
+			},
+			file: "merged.dmp",
+		},
+		{
+			name: "appended",
+			modify: func(t *testing.T, files Files) {
+				fh, err := os.OpenFile(files.DelNodes, os.O_APPEND|os.O_WRONLY, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fh.WriteString("5\t|\n")
+				fh.Close()
+			},
+			file: "delnodes.dmp",
+		},
+		{
+			name: "removed",
+			modify: func(t *testing.T, files Files) {
+				if err := os.Remove(files.Names); err != nil {
+					t.Fatal(err)
+				}
+			},
+			file: "names.dmp",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := writeTestIndex(t, copyTestdata(t))
+			files := testdataFiles(filepath.Dir(file))
+			test.modify(t, files)
+
+			err := CheckIndex(file, indexSourceFiles(files))
+			var outdated *OutdatedIndexError
+			if !errors.As(err, &outdated) {
+				t.Fatalf("CheckIndex: error %v, want an OutdatedIndexError", err)
+			}
+			if filepath.Base(outdated.File) != test.file {
+				t.Errorf("OutdatedIndexError.File = %s, want %s", outdated.File, test.file)
+			}
+		})
+	}
+
+	// an index with a different number of sources
+	if err := CheckIndex(file, indexSourceFiles(files)[:2]); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("CheckIndex with fewer files: error %v, want %v", err, ErrInvalidIndex)
+	}
+
+	// an invalid index file
+	invalid := filepath.Join(dir, "invalid.idx")
+	if err := os.WriteFile(invalid, []byte("not an index file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckIndex(invalid, indexSourceFiles(files)); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("CheckIndex of an invalid file: error %v, want %v", err, ErrInvalidIndex)
+	}
+	if _, err := OpenIndex(invalid, files); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("OpenIndex of an invalid file: error %v, want %v", err, ErrInvalidIndex)
+	}
+
+	// an index file truncated in the list of sources
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.idx")
+	if err := os.WriteFile(truncated, data[:16+8], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckIndex(truncated, indexSourceFiles(files)); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("CheckIndex of a truncated file: error %v, want %v", err, ErrInvalidIndex)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Name2Taxids returns TaxIds with the scientific name, case ignored.
+// The name -> TaxIds mapping is created on the first call.
+// It returns nil if names are not available.
+func (t *Taxonomy) Name2Taxids(name string) []uint32 {
+	return t.NameMap(true)[strings.ToLower(name)]
+}
+
+// NameMap returns the mapping of lower-case names to TaxIds,
+// which are in ascending order as names.dmp is sorted by TaxId.
+// Only scientific names are used when sciNameOnly is true,
+// otherwise names of all name classes are included.
+// The returned map should not be modified.
+func (t *Taxonomy) NameMap(sciNameOnly bool) map[string][]uint32 {
+	if !sciNameOnly {
+		t.ensure(PartAllNames)
+		t.onceAllName2Taxids.Do(func() {
+			t.allName2taxids = make(map[string][]uint32, len(t.allNames))
+			var _name string
+			seen := make(map[string]struct{}, 8)
+			for taxid, names := range t.allNames {
+				clear(seen)
+				for _, name := range names {
+					// skip duplicated names of a TaxId caused by capitalization
+					_name = strings.ToLower(name.Name)
+					if _, ok := seen[_name]; ok {
+						continue
+					}
+					seen[_name] = struct{}{}
+					t.allName2taxids[_name] = append(t.allName2taxids[_name], taxid)
+				}
+			}
+			sortTaxIdLists(t.allName2taxids)
+		})
+		return t.allName2taxids
+	}
+
+	t.ensure(PartNames)
+	t.onceName2Taxids.Do(func() {
+		t.name2taxids = make(map[string][]uint32, t.NumNames())
+		var name string
+		for i, taxid := range t.tree.taxids { // TaxIds are in ascending order
+			if name = t.tree.name(i); name == "" {
+				continue
+			}
+			name = strings.ToLower(name)
+			t.name2taxids[name] = append(t.name2taxids[name], taxid)
+		}
+	})
+	return t.name2taxids
+}
+
+var reGenusAbbreviation = regexp.MustCompile(`^([A-Z][a-z]*)\.\s*([a-z].*)$`)
+
+// ExpandGenusAbbreviation expands the abbreviated genus of a name,
+// e.g., "E. coli" -> "Escherichia coli". It returns the scientific names
+// which share the rest of the name, case ignored, and have a genus starting
+// with the abbreviation. Multiple names are returned in lexicographic order if
+// several genera match, and nil is returned if the name is not abbreviated
+// or no name matches.
+// The mapping for expansion is created on the first call.
+func (t *Taxonomy) ExpandGenusAbbreviation(name string) []string {
+	m := reGenusAbbreviation.FindStringSubmatch(strings.TrimSpace(name))
+	if m == nil {
+		return nil
+	}
+
+	t.ensure(PartNames)
+	t.onceEpithet2Names.Do(func() {
+		t.epithet2names = make(map[string][]string, 1024)
+		var name, rest string
+		var i, j int
+		for i = range t.tree.taxids {
+			if name = t.tree.name(i); name == "" {
+				continue
+			}
+			// only names like "Genus epithet ...", the genus is capitalized
+			if j = strings.IndexByte(name, ' '); j < 2 || j+1 == len(name) ||
+				name[0] < 'A' || name[0] > 'Z' || name[j+1] < 'a' || name[j+1] > 'z' {
+				continue
+			}
+			rest = strings.ToLower(name[j+1:])
+			if !slices.Contains(t.epithet2names[rest], name) { // homonyms
+				t.epithet2names[rest] = append(t.epithet2names[rest], name)
+			}
+		}
+	})
+
+	abbr := m[1]
+	var names []string
+	for _, name := range t.epithet2names[strings.ToLower(m[2])] {
+		if strings.HasPrefix(name, abbr) && name[len(abbr)] != ' ' {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortTaxIdLists(m map[string][]uint32) {
+	for _, taxids := range m {
+		if len(taxids) > 1 {
+			sort.Slice(taxids, func(i, j int) bool { return taxids[i] < taxids[j] })
+		}
+	}
+}
+
+// ScientificName is the name class of scientific names in names.dmp.
+const ScientificName = "scientific name"
+
+// TaxonName is a name of a taxon in names.dmp.
+type TaxonName struct {
+	Name       string
+	UniqueName string // the unique variant of the name if it's not unique, could be empty
+	Class      string // name class, e.g., "scientific name", "synonym", "genbank common name"
+}
+
+// AllNames returns names of all name classes of a TaxId, in the order of names.dmp.
+// If the TaxId was merged, names of the new one are returned.
+func (t *Taxonomy) AllNames(taxid uint32) []TaxonName {
+	t.ensure(PartAllNames | PartMerged)
+	if names, ok := t.allNames[taxid]; ok {
+		return names
+	}
+	if newtaxid, ok := t.merged[taxid]; ok {
+		return t.allNames[newtaxid]
+	}
+	return nil
+}
+
+// NameInClasses returns the first name of a TaxId in the given name classes,
+// which are checked in order. If none is found, the scientific name is returned.
+func (t *Taxonomy) NameInClasses(taxid uint32, classes ...string) string {
+	if len(classes) == 0 || len(classes) == 1 && classes[0] == ScientificName {
+		return t.Name(taxid)
+	}
+	names := t.AllNames(taxid)
+	for _, class := range classes {
+		for _, name := range names {
+			if name.Class == class {
+				return name.Name
+			}
+		}
+	}
+	return t.Name(taxid)
+}
+
+// NameClassOf returns the name class of a name of a TaxId, case ignored.
+// The scientific name is preferred if the name is shared by multiple classes,
+// e.g., a scientific name also recorded as a "genbank synonym".
+// It returns an empty string if the TaxId has no such a name.
+func (t *Taxonomy) NameClassOf(taxid uint32, name string) string {
+	var class string
+	for _, n := range t.AllNames(taxid) {
+		if !strings.EqualFold(n.Name, name) {
+			continue
+		}
+		if n.Class == ScientificName {
+			return ScientificName
+		}
+		if class == "" {
+			class = n.Class
+		}
+	}
+	return class
+}
+
+// NameClasses returns all name classes and the numbers of names in them.
+// The returned map should not be modified.
+func (t *Taxonomy) NameClasses() map[string]int {
+	t.ensure(PartAllNames)
+	return t.nameClasses
+}
+
+// NumAllNames returns the number of names of all name classes.
+func (t *Taxonomy) NumAllNames() int {
+	var n int
+	for _, c := range t.NameClasses() {
+		n += c
+	}
+	return n
+}
+
+// NameResolver queries TaxIds by taxon names and the names of their parents,
+// which helps to distinguish TaxIds sharing the same names.
+// All names are in lower case.
+type NameResolver struct {
+	// name -> parent-name -> taxid
+	Name2Parent2Taxid map[string]map[string]uint32
+	// name -> taxids
+	Name2Taxids map[string]*[]uint32
+	// name__parent-name -> taxids
+	Ambiguous map[string][]uint32
+}
+
+// NewNameResolver creates links: child name -> parent name -> taxid.
+func (t *Taxonomy) NewNameResolver() (*NameResolver, error) {
+	if !t.HasNames() {
+		return nil, ErrNamesNotLoaded
+	}
+	t.ensure(PartNodes | PartNames)
+
+	r := &NameResolver{
+		Name2Parent2Taxid: make(map[string]map[string]uint32, mapInitialSize),
+		Name2Taxids:       make(map[string]*[]uint32, mapInitialSize),
+		Ambiguous:         make(map[string][]uint32, 128),
+	}
+
+	var name, pname string
+	var _n2i map[string]uint32
+	var ok bool
+	var pair string
+	var taxids *[]uint32
+	tr := t.tree
+	var p uint32
+	for i, child := range tr.taxids {
+		name = strings.ToLower(tr.name(i))
+		if p = tr.parents[i]; p != noParent {
+			pname = strings.ToLower(tr.name(int(p)))
+		} else {
+			pname = ""
+		}
+
+		if _n2i, ok = r.Name2Parent2Taxid[name]; !ok {
+			r.Name2Parent2Taxid[name] = map[string]uint32{pname: child}
+		} else {
+			if _, ok = _n2i[pname]; ok {
+				pair = name + "__" + pname
+				if _, ok = r.Ambiguous[pair]; !ok {
+					r.Ambiguous[pair] = []uint32{_n2i[pname], child}
+				} else {
+					r.Ambiguous[pair] = append(r.Ambiguous[pair], child)
+				}
+			} else {
+				_n2i[pname] = child
+			}
+		}
+
+		if taxids, ok = r.Name2Taxids[name]; !ok {
+			r.Name2Taxids[name] = &[]uint32{child}
+		} else {
+			*taxids = append(*taxids, child)
+		}
+	}
+
+	return r, nil
+}
+
+// TaxIds returns TaxIds of a name.
+func (r *NameResolver) TaxIds(name string) []uint32 {
+	taxids := r.Name2Taxids[strings.ToLower(name)]
+	if taxids == nil {
+		return nil
+	}
+	return *taxids
+}
+
+// TaxIdWithParent returns the TaxId of a name with the name of its parent.
+// If the pair is shared by multiple TaxIds, all of them are also returned.
+func (r *NameResolver) TaxIdWithParent(name, pname string) (uint32, []uint32, bool) {
+	name = strings.ToLower(name)
+	pname = strings.ToLower(pname)
+
+	taxid, ok := r.Name2Parent2Taxid[name][pname]
+	if !ok {
+		return 0, nil, false
+	}
+	return taxid, r.Ambiguous[name+"__"+pname], true
+}
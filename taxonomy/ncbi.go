@@ -0,0 +1,369 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+)
+
+var mapInitialSize = 8 << 10
+
+// readFile opens a dump file and parses it with fn, empty files are parsed as empty.
+func readFile(file string, fn func(r io.Reader) error) error {
+	fh, err := xopen.Ropen(file)
+	if err == xopen.ErrNoContent {
+		return fn(strings.NewReader(""))
+	} else if err != nil {
+		return fmt.Errorf("taxonomy: %s: %s", file, err)
+	}
+	defer fh.Close()
+
+	if err = fn(fh); err != nil {
+		return fmt.Errorf("taxonomy: %s: %s", file, err)
+	}
+	return nil
+}
+
+// NewFromNCBI creates a Taxonomy from NCBI taxdump files, with nodes,
+// names, deleted and merged TaxIds loaded, and ranks if withRank is true.
+// namesFile, delNodesFile and mergedFile can be empty if they are not needed.
+// Use Open to load data on demand.
+func NewFromNCBI(nodesFile, namesFile, delNodesFile, mergedFile string, withRank bool) (*Taxonomy, error) {
+	t := Open(Files{Nodes: nodesFile, Names: namesFile, DelNodes: delNodesFile, Merged: mergedFile})
+	parts := PartNodes | PartNames | PartDelNodes | PartMerged
+	if withRank {
+		parts |= PartRanks
+	}
+	if err := t.Load(parts); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ReadNodes parses nodes.dmp, returning child -> parent and taxid -> rank.
+// Ranks are only recorded when withRank is true.
+func ReadNodes(file string, withRank bool) (map[uint32]uint32, map[uint32]string, error) {
+	var tree map[uint32]uint32
+	var ranks map[uint32]string
+	err := readFile(file, func(r io.Reader) (err error) {
+		tree, ranks, err = ParseNodes(r, withRank)
+		return err
+	})
+	return tree, ranks, err
+}
+
+// ParseNodes is like ReadNodes, but parses data from a reader.
+func ParseNodes(r io.Reader, withRank bool) (map[uint32]uint32, map[uint32]string, error) {
+	tree := make(map[uint32]uint32, mapInitialSize)
+	var ranks map[uint32]string
+	if withRank {
+		ranks = make(map[uint32]string, mapInitialSize)
+	}
+
+	var err error
+
+	items := make([]string, 6)
+	scanner := bufio.NewScanner(r)
+	var _child, _parent int
+	var child, parent uint32
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 6, &items)
+		if len(items) < 6 {
+			continue
+		}
+
+		_child, err = strconv.Atoi(items[0])
+		if err != nil {
+			continue
+		}
+
+		_parent, err = strconv.Atoi(items[2])
+		if err != nil {
+			continue
+		}
+		child, parent = uint32(_child), uint32(_parent)
+
+		tree[child] = parent
+		if withRank {
+			ranks[child] = items[4]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return tree, ranks, nil
+}
+
+// ReadNames parses scientific names in names.dmp.
+func ReadNames(file string) (map[uint32]string, error) {
+	var names map[uint32]string
+	err := readFile(file, func(r io.Reader) (err error) {
+		names, err = ParseNames(r)
+		return err
+	})
+	return names, err
+}
+
+// ParseNames is like ReadNames, but parses data from a reader.
+func ParseNames(r io.Reader) (map[uint32]string, error) {
+	var err error
+
+	taxid2name := make(map[uint32]string, mapInitialSize)
+
+	items := make([]string, 8)
+	scanner := bufio.NewScanner(r)
+	var id int
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 8, &items)
+		if len(items) < 8 {
+			continue
+		}
+		if items[6] != ScientificName {
+			continue
+		}
+		id, err = strconv.Atoi(items[0])
+		if err != nil {
+			continue
+		}
+
+		taxid2name[uint32(id)] = items[2]
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return taxid2name, nil
+}
+
+// ReadAllNames parses names of all name classes in names.dmp,
+// returning TaxId -> names in the order of the file.
+func ReadAllNames(file string) (map[uint32][]TaxonName, error) {
+	var names map[uint32][]TaxonName
+	err := readFile(file, func(r io.Reader) (err error) {
+		names, err = ParseAllNames(r)
+		return err
+	})
+	return names, err
+}
+
+// ParseAllNames is like ReadAllNames, but parses data from a reader.
+func ParseAllNames(r io.Reader) (map[uint32][]TaxonName, error) {
+	var err error
+
+	taxid2names := make(map[uint32][]TaxonName, mapInitialSize)
+
+	items := make([]string, 8)
+	scanner := bufio.NewScanner(r)
+	var id int
+	var taxid uint32
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 8, &items)
+		if len(items) < 7 {
+			continue
+		}
+		id, err = strconv.Atoi(items[0])
+		if err != nil {
+			continue
+		}
+		taxid = uint32(id)
+
+		taxid2names[taxid] = append(taxid2names[taxid],
+			TaxonName{Name: items[2], UniqueName: items[4], Class: items[6]})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return taxid2names, nil
+}
+
+// ReadName2Taxids parses names.dmp, returning lower-case names -> TaxIds.
+// Only scientific names are used when sciNameOnly is true.
+func ReadName2Taxids(file string, sciNameOnly bool) (map[string][]uint32, error) {
+	var name2taxids map[string][]uint32
+	err := readFile(file, func(r io.Reader) (err error) {
+		name2taxids, err = ParseName2Taxids(r, sciNameOnly)
+		return err
+	})
+	return name2taxids, err
+}
+
+// ParseName2Taxids is like ReadName2Taxids, but parses data from a reader.
+func ParseName2Taxids(r io.Reader, sciNameOnly bool) (map[string][]uint32, error) {
+	var err error
+
+	name2taxids := make(map[string][]uint32, mapInitialSize)
+
+	items := make([]string, 8)
+	scanner := bufio.NewScanner(r)
+	var preTaxid, taxid string
+	var id int
+	var name string
+	var ok bool
+	m := make(map[string]interface{})
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 8, &items)
+		if len(items) < 7 {
+			continue
+		}
+		if sciNameOnly && items[6] != ScientificName {
+			continue
+		}
+		taxid = items[0]
+		name = strings.ToLower(items[2])
+
+		// skip duplicated names of a TaxId caused by capitalization
+		if taxid == preTaxid {
+			if _, ok = m[name]; ok {
+				continue
+			}
+			m[name] = struct{}{}
+		} else {
+			clear(m)
+			m[name] = struct{}{}
+		}
+
+		id, err = strconv.Atoi(taxid)
+		if err != nil {
+			continue
+		}
+
+		name2taxids[name] = append(name2taxids[name], uint32(id))
+
+		preTaxid = taxid
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return name2taxids, nil
+}
+
+// ReadDelNodes parses delnodes.dmp.
+func ReadDelNodes(file string) (map[uint32]struct{}, error) {
+	var taxids map[uint32]struct{}
+	err := readFile(file, func(r io.Reader) (err error) {
+		taxids, err = ParseDelNodes(r)
+		return err
+	})
+	return taxids, err
+}
+
+// ParseDelNodes is like ReadDelNodes, but parses data from a reader.
+func ParseDelNodes(r io.Reader) (map[uint32]struct{}, error) {
+	taxids := make(map[uint32]struct{}, 1<<10)
+
+	var err error
+
+	items := make([]string, 2)
+
+	scanner := bufio.NewScanner(r)
+	var id int
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 2, &items)
+		if len(items) < 2 {
+			continue
+		}
+		id, err = strconv.Atoi(items[0])
+		if err != nil {
+			continue
+		}
+
+		taxids[uint32(id)] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return taxids, nil
+}
+
+// ReadMergedNodes parses merged.dmp, returning old TaxId -> new TaxId.
+func ReadMergedNodes(file string) (map[uint32]uint32, error) {
+	var merges map[uint32]uint32
+	err := readFile(file, func(r io.Reader) (err error) {
+		merges, err = ParseMergedNodes(r)
+		return err
+	})
+	return merges, err
+}
+
+// ParseMergedNodes is like ReadMergedNodes, but parses data from a reader.
+func ParseMergedNodes(r io.Reader) (map[uint32]uint32, error) {
+	merges := make(map[uint32]uint32, 1<<10)
+
+	var err error
+
+	items := make([]string, 4)
+
+	scanner := bufio.NewScanner(r)
+	var from, to int
+	for scanner.Scan() {
+		stringSplitN(scanner.Text(), "\t", 4, &items)
+		if len(items) < 4 {
+			continue
+		}
+		from, err = strconv.Atoi(items[0])
+		if err != nil {
+			continue
+		}
+		to, err = strconv.Atoi(items[2])
+		if err != nil {
+			continue
+		}
+
+		merges[uint32(from)] = uint32(to)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return merges, nil
+}
+
+func stringSplitN(s string, sep string, n int, a *[]string) {
+	if a == nil {
+		tmp := make([]string, n)
+		a = &tmp
+	}
+
+	n--
+	i := 0
+	for i < n {
+		m := strings.Index(s, sep)
+		if m < 0 {
+			break
+		}
+		(*a)[i] = s[:m]
+		s = s[m+len(sep):]
+		i++
+	}
+	(*a)[i] = s
+
+	(*a) = (*a)[:i+1]
+}
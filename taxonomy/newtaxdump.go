@@ -0,0 +1,248 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+// Files in new_taxdump.tar.gz of NCBI:
+// https://ftp.ncbi.nlm.nih.gov/pub/taxonomy/new_taxdump/taxdump_readme.txt
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TypeMaterial is a record of typematerial.dmp.
+type TypeMaterial struct {
+	Name       string // organism name type material is assigned to
+	Type       string // type material type, e.g., "type strain", "neotype"
+	Identifier string // identifier in type material collection, e.g., "ATCC 11775"
+}
+
+// RankedLineage is a record of rankedlineage.dmp.
+type RankedLineage struct {
+	Name string
+	// Names of ancestors at ranks of RankedLineageRanks, empty for missing ones.
+	// Extra columns of newer files are appended in order.
+	Lineage []string
+}
+
+// RankedLineageRanks are the ranks of columns in rankedlineage.dmp.
+var RankedLineageRanks = []string{"species", "genus", "family", "order", "class", "phylum", "kingdom", "superkingdom"}
+
+// Hosts returns the potential hosts of a TaxId, provided by host.dmp.
+func (t *Taxonomy) Hosts(taxid uint32) []string {
+	t.ensure(PartHosts | PartMerged)
+	if hosts, ok := t.hosts[taxid]; ok {
+		return hosts
+	}
+	if newtaxid, ok := t.merged[taxid]; ok {
+		return t.hosts[newtaxid]
+	}
+	return nil
+}
+
+// TypeMaterials returns type materials of a TaxId, provided by typematerial.dmp.
+func (t *Taxonomy) TypeMaterials(taxid uint32) []TypeMaterial {
+	t.ensure(PartTypeMaterial | PartMerged)
+	if types, ok := t.typeMaterials[taxid]; ok {
+		return types
+	}
+	if newtaxid, ok := t.merged[taxid]; ok {
+		return t.typeMaterials[newtaxid]
+	}
+	return nil
+}
+
+// NumHosts returns the number of TaxIds with potential hosts.
+func (t *Taxonomy) NumHosts() int {
+	t.ensure(PartHosts)
+	return len(t.hosts)
+}
+
+// NumTypeMaterials returns the number of TaxIds with type materials.
+func (t *Taxonomy) NumTypeMaterials() int {
+	t.ensure(PartTypeMaterial)
+	return len(t.typeMaterials)
+}
+
+// ReadHosts parses host.dmp, returning TaxId -> potential hosts.
+func ReadHosts(file string) (map[uint32][]string, error) {
+	var hosts map[uint32][]string
+	err := readFile(file, func(r io.Reader) (err error) {
+		hosts, err = ParseHosts(r)
+		return err
+	})
+	return hosts, err
+}
+
+// ParseHosts is like ReadHosts, but parses data from a reader.
+func ParseHosts(r io.Reader) (map[uint32][]string, error) {
+	hosts := make(map[uint32][]string, mapInitialSize)
+	err := readDmp(r, 2, func(id int, items []string) {
+		if items[1] == "" {
+			return
+		}
+		hosts[uint32(id)] = strings.Split(items[1], ",")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// ReadTypeMaterials parses typematerial.dmp, returning TaxId -> type materials in the order of the file.
+func ReadTypeMaterials(file string) (map[uint32][]TypeMaterial, error) {
+	var types map[uint32][]TypeMaterial
+	err := readFile(file, func(r io.Reader) (err error) {
+		types, err = ParseTypeMaterials(r)
+		return err
+	})
+	return types, err
+}
+
+// ParseTypeMaterials is like ReadTypeMaterials, but parses data from a reader.
+func ParseTypeMaterials(r io.Reader) (map[uint32][]TypeMaterial, error) {
+	types := make(map[uint32][]TypeMaterial, mapInitialSize)
+	err := readDmp(r, 4, func(id int, items []string) {
+		types[uint32(id)] = append(types[uint32(id)],
+			TypeMaterial{Name: items[1], Type: items[2], Identifier: items[3]})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// ReadTaxIdLineages parses taxidlineage.dmp, returning TaxId -> TaxIds of ancestors,
+// from the top (the root is not included) to the parent.
+func ReadTaxIdLineages(file string) (map[uint32][]uint32, error) {
+	var lineages map[uint32][]uint32
+	err := readFile(file, func(r io.Reader) (err error) {
+		lineages, err = ParseTaxIdLineages(r)
+		return err
+	})
+	return lineages, err
+}
+
+// ParseTaxIdLineages is like ReadTaxIdLineages, but parses data from a reader.
+func ParseTaxIdLineages(r io.Reader) (map[uint32][]uint32, error) {
+	lineages := make(map[uint32][]uint32, mapInitialSize)
+	var ids []string
+	err := readDmp(r, 2, func(id int, items []string) {
+		ids = strings.Fields(items[1])
+		lineage := make([]uint32, 0, len(ids))
+		for _, s := range ids {
+			taxid, err := strconv.Atoi(s)
+			if err != nil {
+				continue
+			}
+			lineage = append(lineage, uint32(taxid))
+		}
+		lineages[uint32(id)] = lineage
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lineages, nil
+}
+
+// ReadFullNameLineages parses fullnamelineage.dmp, returning TaxId -> scientific name
+// and TaxId -> names of ancestors, from the top (the root is not included) to the parent.
+func ReadFullNameLineages(file string) (map[uint32]string, map[uint32][]string, error) {
+	var names map[uint32]string
+	var lineages map[uint32][]string
+	err := readFile(file, func(r io.Reader) (err error) {
+		names, lineages, err = ParseFullNameLineages(r)
+		return err
+	})
+	return names, lineages, err
+}
+
+// ParseFullNameLineages is like ReadFullNameLineages, but parses data from a reader.
+func ParseFullNameLineages(r io.Reader) (map[uint32]string, map[uint32][]string, error) {
+	names := make(map[uint32]string, mapInitialSize)
+	lineages := make(map[uint32][]string, mapInitialSize)
+	err := readDmp(r, 3, func(id int, items []string) {
+		names[uint32(id)] = items[1]
+
+		lineage := strings.Split(strings.TrimSuffix(items[2], "; "), "; ")
+		if len(lineage) == 1 && lineage[0] == "" {
+			lineage = lineage[:0]
+		}
+		lineages[uint32(id)] = lineage
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return names, lineages, nil
+}
+
+// ReadRankedLineages parses rankedlineage.dmp.
+func ReadRankedLineages(file string) (map[uint32]RankedLineage, error) {
+	var lineages map[uint32]RankedLineage
+	err := readFile(file, func(r io.Reader) (err error) {
+		lineages, err = ParseRankedLineages(r)
+		return err
+	})
+	return lineages, err
+}
+
+// ParseRankedLineages is like ReadRankedLineages, but parses data from a reader.
+func ParseRankedLineages(r io.Reader) (map[uint32]RankedLineage, error) {
+	lineages := make(map[uint32]RankedLineage, mapInitialSize)
+
+	scanner := bufio.NewScanner(r)
+	var items []string
+	var id int
+	var err error
+	for scanner.Scan() {
+		items = strings.Split(strings.TrimSuffix(scanner.Text(), "\t|"), "\t|\t")
+		if len(items) < 2 {
+			continue
+		}
+		id, err = strconv.Atoi(items[0])
+		if err != nil {
+			continue
+		}
+		lineage := make([]string, len(items)-2)
+		copy(lineage, items[2:])
+		lineages[uint32(id)] = RankedLineage{Name: items[1], Lineage: lineage}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lineages, nil
+}
+
+// parentsFromLineages converts TaxId lineages of taxidlineage.dmp to child -> parent.
+// The root node (1) is not included in lineages of the file.
+func parentsFromLineages(lineages map[uint32][]uint32) map[uint32]uint32 {
+	nodes := make(map[uint32]uint32, len(lineages))
+	for taxid, lineage := range lineages {
+		if len(lineage) == 0 {
+			nodes[taxid] = 1
+			continue
+		}
+		nodes[taxid] = lineage[len(lineage)-1]
+	}
+	return nodes
+}
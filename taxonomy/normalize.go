@@ -0,0 +1,319 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// nameNormStep is a step of normalizing taxon names.
+// It returns variants of the name, the last one is passed to the next step.
+type nameNormStep func(name string) []string
+
+// nameNormSteps are all steps of normalizing taxon names, in order.
+// Names of them are listed in NameNormSteps.
+var nameNormSteps = []struct {
+	name string
+	step nameNormStep
+}{
+	{"unicode", normUnicode},
+	{"space", normSpace},
+	{"bracket", normBracket},
+	{"qualifier", normQualifier},
+	{"authority", normAuthority},
+	{"candidatus", normCandidatus},
+}
+
+// NameNormSteps are names of all steps of normalizing taxon names, in order:
+//
+//	unicode     folding letters with diacritics, quotes and dashes to ASCII
+//	space       trimming and collapsing white spaces
+//	bracket     removing brackets and quotes, e.g., "[Clostridium] scindens"
+//	qualifier   removing open nomenclature qualifiers, e.g., "sp." and "cf."
+//	authority   removing authorities and years
+//	candidatus  removing "Candidatus" or its abbreviations
+var NameNormSteps = func() []string {
+	steps := make([]string, len(nameNormSteps))
+	for i, s := range nameNormSteps {
+		steps[i] = s.name
+	}
+	return steps
+}()
+
+// NameNormalizer normalizes taxon names with chosen steps.
+type NameNormalizer struct {
+	steps []nameNormStep
+}
+
+// NewNameNormalizer creates a NameNormalizer with steps in NameNormSteps,
+// which are always applied in the order of NameNormSteps.
+func NewNameNormalizer(steps []string) (*NameNormalizer, error) {
+	chosen := make(map[string]struct{}, len(steps))
+	for _, s := range steps {
+		chosen[strings.ToLower(strings.TrimSpace(s))] = struct{}{}
+	}
+
+	n := &NameNormalizer{}
+	for _, s := range nameNormSteps {
+		if _, ok := chosen[s.name]; ok {
+			n.steps = append(n.steps, s.step)
+			delete(chosen, s.name)
+		}
+	}
+	for s := range chosen {
+		return nil, fmt.Errorf(`taxonomy: invalid name normalization step: "%s", available: "%s"`, s, strings.Join(NameNormSteps, `", "`))
+	}
+	return n, nil
+}
+
+// Variants returns the name and its normalized variants in the order of steps, without duplicates.
+// The last one is the fully normalized name.
+func (n *NameNormalizer) Variants(name string) []string {
+	variants := make([]string, 0, len(n.steps)+2)
+	variants = append(variants, name)
+	add := func(v string) {
+		for _, s := range variants {
+			if s == v {
+				return
+			}
+		}
+		variants = append(variants, v)
+	}
+
+	var vs []string
+	for _, step := range n.steps {
+		if vs = step(name); len(vs) == 0 {
+			continue
+		}
+		for _, v := range vs {
+			if v != "" {
+				add(v)
+			}
+		}
+		if last := vs[len(vs)-1]; last != "" {
+			name = last
+		}
+	}
+
+	if name != variants[len(variants)-1] { // make sure the last one is the fully normalized name
+		variants = append(variants, name)
+	}
+	return variants
+}
+
+// Normalize returns the fully normalized name.
+func (n *NameNormalizer) Normalize(name string) string {
+	variants := n.Variants(name)
+	return variants[len(variants)-1]
+}
+
+var defaultNameNormalizer, _ = NewNameNormalizer(NameNormSteps)
+
+// NormalizeName normalizes a taxon name with all steps in NameNormSteps, e.g.,
+// "Candidatus Pelagibacter ubique Rappé et al. 2002" -> "Pelagibacter ubique".
+func NormalizeName(name string) string {
+	return defaultNameNormalizer.Normalize(name)
+}
+
+// foldedRunes maps letters with diacritics and typographic symbols to ASCII.
+var foldedRunes = map[rune]string{
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A", 'Å': "A", 'Æ': "AE", 'Ç': "C",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I",
+	'Ð': "D", 'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ö': "O", 'Ø': "O",
+	'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "U", 'Ý': "Y", 'Þ': "Th", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae", 'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ð': "d", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'þ': "th", 'ÿ': "y",
+	'Ā': "A", 'ā': "a", 'Ă': "A", 'ă': "a", 'Ą': "A", 'ą': "a", 'Ć': "C", 'ć': "c",
+	'Č': "C", 'č': "c", 'Ď': "D", 'ď': "d", 'Đ': "D", 'đ': "d", 'Ē': "E", 'ē': "e",
+	'Ė': "E", 'ė': "e", 'Ę': "E", 'ę': "e", 'Ě': "E", 'ě': "e", 'Ğ': "G", 'ğ': "g",
+	'Ī': "I", 'ī': "i", 'İ': "I", 'ı': "i", 'Ł': "L", 'ł': "l", 'Ń': "N", 'ń': "n",
+	'Ň': "N", 'ň': "n", 'Ō': "O", 'ō': "o", 'Ő': "O", 'ő': "o", 'Œ': "OE", 'œ': "oe",
+	'Ř': "R", 'ř': "r", 'Ś': "S", 'ś': "s", 'Ş': "S", 'ş': "s", 'Š': "S", 'š': "s",
+	'Ţ': "T", 'ţ': "t", 'Ť': "T", 'ť': "t", 'Ū': "U", 'ū': "u", 'Ů': "U", 'ů': "u",
+	'Ű': "U", 'ű': "u", 'Ÿ': "Y", 'Ź': "Z", 'ź': "z", 'Ż': "Z", 'ż': "z", 'Ž': "Z", 'ž': "z",
+	'‘': "'", '’': "'", '‚': "'", '‛': "'", '′': "'", '“': `"`, '”': `"`, '„': `"`, '″': `"`,
+	'×': "x", '…': "...",
+}
+
+// normUnicode folds letters with diacritics, quotes and dashes to ASCII,
+// turns Unicode spaces to ASCII spaces, and removes invisible characters.
+func normUnicode(name string) []string {
+	var b strings.Builder
+	b.Grow(len(name))
+	for _, r := range name {
+		if r < 128 {
+			b.WriteRune(r)
+			continue
+		}
+		if s, ok := foldedRunes[r]; ok {
+			b.WriteString(s)
+			continue
+		}
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		case unicode.Is(unicode.Pd, r): // dashes
+			b.WriteByte('-')
+		case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Cf, r): // combining marks, zero-width characters
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return []string{b.String()}
+}
+
+// normSpace trims and collapses white spaces.
+func normSpace(name string) []string {
+	return []string{strings.Join(strings.Fields(name), " ")}
+}
+
+// normBracket removes square brackets around misclassified genera,
+// e.g., "[Clostridium] scindens", and quotes around names.
+func normBracket(name string) []string {
+	name = strings.Trim(strings.NewReplacer("[", "", "]", "").Replace(name), `"' `)
+	return []string{strings.Join(strings.Fields(name), " ")}
+}
+
+// normQualifier removes open nomenclature qualifiers,
+// e.g., "Bacteroides sp. ABC" -> "Bacteroides", "Bacteroides cf. fragilis" -> "Bacteroides fragilis".
+func normQualifier(name string) []string {
+	words := strings.Fields(name)
+	kept := make([]string, 0, len(words))
+	for i, w := range words {
+		if i == 0 {
+			kept = append(kept, w)
+			continue
+		}
+		switch strings.ToLower(w) {
+		case "sp.", "sp", "spp.", "spp":
+			return []string{strings.Join(kept, " ")}
+		case "cf.", "cf", "aff.", "aff", "nr.", "?":
+			continue
+		}
+		kept = append(kept, w)
+	}
+	return []string{strings.Join(kept, " ")}
+}
+
+// reYear matches years followed by punctuations, e.g., "1758," and "1895)".
+// Bare numbers are not treated as years, as they might be parts of strain names, e.g., "NCTC 9001".
+var reYear = regexp.MustCompile(`^\d{4}[,;)]+$`)
+
+// infraspecificMarkers are words followed by infraspecific names, which are kept.
+var infraspecificMarkers = map[string]struct{}{
+	"subsp.": {}, "ssp.": {}, "var.": {}, "f.": {}, "forma": {}, "pv.": {}, "bv.": {},
+	"serovar": {}, "biovar": {}, "str.": {}, "strain": {}, "genomosp.": {},
+}
+
+// normAuthority removes authorities and years following names, e.g.,
+// "Escherichia coli (Migula 1895) Castellani and Chalmers 1919" -> "Escherichia coli",
+// "Homo sapiens Linnaeus, 1758" -> "Homo sapiens". Strain names like "K-12" are kept.
+func normAuthority(name string) []string {
+	words := strings.Fields(name)
+	start := 1
+	if len(words) > 0 && isCandidatus(words[0]) { // the genus follows "Candidatus"
+		start = 2
+	}
+	if len(words) <= start || !startsWithUpper(words[start-1]) {
+		return []string{name}
+	}
+
+	kept := make([]string, start, len(words))
+	copy(kept, words[:start])
+	for i := start; i < len(words); i++ {
+		w := words[i]
+		if _, ok := infraspecificMarkers[strings.ToLower(w)]; ok {
+			kept = append(kept, w)
+			if i+1 < len(words) {
+				i++
+				kept = append(kept, words[i])
+			}
+			continue
+		}
+		if isAuthorityWord(w) {
+			break
+		}
+		kept = append(kept, w)
+	}
+	return []string{strings.Join(kept, " ")}
+}
+
+// isAuthorityWord checks if a word starts an authority,
+// i.e., an author name, a parenthetical authority, a conjunction or a year.
+func isAuthorityWord(w string) bool {
+	switch strings.ToLower(w) {
+	case "and", "&", "et", "ex", "emend.", "in":
+		return true
+	}
+	if w[0] == '(' || reYear.MatchString(w) {
+		return true
+	}
+	if !startsWithUpper(w) {
+		return false
+	}
+	if len(w) == 2 && w[1] == '.' { // abbreviations, e.g., "L."
+		return true
+	}
+	// author names consist of letters (and dots, hyphens, apostrophes, commas),
+	// and are not all in upper case like strain names, e.g., "NCTC".
+	var lower bool
+	for _, r := range w {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsLetter(r), r == '.', r == '-', r == '\'', r == ',':
+		default:
+			return false
+		}
+	}
+	return lower
+}
+
+func startsWithUpper(w string) bool {
+	for _, r := range w {
+		return unicode.IsUpper(r)
+	}
+	return false
+}
+
+// normCandidatus expands the abbreviation "Ca." of "Candidatus",
+// and then removes "Candidatus", e.g., "Candidatus Pelagibacter" -> "Pelagibacter".
+func normCandidatus(name string) []string {
+	words := strings.Fields(name)
+	if len(words) < 2 || !isCandidatus(words[0]) {
+		return nil
+	}
+	rest := strings.Join(words[1:], " ")
+	return []string{"Candidatus " + rest, rest}
+}
+
+func isCandidatus(w string) bool {
+	switch strings.ToLower(w) {
+	case "candidatus", "ca.", "cand.":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNameNormSteps(t *testing.T) {
+	tests := []struct {
+		step string
+		fn   nameNormStep
+		name string
+		want []string
+	}{
+		{"unicode", normUnicode, "Rappé", []string{"Rappe"}},
+		{"unicode", normUnicode, "Escherichia coli (Migula 1895) Castellani & Chalmers 1919", []string{"Escherichia coli (Migula 1895) Castellani & Chalmers 1919"}},
+		{"unicode", normUnicode, "Wolbachia endosymbiont", []string{"Wolbachia endosymbiont"}},
+		{"unicode", normUnicode, "Abc​ def", []string{"Abc def"}},
+		{"unicode", normUnicode, "Café", []string{"Cafe"}},
+		{"unicode", normUnicode, "‘Candidatus’ Foo – bar", []string{"'Candidatus' Foo - bar"}},
+
+		{"space", normSpace, "  Homo \t sapiens  ", []string{"Homo sapiens"}},
+
+		{"bracket", normBracket, "[Clostridium] scindens", []string{"Clostridium scindens"}},
+		{"bracket", normBracket, `"Bacteroides fragilis"`, []string{"Bacteroides fragilis"}},
+		{"bracket", normBracket, "[ Clostridium ] scindens", []string{"Clostridium scindens"}},
+
+		{"qualifier", normQualifier, "Bacteroides sp. ABC", []string{"Bacteroides"}},
+		{"qualifier", normQualifier, "Bacteroides spp.", []string{"Bacteroides"}},
+		{"qualifier", normQualifier, "Bacteroides cf. fragilis", []string{"Bacteroides fragilis"}},
+		{"qualifier", normQualifier, "Bacteroides aff. fragilis", []string{"Bacteroides fragilis"}},
+		{"qualifier", normQualifier, "Sp. nov", []string{"Sp. nov"}}, // the first word is kept
+
+		{"authority", normAuthority, "Escherichia coli (Migula 1895) Castellani and Chalmers 1919", []string{"Escherichia coli"}},
+		{"authority", normAuthority, "Homo sapiens Linnaeus, 1758", []string{"Homo sapiens"}},
+		{"authority", normAuthority, "Bellis perennis L.", []string{"Bellis perennis"}},
+		{"authority", normAuthority, "Escherichia coli K-12", []string{"Escherichia coli K-12"}},
+		{"authority", normAuthority, "Escherichia coli NCTC 9001", []string{"Escherichia coli NCTC 9001"}},
+		{"authority", normAuthority, "Salmonella enterica subsp. enterica", []string{"Salmonella enterica subsp. enterica"}},
+		{"authority", normAuthority, "Brassica oleracea var. Capitata L.", []string{"Brassica oleracea var. Capitata"}},
+		{"authority", normAuthority, "Candidatus Pelagibacter ubique Rappe et al. 2002", []string{"Candidatus Pelagibacter ubique"}},
+		{"authority", normAuthority, "Ca. Pelagibacter", []string{"Ca. Pelagibacter"}},
+		{"authority", normAuthority, "Homo", []string{"Homo"}},
+		{"authority", normAuthority, "uncultured bacterium Smith", []string{"uncultured bacterium Smith"}},
+
+		{"candidatus", normCandidatus, "Candidatus Pelagibacter ubique", []string{"Candidatus Pelagibacter ubique", "Pelagibacter ubique"}},
+		{"candidatus", normCandidatus, "Ca. Pelagibacter", []string{"Candidatus Pelagibacter", "Pelagibacter"}},
+		{"candidatus", normCandidatus, "Cand. Pelagibacter", []string{"Candidatus Pelagibacter", "Pelagibacter"}},
+		{"candidatus", normCandidatus, "Pelagibacter ubique", nil},
+		{"candidatus", normCandidatus, "Candidatus", nil},
+	}
+	for _, test := range tests {
+		if got := test.fn(test.name); !slices.Equal(got, test.want) {
+			t.Errorf("step %s of %q: %q, want %q", test.step, test.name, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Homo sapiens", "Homo sapiens"},
+		{"  Homo   sapiens Linnaeus, 1758 ", "Homo sapiens"},
+		{"[Clostridium] scindens", "Clostridium scindens"},
+		{"Candidatus Pelagibacter ubique Rappé et al. 2002", "Pelagibacter ubique"},
+		{"Ca. Pelagibacter sp. HTCC7211", "Pelagibacter"},
+		{"Escherichia coli (Migula 1895) Castellani and Chalmers 1919", "Escherichia coli"},
+		{"Escherichia coli str. K-12 substr. MG1655", "Escherichia coli str. K-12 substr. MG1655"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := NormalizeName(test.name); got != test.want {
+			t.Errorf("NormalizeName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNameNormalizer(t *testing.T) {
+	if _, err := NewNameNormalizer([]string{"space", "typo"}); err == nil {
+		t.Errorf("NewNameNormalizer with an invalid step: no error")
+	}
+
+	tests := []struct {
+		steps    []string
+		name     string
+		variants []string
+	}{
+		{
+			steps:    NameNormSteps,
+			name:     "Candidatus Pelagibacter ubique Rappé et al. 2002",
+			variants: []string{"Candidatus Pelagibacter ubique Rappé et al. 2002", "Candidatus Pelagibacter ubique Rappe et al. 2002", "Candidatus Pelagibacter ubique", "Pelagibacter ubique"},
+		},
+		{
+			// steps are applied in the fixed order, case ignored
+			steps:    []string{"Candidatus", " space"},
+			name:     " Ca.  Pelagibacter",
+			variants: []string{" Ca.  Pelagibacter", "Ca. Pelagibacter", "Candidatus Pelagibacter", "Pelagibacter"},
+		},
+		{
+			steps:    []string{"bracket"},
+			name:     "Homo sapiens",
+			variants: []string{"Homo sapiens"},
+		},
+		{
+			steps:    nil,
+			name:     "[Clostridium] scindens",
+			variants: []string{"[Clostridium] scindens"},
+		},
+	}
+	for _, test := range tests {
+		n, err := NewNameNormalizer(test.steps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if variants := n.Variants(test.name); !slices.Equal(variants, test.variants) {
+			t.Errorf("Variants(%q) with steps %q: %q, want %q", test.name, test.steps, variants, test.variants)
+		}
+		if got, want := n.Normalize(test.name), test.variants[len(test.variants)-1]; got != want {
+			t.Errorf("Normalize(%q) with steps %q: %q, want %q", test.name, test.steps, got, want)
+		}
+	}
+}
@@ -18,28 +18,30 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-package cmd
+package taxonomy
 
 import (
 	"strconv"
-
-	"github.com/shenwei356/bio/taxdump"
 )
 
+// Target is a taxon with abundance in a taxonomic profile.
 type Target struct {
 	Taxid     uint32
 	Abundance float64
 
 	Rank          string
 	TaxonName     string
-	LineageNames  []string
-	LineageTaxids []string
+	LineageNames  []string // names of ranks to show
+	LineageTaxids []string // TaxIds of ranks to show
 
 	CompleteLineageNames  []string
 	CompleteLineageTaxids []uint32
 }
 
-func (t *Target) AddTaxonomy(taxdb *taxdump.Taxonomy, showRanksMap map[string]interface{}, taxid uint32) bool {
+// AddTaxonomy fills the taxonomy information of a TaxId,
+// only ranks in showRanksMap are kept in LineageNames and LineageTaxids if it's not empty.
+// false is returned if the TaxId is deleted or not found.
+func (t *Target) AddTaxonomy(taxdb *Taxonomy, showRanksMap map[string]interface{}, taxid uint32) bool {
 	var ok bool
 	t.Taxid, ok = taxdb.TaxId(taxid)
 	if !ok {
@@ -71,12 +73,13 @@ func (t *Target) AddTaxonomy(taxdb *taxdump.Taxonomy, showRanksMap map[string]in
 
 	t.LineageNames = make([]string, len(_taxids))
 	for i, _taxid := range _taxids {
-		t.LineageNames[i] = taxdb.Names[_taxid]
+		t.LineageNames[i] = taxdb.Name(_taxid)
 	}
 
 	return true
 }
 
+// Targets is a list of Target, sorted by abundance in descending order.
 type Targets []*Target
 
 func (t Targets) Len() int { return len(t) }
@@ -87,6 +90,64 @@ func (t Targets) Swap(i, j int) {
 	t[i], t[j] = t[j], t[i]
 }
 
+// MergeTargets sums up abundances of Targets with the same TaxId,
+// e.g., those merged into the same one. The order is kept.
+func MergeTargets(targets []*Target) []*Target {
+	targets2 := make([]*Target, 0, len(targets))
+	taxid2i := make(map[uint32]int, len(targets))
+	var j int
+	var ok bool
+	for _, target := range targets {
+		if j, ok = taxid2i[target.Taxid]; ok {
+			targets2[j].Abundance += target.Abundance
+		} else {
+			taxid2i[target.Taxid] = len(targets2)
+			targets2 = append(targets2, target)
+		}
+	}
+	return targets2
+}
+
+// RecomputeAbundance recomputes abundances of Targets which are not
+// descendants of others, skipping deleted ones (without lineages).
+// It's used when some TaxIds are deleted in current taxonomy version.
+func (t *Taxonomy) RecomputeAbundance(targets []*Target) {
+	var sum float64
+	isLeaf := make([]bool, len(targets))
+
+	var lca uint32
+	var isAChildOfSomeOne bool
+	for i, target := range targets {
+		if len(target.CompleteLineageTaxids) == 0 {
+			continue
+		}
+		isAChildOfSomeOne = false
+		for j, target2 := range targets {
+			if i == j {
+				continue
+			}
+
+			lca = t.LCA(target.Taxid, target2.Taxid)
+			if lca != 0 && lca == target2.Taxid {
+				isAChildOfSomeOne = true
+				break
+			}
+		}
+
+		if !isAChildOfSomeOne {
+			isLeaf[i] = true
+			sum += target.Abundance
+		}
+	}
+
+	for i, target := range targets {
+		if isLeaf[i] {
+			target.Abundance = target.Abundance / sum
+		}
+	}
+}
+
+// ProfileNode is a node in a taxonomic profile.
 type ProfileNode struct {
 	Taxid         uint32
 	Rank          string
@@ -96,8 +157,10 @@ type ProfileNode struct {
 	Abundance     float64
 }
 
-func generateProfile(taxdb *taxdump.Taxonomy, targets []*Target, summedUp bool) map[uint32]*ProfileNode {
-
+// GenerateProfile creates a taxonomic profile from Targets with taxonomy information added.
+// Abundances are summed up from child to parent TaxIds if summedUp is true.
+func (t *Taxonomy) GenerateProfile(targets []*Target, summedUp bool) map[uint32]*ProfileNode {
+	t.ensure(PartNames)
 	profile := make(map[uint32]*ProfileNode, len(targets))
 
 	for _, target := range targets {
@@ -105,10 +168,10 @@ func generateProfile(taxdb *taxdump.Taxonomy, targets []*Target, summedUp bool)
 			if node, ok := profile[taxid]; !ok {
 				profile[taxid] = &ProfileNode{
 					Taxid:         taxid,
-					Rank:          taxdb.Rank(taxid),
-					TaxonName:     taxdb.Names[taxid],
-					LineageNames:  taxdb.LineageNames(taxid),
-					LineageTaxids: taxdb.LineageTaxIds(taxid),
+					Rank:          t.Rank(taxid),
+					TaxonName:     t.Name(taxid),
+					LineageNames:  t.LineageNames(taxid),
+					LineageTaxids: t.LineageTaxIds(taxid),
 
 					Abundance: target.Abundance,
 				}
@@ -0,0 +1,566 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// Part is a part of the taxonomy data. Parts can be combined with bitwise OR.
+type Part uint32
+
+const (
+	// PartNodes is the child -> parent relationship in nodes.dmp.
+	PartNodes Part = 1 << iota
+	// PartRanks is ranks in nodes.dmp.
+	PartRanks
+	// PartNames is scientific names in names.dmp.
+	PartNames
+	// PartAllNames is names of all name classes in names.dmp,
+	// e.g., synonyms, common names and authorities.
+	PartAllNames
+	// PartDelNodes is deleted TaxIds in delnodes.dmp.
+	PartDelNodes
+	// PartMerged is merged TaxIds in merged.dmp.
+	PartMerged
+	// PartGenCodes is division and genetic code ids in nodes.dmp,
+	// along with division.dmp and gencode.dmp if available.
+	PartGenCodes
+	// PartHosts is potential hosts in host.dmp of new_taxdump.
+	PartHosts
+	// PartTypeMaterial is type materials in typematerial.dmp of new_taxdump.
+	PartTypeMaterial
+
+	// PartAll contains all parts.
+	PartAll = PartNodes | PartRanks | PartNames | PartAllNames | PartDelNodes | PartMerged | PartGenCodes |
+		PartHosts | PartTypeMaterial
+)
+
+// Files are paths of NCBI taxdump files.
+// Empty paths mean the files are not available.
+//
+// Files of new_taxdump are also supported. When Nodes is empty,
+// the tree is built from TaxIdLineage, without ranks. When Names is empty,
+// scientific names are read from FullNameLineage.
+type Files struct {
+	Nodes    string
+	Names    string
+	DelNodes string
+	Merged   string
+	Division string
+	GenCode  string
+
+	TaxIdLineage    string
+	FullNameLineage string
+	Host            string
+	TypeMaterial    string
+}
+
+// data holds parsed parts of the taxonomy data.
+type data struct {
+	idx *index // nodes, ranks and names are in the index if not nil
+
+	// nodes, ranks and scientific names in nodes.dmp and names.dmp
+	nodeList *nodeList
+	nameList *nameList
+
+	// nodes and names from files of new_taxdump
+	nodes    map[uint32]uint32
+	ranks    map[uint32]string
+	names    map[uint32]string
+	allNames map[uint32][]TaxonName
+	delNodes map[uint32]struct{}
+	merged   map[uint32]uint32
+
+	codes        map[uint32]nodeCodes
+	divisions    map[int]Division
+	geneticCodes map[int]GeneticCode
+
+	hosts         map[uint32][]string
+	typeMaterials map[uint32][]TypeMaterial
+}
+
+// source provides the taxonomy data on demand.
+type source interface {
+	// available returns the parts the source can provide.
+	available() Part
+	// load parses the given parts. Other parts parsed along with them
+	// can also be returned, and all the returned parts are recorded in got.
+	load(parts Part) (d *data, got Part, err error)
+}
+
+// Open creates a Taxonomy from NCBI taxdump files,
+// which are not parsed until the data is needed.
+// Use Load to parse the needed parts in parallel in advance.
+func Open(files Files) *Taxonomy {
+	return newLazy(&ncbiSource{files: files})
+}
+
+// OpenIndex creates a Taxonomy from an index file created by WriteIndex.
+// The index is memory-mapped and never released. Parts not in the index,
+// i.e., PartAllNames and PartGenCodes, are parsed from the dump files when needed.
+// Use CheckIndex to check whether the index is up to date before calling this.
+func OpenIndex(file string, files Files) (*Taxonomy, error) {
+	idx, err := readIndex(file)
+	if err != nil {
+		return nil, err
+	}
+	return newLazy(&indexSource{idx: idx, files: &ncbiSource{files: files}}), nil
+}
+
+// OpenArchive creates a Taxonomy from a taxdump archive, see Archive for details.
+// Dump files are streamed from the archive when the data is needed,
+// and delnodes.dmp, merged.dmp, division.dmp and gencode.dmp are optional,
+// so are host.dmp and typematerial.dmp of new_taxdump.tar.gz.
+func OpenArchive(file string) (*Taxonomy, error) {
+	archive, err := NewArchive(file)
+	if err != nil {
+		return nil, err
+	}
+	files := Files{
+		Nodes:    "nodes.dmp",
+		Names:    "names.dmp",
+		DelNodes: "delnodes.dmp",
+		Merged:   "merged.dmp",
+		Division: "division.dmp",
+		GenCode:  "gencode.dmp",
+
+		Host:         "host.dmp",
+		TypeMaterial: "typematerial.dmp",
+	}
+	return newLazy(&ncbiSource{files: files, archive: archive}), nil
+}
+
+func newLazy(src source) *Taxonomy {
+	return &Taxonomy{src: src, avail: src.available()}
+}
+
+// Load parses the given parts in parallel if they are not loaded yet.
+// Parts not provided by the source are ignored.
+// If any part fails to load, it's treated as empty and the error is returned.
+func (t *Taxonomy) Load(parts Part) error {
+	return t.load(parts)
+}
+
+// Loaded returns the parts which have been loaded.
+func (t *Taxonomy) Loaded() Part {
+	return Part(t.loaded.Load())
+}
+
+// Available returns the parts which can be loaded.
+func (t *Taxonomy) Available() Part {
+	return t.avail
+}
+
+// OnLoadError sets a function to handle errors of loading data on demand,
+// where methods like Name and Rank can not return them.
+// The data which fails to load is treated as empty.
+// The first error can also be retrieved with Err.
+func (t *Taxonomy) OnLoadError(fn func(error)) {
+	t.onError = fn
+}
+
+// Err returns the first error of loading data.
+func (t *Taxonomy) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// ensure loads the given parts on demand.
+func (t *Taxonomy) ensure(parts Part) {
+	if Part(t.loaded.Load())&parts == parts&t.avail {
+		return
+	}
+	if err := t.load(parts); err != nil && t.onError != nil {
+		t.onError(err)
+	}
+}
+
+func (t *Taxonomy) load(parts Part) error {
+	parts &= t.avail
+	if Part(t.loaded.Load())&parts == parts {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	loaded := Part(t.loaded.Load())
+	missing := parts &^ loaded
+	if missing == 0 {
+		return nil
+	}
+	// ranks and names are saved in the tree of nodes
+	if missing&(PartRanks|PartNames) > 0 && loaded&PartNodes == 0 {
+		missing |= PartNodes & t.avail
+	}
+
+	d, got, err := t.src.load(missing)
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		// do not retry
+		t.loaded.Store(uint32(loaded | missing))
+		return err
+	}
+
+	got &^= loaded
+	if got&PartNodes > 0 {
+		switch {
+		case d.idx != nil:
+			t.tree = newTreeFromIndex(d.idx)
+		case d.nodeList != nil:
+			t.tree = newTreeFromList(d.nodeList)
+		default:
+			t.tree = newTree(d.nodes)
+		}
+		t.setRoot()
+	}
+	if t.tree == nil { // failed to load nodes
+		t.tree = newTree(nil)
+	}
+	if got&PartRanks > 0 {
+		switch {
+		case d.idx != nil:
+			t.tree.rankIDs, t.tree.ranks = d.idx.RankIDs, d.idx.Ranks
+		case d.nodeList != nil && got&PartNodes > 0:
+			// ranks are in the tree already
+		case d.nodeList != nil:
+			err = t.tree.setRanksFromList(d.nodeList)
+		default:
+			err = t.tree.setRanks(d.ranks)
+		}
+		if err != nil && t.err == nil {
+			t.err = err
+		}
+		t.setRankSet()
+	}
+	if got&PartNames > 0 {
+		switch {
+		case d.idx != nil:
+			t.tree.setNamesFromIndex(d.idx)
+		case d.nameList != nil:
+			t.tree.setNamesFromList(d.nameList)
+		default:
+			if err = t.tree.setNames(d.names); err != nil && t.err == nil {
+				t.err = err
+			}
+		}
+	}
+	if got&PartAllNames > 0 {
+		t.allNames = d.allNames
+		t.nameClasses = make(map[string]int, 16)
+		for _, names := range t.allNames {
+			for _, name := range names {
+				t.nameClasses[name.Class]++
+			}
+		}
+	}
+	if got&PartDelNodes > 0 {
+		t.delNodes = d.delNodes
+	}
+	if got&PartMerged > 0 {
+		t.merged = d.merged
+	}
+	if got&PartGenCodes > 0 {
+		t.codes = d.codes
+		t.divisions = d.divisions
+		t.geneticCodes = d.geneticCodes
+	}
+	if got&PartHosts > 0 {
+		t.hosts = d.hosts
+	}
+	if got&PartTypeMaterial > 0 {
+		t.typeMaterials = d.typeMaterials
+	}
+
+	// the atomic store makes the data visible to goroutines checking t.loaded
+	t.loaded.Store(uint32(loaded | got | missing))
+	return err
+}
+
+// ----------------------------------  sources ---------------------------
+
+// ncbiSource parses NCBI taxdump files in a directory or an archive.
+type ncbiSource struct {
+	files   Files
+	archive *Archive // if not nil, files are names of members in it
+}
+
+func (s *ncbiSource) available() Part {
+	var parts Part
+	if s.files.Nodes != "" {
+		parts |= PartNodes | PartRanks | PartGenCodes
+	} else if s.files.TaxIdLineage != "" {
+		parts |= PartNodes
+	}
+	if s.files.Names != "" {
+		parts |= PartNames | PartAllNames
+	} else if s.files.FullNameLineage != "" {
+		parts |= PartNames
+	}
+	if s.files.DelNodes != "" {
+		parts |= PartDelNodes
+	}
+	if s.files.Merged != "" {
+		parts |= PartMerged
+	}
+	if s.files.Host != "" {
+		parts |= PartHosts
+	}
+	if s.files.TypeMaterial != "" {
+		parts |= PartTypeMaterial
+	}
+	return parts
+}
+
+// dumpJob parses a dump file.
+type dumpJob struct {
+	file     string
+	optional bool // whether the file can be missing in an archive
+	parse    func(r io.Reader) error
+}
+
+func (s *ncbiSource) load(parts Part) (*data, Part, error) {
+	d := &data{}
+	var got Part
+
+	jobs := make([]dumpJob, 0, 8)
+
+	if parts&PartNodes > 0 && s.files.Nodes == "" {
+		jobs = append(jobs, dumpJob{file: s.files.TaxIdLineage, parse: func(r io.Reader) error {
+			lineages, err := ParseTaxIdLineages(r)
+			d.nodes = parentsFromLineages(lineages)
+			return err
+		}})
+		got |= PartNodes
+	} else if parts&(PartNodes|PartRanks) > 0 {
+		withRank := parts&PartRanks > 0
+		jobs = append(jobs, dumpJob{file: s.files.Nodes, parse: func(r io.Reader) (err error) {
+			d.nodeList, err = parseNodeList(r, withRank)
+			return err
+		}})
+		got |= PartNodes
+		if withRank {
+			got |= PartRanks
+		}
+	}
+
+	if parts&PartNames > 0 && s.files.Names == "" {
+		jobs = append(jobs, dumpJob{file: s.files.FullNameLineage, parse: func(r io.Reader) (err error) {
+			d.names, _, err = ParseFullNameLineages(r)
+			return err
+		}})
+		got |= PartNames
+	} else if parts&PartNames > 0 {
+		jobs = append(jobs, dumpJob{file: s.files.Names, parse: func(r io.Reader) (err error) {
+			d.nameList, err = parseNameList(r)
+			return err
+		}})
+		got |= PartNames
+	}
+
+	if parts&PartAllNames > 0 {
+		jobs = append(jobs, dumpJob{file: s.files.Names, parse: func(r io.Reader) (err error) {
+			d.allNames, err = ParseAllNames(r)
+			return err
+		}})
+		got |= PartAllNames
+	}
+
+	if parts&PartDelNodes > 0 {
+		jobs = append(jobs, dumpJob{file: s.files.DelNodes, optional: true, parse: func(r io.Reader) (err error) {
+			d.delNodes, err = ParseDelNodes(r)
+			return err
+		}})
+		got |= PartDelNodes
+	}
+
+	if parts&PartMerged > 0 {
+		jobs = append(jobs, dumpJob{file: s.files.Merged, optional: true, parse: func(r io.Reader) (err error) {
+			d.merged, err = ParseMergedNodes(r)
+			return err
+		}})
+		got |= PartMerged
+	}
+
+	if parts&PartGenCodes > 0 {
+		jobs = append(jobs, dumpJob{file: s.files.Nodes, parse: func(r io.Reader) (err error) {
+			d.codes, err = readNodeCodes(r)
+			return err
+		}})
+		if s.files.Division != "" {
+			jobs = append(jobs, dumpJob{file: s.files.Division, optional: true, parse: func(r io.Reader) (err error) {
+				d.divisions, err = ParseDivisions(r)
+				return err
+			}})
+		}
+		if s.files.GenCode != "" {
+			jobs = append(jobs, dumpJob{file: s.files.GenCode, optional: true, parse: func(r io.Reader) (err error) {
+				d.geneticCodes, err = ParseGeneticCodes(r)
+				return err
+			}})
+		}
+		got |= PartGenCodes
+	}
+
+	if parts&PartHosts > 0 {
+		jobs = append(jobs, dumpJob{file: s.files.Host, optional: true, parse: func(r io.Reader) (err error) {
+			d.hosts, err = ParseHosts(r)
+			return err
+		}})
+		got |= PartHosts
+	}
+
+	if parts&PartTypeMaterial > 0 {
+		jobs = append(jobs, dumpJob{file: s.files.TypeMaterial, optional: true, parse: func(r io.Reader) (err error) {
+			d.typeMaterials, err = ParseTypeMaterials(r)
+			return err
+		}})
+		got |= PartTypeMaterial
+	}
+
+	if err := s.run(jobs); err != nil {
+		return nil, 0, err
+	}
+	return d, got, nil
+}
+
+// run runs the jobs in parallel, returning the first error.
+func (s *ncbiSource) run(jobs []dumpJob) error {
+	var readers []io.ReadCloser
+	if s.archive != nil {
+		members := make([]string, len(jobs))
+		for i, job := range jobs {
+			members[i] = job.file
+		}
+		readers = s.archive.OpenMembers(members...)
+	}
+
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job dumpJob) {
+			defer wg.Done()
+
+			if s.archive == nil {
+				errs[i] = readFile(job.file, job.parse)
+				return
+			}
+
+			defer readers[i].Close()
+			err := job.parse(readers[i])
+			if err == nil {
+				return
+			}
+			if errors.Is(err, fs.ErrNotExist) {
+				if !job.optional {
+					errs[i] = err
+				}
+				return
+			}
+			errs[i] = fmt.Errorf("taxonomy: %s: %s: %s", s.archive.File(), job.file, err)
+		}(i, job)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexSource provides nodes, ranks and names in a memory-mapped index file,
+// and creates maps of deleted and merged TaxIds from it,
+// parts not in the index are parsed from the dump files.
+type indexSource struct {
+	idx   *index
+	files *ncbiSource
+}
+
+const indexParts = PartNodes | PartRanks | PartNames | PartDelNodes | PartMerged
+
+func (s *indexSource) available() Part {
+	return indexParts | s.files.available()&^indexParts
+}
+
+func (s *indexSource) load(parts Part) (*data, Part, error) {
+	idx := s.idx
+
+	d := &data{}
+	var got Part
+	var err error
+
+	var wg sync.WaitGroup
+
+	if parts&^indexParts > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var _d *data
+			_d, _, err = s.files.load(parts &^ indexParts)
+			if err == nil {
+				d.allNames = _d.allNames
+				d.codes, d.divisions, d.geneticCodes = _d.codes, _d.divisions, _d.geneticCodes
+				d.hosts, d.typeMaterials = _d.hosts, _d.typeMaterials
+			}
+		}()
+		got |= parts &^ indexParts
+	}
+
+	// arrays in the index are directly used in the tree
+	if parts&(PartNodes|PartRanks|PartNames) > 0 {
+		d.idx = idx
+		got |= parts & (PartNodes | PartRanks | PartNames)
+	}
+
+	if parts&PartDelNodes > 0 {
+		d.delNodes = make(map[uint32]struct{}, len(idx.DelNodes))
+		for _, taxid := range idx.DelNodes {
+			d.delNodes[taxid] = struct{}{}
+		}
+		got |= PartDelNodes
+	}
+
+	if parts&PartMerged > 0 {
+		d.merged = make(map[uint32]uint32, len(idx.MergedFrom))
+		for i, from := range idx.MergedFrom {
+			d.merged[from] = idx.MergedTo[i]
+		}
+		got |= PartMerged
+	}
+
+	wg.Wait()
+
+	if err != nil {
+		return nil, 0, err
+	}
+	return d, got, nil
+}
@@ -0,0 +1,544 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package taxonomy is the taxonomy engine of TaxonKit.
+//
+// It loads NCBI taxdump files (or the binary index created by "taxonkit index"),
+// and provides methods for querying lineages, reformatting lineages,
+// computing lowest common ancestors, converting names to TaxIds,
+// filtering TaxIds by ranks, and generating taxonomic profiles.
+//
+// Unlike the command line interface, functions in this package
+// never write logs or exit the process, errors are returned instead.
+package taxonomy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrRanksNotLoaded means ranks are needed but not loaded.
+var ErrRanksNotLoaded = errors.New("taxonomy: ranks not loaded")
+
+// ErrNamesNotLoaded means names are needed but not loaded.
+var ErrNamesNotLoaded = errors.New("taxonomy: names not loaded")
+
+// Status is the status of a TaxId in the taxonomy.
+type Status int
+
+const (
+	// NotFound means the TaxId is not found in the taxonomy.
+	NotFound Status = iota
+	// Found means the TaxId is found in nodes.dmp.
+	Found
+	// Merged means the TaxId was merged into another one, provided by merged.dmp.
+	Merged
+	// Deleted means the TaxId was deleted, provided by delnodes.dmp.
+	Deleted
+)
+
+// String returns the name of the status: "found", "merged", "deleted" or "unknown",
+// which is used in outputs of all commands.
+func (s Status) String() string {
+	switch s {
+	case Found:
+		return "found"
+	case Merged:
+		return "merged"
+	case Deleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// TaxIdError is returned when a queried TaxId is deleted or not found.
+type TaxIdError struct {
+	TaxId  uint32
+	Status Status
+}
+
+func (e *TaxIdError) Error() string {
+	if e.Status == Deleted {
+		return fmt.Sprintf("taxid %d was deleted", e.TaxId)
+	}
+	return fmt.Sprintf("taxid %d not found", e.TaxId)
+}
+
+// Taxonomy holds the nodes, ranks, names, deleted and merged TaxIds
+// of a taxonomy database.
+//
+// A Taxonomy created with Open or OpenIndex loads each part of the data
+// on the first access, so commands only pay for what they use.
+//
+// Taxonomy is safe for concurrent use once created.
+type Taxonomy struct {
+	src     source
+	avail   Part          // parts the source can provide
+	loaded  atomic.Uint32 // loaded parts
+	mu      sync.Mutex    // for loading data
+	err     error         // the first error of loading data
+	onError func(error)
+
+	rootNode uint32
+
+	tree     *tree                  // nodes, ranks and scientific names
+	allNames map[uint32][]TaxonName // taxid -> names of all classes
+	delNodes map[uint32]struct{}
+	merged   map[uint32]uint32 // from -> to
+
+	codes        map[uint32]nodeCodes
+	divisions    map[int]Division
+	geneticCodes map[int]GeneticCode
+
+	hosts         map[uint32][]string       // taxid -> potential hosts
+	typeMaterials map[uint32][]TypeMaterial // taxid -> type materials
+
+	rankSet map[string]interface{}
+
+	nameClasses map[string]int // name class -> number of names
+
+	onceName2Taxids sync.Once
+	name2taxids     map[string][]uint32
+
+	onceAllName2Taxids sync.Once
+	allName2taxids     map[string][]uint32
+
+	onceEpithet2Names sync.Once
+	epithet2names     map[string][]string // lower-case name without the genus -> scientific names
+
+	onceChildren sync.Once
+	children     map[uint32][]uint32 // parent -> children
+
+	onceNodes sync.Once
+	nodes     map[uint32]uint32 // child -> parent, only created by Nodes
+
+	cacheLCA bool
+	lcaCache sync.Map
+}
+
+// New creates a Taxonomy from parsed data.
+// ranks and names can be nil if they are not needed.
+func New(
+	nodes map[uint32]uint32,
+	ranks map[uint32]string,
+	names map[uint32]string,
+	delNodes map[uint32]struct{},
+	merged map[uint32]uint32,
+) *Taxonomy {
+	if delNodes == nil {
+		delNodes = make(map[uint32]struct{})
+	}
+	if merged == nil {
+		merged = make(map[uint32]uint32)
+	}
+	t := &Taxonomy{
+		avail:    PartNodes | PartDelNodes | PartMerged,
+		tree:     newTree(nodes),
+		delNodes: delNodes,
+		merged:   merged,
+	}
+	t.setRoot()
+	if ranks != nil {
+		t.avail |= PartRanks
+		t.setRanks(ranks)
+	}
+	if names != nil {
+		t.avail |= PartNames
+		t.tree.setNames(names)
+	}
+	t.loaded.Store(uint32(t.avail))
+	return t
+}
+
+// setRoot records the TaxId of the root node.
+func (t *Taxonomy) setRoot() {
+	if t.tree.root != noParent {
+		t.rootNode = t.tree.taxids[t.tree.root]
+	}
+}
+
+// setRanks fills the ranks in the tree and the set of ranks.
+func (t *Taxonomy) setRanks(ranks map[uint32]string) error {
+	if err := t.tree.setRanks(ranks); err != nil {
+		return err
+	}
+	t.setRankSet()
+	return nil
+}
+
+func (t *Taxonomy) setRankSet() {
+	t.rankSet = make(map[string]interface{}, len(t.tree.ranks))
+	for _, rank := range t.tree.ranks {
+		t.rankSet[rank] = struct{}{}
+	}
+}
+
+// HasRanks tells whether ranks are available.
+func (t *Taxonomy) HasRanks() bool { return t.avail&PartRanks > 0 }
+
+// HasNames tells whether names are available.
+func (t *Taxonomy) HasNames() bool { return t.avail&PartNames > 0 }
+
+// NumNodes returns the number of nodes.
+func (t *Taxonomy) NumNodes() int {
+	t.ensure(PartNodes)
+	if t.tree == nil {
+		return 0
+	}
+	return len(t.tree.taxids)
+}
+
+// NumNames returns the number of scientific names.
+func (t *Taxonomy) NumNames() int {
+	t.ensure(PartNames)
+	if t.tree == nil {
+		return 0
+	}
+	return t.tree.numNames
+}
+
+// NumDelNodes returns the number of deleted TaxIds.
+func (t *Taxonomy) NumDelNodes() int {
+	t.ensure(PartDelNodes)
+	return len(t.delNodes)
+}
+
+// NumMerged returns the number of merged TaxIds.
+func (t *Taxonomy) NumMerged() int {
+	t.ensure(PartMerged)
+	return len(t.merged)
+}
+
+// Root returns the root TaxId.
+func (t *Taxonomy) Root() uint32 {
+	t.ensure(PartNodes)
+	return t.rootNode
+}
+
+// Ranks returns all ranks in the taxonomy. The returned map should not be modified.
+func (t *Taxonomy) Ranks() map[string]interface{} {
+	t.ensure(PartRanks)
+	return t.rankSet
+}
+
+// TaxIds returns all TaxIds in nodes.dmp in ascending order.
+// The returned slice should not be modified.
+func (t *Taxonomy) TaxIds() []uint32 {
+	t.ensure(PartNodes)
+	if t.tree == nil {
+		return nil
+	}
+	return t.tree.taxids
+}
+
+// Nodes returns the child -> parent mapping, which is created on the first call.
+// Please use Parent or TaxIds if possible, as the map costs much more memory
+// than the compact tree. The returned map should not be modified.
+func (t *Taxonomy) Nodes() map[uint32]uint32 {
+	t.ensure(PartNodes)
+	t.onceNodes.Do(func() {
+		n := t.NumNodes()
+		t.nodes = make(map[uint32]uint32, n)
+		for i := 0; i < n; i++ {
+			t.nodes[t.tree.taxids[i]] = t.tree.parent(i)
+		}
+	})
+	return t.nodes
+}
+
+// DelNodes returns deleted TaxIds. The returned map should not be modified.
+func (t *Taxonomy) DelNodes() map[uint32]struct{} {
+	t.ensure(PartDelNodes)
+	return t.delNodes
+}
+
+// MergedNodes returns the mapping of merged TaxIds to the new ones.
+// The returned map should not be modified.
+func (t *Taxonomy) MergedNodes() map[uint32]uint32 {
+	t.ensure(PartMerged)
+	return t.merged
+}
+
+// Parent returns the parent of a TaxId, merged TaxIds are not considered.
+func (t *Taxonomy) Parent(taxid uint32) (uint32, bool) {
+	t.ensure(PartNodes)
+	i, ok := t.tree.index(taxid)
+	if !ok {
+		return 0, false
+	}
+	return t.tree.parent(i), true
+}
+
+// Children returns child TaxIds of a TaxId in ascending order.
+// The parent -> children mapping is created on the first call.
+func (t *Taxonomy) Children(taxid uint32) []uint32 {
+	t.ensure(PartNodes)
+	t.onceChildren.Do(func() {
+		n := t.NumNodes()
+		t.children = make(map[uint32][]uint32, n>>1)
+		var child, parent uint32
+		for i := 0; i < n; i++ { // children are appended in ascending order
+			child, parent = t.tree.taxids[i], t.tree.parent(i)
+			if child == parent {
+				continue
+			}
+			t.children[parent] = append(t.children[parent], child)
+		}
+	})
+	return t.children[taxid]
+}
+
+// Resolve checks the status of a TaxId.
+// If the TaxId was merged, the new one is returned.
+func (t *Taxonomy) Resolve(taxid uint32) (uint32, Status) {
+	t.ensure(PartNodes | PartDelNodes | PartMerged)
+	if _, ok := t.tree.index(taxid); ok {
+		return taxid, Found
+	}
+	if _, ok := t.delNodes[taxid]; ok {
+		return taxid, Deleted
+	}
+	if newtaxid, ok := t.merged[taxid]; ok {
+		return newtaxid, Merged
+	}
+	return taxid, NotFound
+}
+
+// TaxId checks if a TaxId is valid in the database.
+// If the TaxId was merged, the new one will be returned.
+func (t *Taxonomy) TaxId(taxid uint32) (uint32, bool) {
+	t.ensure(PartNodes | PartMerged)
+	if _, ok := t.tree.index(taxid); ok {
+		return taxid, true
+	}
+
+	// check if it was merged
+	if newtaxid, ok := t.merged[taxid]; ok {
+		return newtaxid, true
+	}
+
+	return taxid, false
+}
+
+// Name returns the scientific name of a TaxId.
+// If being merged, the name of the new TaxId will be returned.
+func (t *Taxonomy) Name(taxid uint32) string {
+	t.ensure(PartNames | PartMerged)
+	if i, ok := t.nodeIndex(taxid); ok {
+		return t.tree.name(i)
+	}
+	return ""
+}
+
+// Rank returns the rank of a TaxId.
+// If being merged, the rank of the new TaxId will be returned.
+// If the TaxId is not found or deleted, empty will be returned.
+func (t *Taxonomy) Rank(taxid uint32) string {
+	t.ensure(PartRanks | PartMerged)
+	if i, ok := t.nodeIndex(taxid); ok {
+		return t.tree.rank(i)
+	}
+	return "" // taxid not found or deleted
+}
+
+// nodeIndex returns the index of a TaxId in the tree.
+// If the TaxId was merged, the index of the new one is returned.
+func (t *Taxonomy) nodeIndex(taxid uint32) (int, bool) {
+	if i, ok := t.tree.index(taxid); ok {
+		return i, true
+	}
+	if newtaxid, ok := t.merged[taxid]; ok {
+		return t.tree.index(newtaxid)
+	}
+	return 0, false
+}
+
+// LineageTaxIds returns TaxIds of the complete lineage, from the top to the TaxId.
+// If the TaxId was merged, the lineage of the new one is returned.
+// nil is returned for deleted or unknown TaxIds.
+func (t *Taxonomy) LineageTaxIds(taxid uint32) []uint32 {
+	t.ensure(PartNodes | PartMerged)
+	i, ok := t.nodeIndex(taxid)
+	if !ok {
+		return nil
+	}
+	list := t.tree.lineage(i)
+	for j, k := range list {
+		list[j] = t.tree.taxids[k]
+	}
+	return list
+}
+
+// LineageNames returns names of the complete lineage.
+func (t *Taxonomy) LineageNames(taxid uint32) []string {
+	t.ensure(PartNodes | PartNames | PartMerged)
+	i, ok := t.nodeIndex(taxid)
+	if !ok {
+		return nil
+	}
+	list := t.tree.lineage(i)
+	if list == nil {
+		return nil
+	}
+	names := make([]string, len(list))
+	for j, k := range list {
+		names[j] = t.tree.name(int(k))
+	}
+	return names
+}
+
+// LineageRanks returns ranks of the complete lineage.
+func (t *Taxonomy) LineageRanks(taxid uint32) []string {
+	t.ensure(PartNodes | PartRanks | PartMerged)
+	i, ok := t.nodeIndex(taxid)
+	if !ok {
+		return nil
+	}
+	list := t.tree.lineage(i)
+	if list == nil {
+		return nil
+	}
+	ranks := make([]string, len(list))
+	for j, k := range list {
+		ranks[j] = t.tree.rank(int(k))
+	}
+	return ranks
+}
+
+// Lineage is the complete lineage of a TaxId, from the top to the TaxId.
+type Lineage struct {
+	TaxId uint32 // the queried TaxId, or the new one if it was merged
+
+	TaxIds []uint32
+	Names  []string
+	Ranks  []string // nil if ranks are not loaded
+}
+
+// String joins names of the lineage with a delimiter.
+func (l *Lineage) String(delimiter string) string {
+	return strings.Join(l.Names, delimiter)
+}
+
+// Lineage returns the complete lineage of a TaxId.
+// A *TaxIdError is returned if the TaxId is deleted or not found.
+// Callers can compare Lineage.TaxId with the query to detect merged TaxIds.
+func (t *Taxonomy) Lineage(taxid uint32) (*Lineage, error) {
+	newtaxid, status := t.Resolve(taxid)
+	switch status {
+	case Deleted, NotFound:
+		return nil, &TaxIdError{TaxId: taxid, Status: status}
+	}
+
+	t.ensure(PartNames | PartRanks)
+	i, _ := t.tree.index(newtaxid)
+	list := t.tree.lineage(i)
+	l := &Lineage{TaxId: newtaxid, TaxIds: make([]uint32, len(list))}
+	l.Names = make([]string, len(list))
+	for j, k := range list {
+		l.TaxIds[j] = t.tree.taxids[k]
+		l.Names[j] = t.tree.name(int(k))
+	}
+	if t.HasRanks() {
+		l.Ranks = make([]string, len(list))
+		for j, k := range list {
+			l.Ranks[j] = t.tree.rank(int(k))
+		}
+	}
+	return l, nil
+}
+
+// CacheLCA tells the Taxonomy to cache every LCA query result.
+func (t *Taxonomy) CacheLCA() {
+	t.cacheLCA = true
+}
+
+// LCA returns the lowest common ancestor of two TaxIds,
+// 0 is returned if any of them is not found.
+func (t *Taxonomy) LCA(a uint32, b uint32) uint32 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	t.ensure(PartNodes | PartMerged)
+	if a == b {
+		return a
+	}
+
+	// check cache
+	var ok bool
+
+	var query uint64
+	var tmp interface{}
+	if t.cacheLCA {
+		query = uint64(a)<<32 | uint64(b)
+
+		tmp, ok = t.lcaCache.Load(query)
+		if ok {
+			return tmp.(uint32)
+		}
+	}
+
+	lca := t.lca(a, b)
+	if t.cacheLCA {
+		t.lcaCache.Store(query, lca)
+	}
+	return lca
+}
+
+func (t *Taxonomy) lca(a uint32, b uint32) uint32 {
+	ia, ok := t.nodeIndex(a)
+	if !ok {
+		return 0
+	}
+	ib, ok := t.nodeIndex(b)
+	if !ok {
+		return 0
+	}
+	i := t.tree.lca(uint32(ia), uint32(ib))
+	if i == noParent {
+		return 0
+	}
+	return t.tree.taxids[i]
+}
+
+// LCAOf returns the lowest common ancestor of a list of valid TaxIds.
+// 0 is returned for an empty list.
+func (t *Taxonomy) LCAOf(taxids []uint32) uint32 {
+	switch len(taxids) {
+	case 0:
+		return 0
+	case 1:
+		return taxids[0]
+	}
+	lca := taxids[0]
+	for _, taxid := range taxids[1:] {
+		lca = t.LCA(lca, taxid)
+	}
+	return lca
+}
+
+func reverseUint32s(s []uint32) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
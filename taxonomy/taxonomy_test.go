@@ -0,0 +1,254 @@
+// Copyright © 2016-2022 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package taxonomy
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// The test data in testdata/ is a tiny taxdump:
+//
+//	1 root
+//	├── 131567 cellular organisms
+//	│   ├── 2 Bacteria
+//	│   │   └── 1224 > 1236 > 91347 > 543 Enterobacteriaceae
+//	│   │       ├── 561 Escherichia > 562 Escherichia coli > 83333 Escherichia coli K-12
+//	│   │       └── 620 Shigella > 622 Shigella dysenteriae
+//	│   └── 2759 Eukaryota > 9604 Hominidae > 9605 Homo > 9606 Homo sapiens
+//	└── 10239 Viruses
+//
+// TaxIds 12 and 30 were merged into 562 and 9606, and 3 and 4 were deleted.
+
+func testdataFiles(dir string) Files {
+	return Files{
+		Nodes:    filepath.Join(dir, "nodes.dmp"),
+		Names:    filepath.Join(dir, "names.dmp"),
+		DelNodes: filepath.Join(dir, "delnodes.dmp"),
+		Merged:   filepath.Join(dir, "merged.dmp"),
+	}
+}
+
+func openTestdata(t *testing.T) *Taxonomy {
+	t.Helper()
+	taxdb := Open(testdataFiles("testdata"))
+	if err := taxdb.Load(PartNodes | PartRanks | PartNames | PartDelNodes | PartMerged); err != nil {
+		t.Fatal(err)
+	}
+	return taxdb
+}
+
+func TestResolve(t *testing.T) {
+	taxdb := openTestdata(t)
+
+	tests := []struct {
+		taxid  uint32
+		want   uint32
+		status Status
+	}{
+		{562, 562, Found},
+		{1, 1, Found},
+		{12, 562, Merged},
+		{30, 9606, Merged},
+		{3, 3, Deleted},
+		{4, 4, Deleted},
+		{99, 99, NotFound},
+	}
+	for _, test := range tests {
+		taxid, status := taxdb.Resolve(test.taxid)
+		if taxid != test.want || status != test.status {
+			t.Errorf("Resolve(%d) = %d, %s, want %d, %s", test.taxid, taxid, status, test.want, test.status)
+		}
+	}
+}
+
+func TestLineage(t *testing.T) {
+	taxdb := openTestdata(t)
+
+	// lineages start below the root, except the one of the root itself
+	tests := []struct {
+		taxid  uint32
+		taxids []uint32
+		names  []string
+		ranks  []string
+		status Status // status of errors, Found or Merged for no error
+	}{
+		{
+			taxid:  83333,
+			taxids: []uint32{131567, 2, 1224, 1236, 91347, 543, 561, 562, 83333},
+			names: []string{"cellular organisms", "Bacteria", "Pseudomonadota", "Gammaproteobacteria",
+				"Enterobacterales", "Enterobacteriaceae", "Escherichia", "Escherichia coli", "Escherichia coli K-12"},
+			ranks: []string{"cellular root", "domain", "phylum", "class",
+				"order", "family", "genus", "species", "strain"},
+			status: Found,
+		},
+		{
+			taxid:  30, // merged into 9606
+			taxids: []uint32{131567, 2759, 9604, 9605, 9606},
+			names:  []string{"cellular organisms", "Eukaryota", "Hominidae", "Homo", "Homo sapiens"},
+			ranks:  []string{"cellular root", "domain", "family", "genus", "species"},
+			status: Merged,
+		},
+		{
+			taxid:  1,
+			taxids: []uint32{1},
+			names:  []string{"root"},
+			ranks:  []string{"no rank"},
+			status: Found,
+		},
+		{taxid: 3, status: Deleted},
+		{taxid: 99, status: NotFound},
+	}
+	for _, test := range tests {
+		l, err := taxdb.Lineage(test.taxid)
+		if test.status == Deleted || test.status == NotFound {
+			var e *TaxIdError
+			if !errors.As(err, &e) || e.TaxId != test.taxid || e.Status != test.status {
+				t.Errorf("Lineage(%d): error %v, want a TaxIdError of %s", test.taxid, err, test.status)
+			}
+			if list := taxdb.LineageTaxIds(test.taxid); list != nil {
+				t.Errorf("LineageTaxIds(%d) = %v, want nil", test.taxid, list)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Lineage(%d): %s", test.taxid, err)
+			continue
+		}
+		if want := test.taxids[len(test.taxids)-1]; l.TaxId != want {
+			t.Errorf("Lineage(%d).TaxId = %d, want %d", test.taxid, l.TaxId, want)
+		}
+		if !slices.Equal(l.TaxIds, test.taxids) {
+			t.Errorf("Lineage(%d).TaxIds = %v, want %v", test.taxid, l.TaxIds, test.taxids)
+		}
+		if !slices.Equal(l.Names, test.names) {
+			t.Errorf("Lineage(%d).Names = %q, want %q", test.taxid, l.Names, test.names)
+		}
+		if !slices.Equal(l.Ranks, test.ranks) {
+			t.Errorf("Lineage(%d).Ranks = %q, want %q", test.taxid, l.Ranks, test.ranks)
+		}
+
+		if list := taxdb.LineageTaxIds(test.taxid); !slices.Equal(list, test.taxids) {
+			t.Errorf("LineageTaxIds(%d) = %v, want %v", test.taxid, list, test.taxids)
+		}
+		if list := taxdb.LineageNames(test.taxid); !slices.Equal(list, test.names) {
+			t.Errorf("LineageNames(%d) = %q, want %q", test.taxid, list, test.names)
+		}
+		if list := taxdb.LineageRanks(test.taxid); !slices.Equal(list, test.ranks) {
+			t.Errorf("LineageRanks(%d) = %q, want %q", test.taxid, list, test.ranks)
+		}
+	}
+}
+
+func TestLCA(t *testing.T) {
+	taxdb := openTestdata(t)
+
+	tests := []struct {
+		a, b uint32
+		want uint32
+	}{
+		{562, 562, 562},
+		{562, 83333, 562},
+		{83333, 562, 562},
+		{562, 622, 543},
+		{83333, 9606, 131567},
+		{9606, 10239, 1},
+		{1, 9606, 1},
+		{12, 622, 543},   // merged into 562
+		{12, 562, 562},   // merged into 562
+		{30, 12, 131567}, // both merged
+		{3, 562, 0},      // deleted
+		{562, 99, 0},     // not found
+		{0, 562, 0},
+	}
+	for _, test := range tests {
+		if lca := taxdb.LCA(test.a, test.b); lca != test.want {
+			t.Errorf("LCA(%d, %d) = %d, want %d", test.a, test.b, lca, test.want)
+		}
+	}
+
+	taxdb.CacheLCA()
+	for _, test := range tests {
+		for i := 0; i < 2; i++ { // the second query hits the cache
+			if lca := taxdb.LCA(test.a, test.b); lca != test.want {
+				t.Errorf("cached LCA(%d, %d) = %d, want %d", test.a, test.b, lca, test.want)
+			}
+		}
+	}
+
+	listTests := []struct {
+		taxids []uint32
+		want   uint32
+	}{
+		{nil, 0},
+		{[]uint32{9606}, 9606},
+		{[]uint32{562, 622, 83333}, 543},
+		{[]uint32{562, 622, 9606}, 131567},
+	}
+	for _, test := range listTests {
+		if lca := taxdb.LCAOf(test.taxids); lca != test.want {
+			t.Errorf("LCAOf(%v) = %d, want %d", test.taxids, lca, test.want)
+		}
+	}
+}
+
+func TestExpandGenusAbbreviation(t *testing.T) {
+	taxdb := openTestdata(t)
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"E. coli", []string{"Escherichia coli"}},
+		{"E.coli", []string{"Escherichia coli"}},
+		{"Esch. coli", []string{"Escherichia coli"}},
+		{"E. coli k-12", []string{"Escherichia coli K-12"}},
+		{"S. dysenteriae", []string{"Shigella dysenteriae"}},
+		{"H. sapiens", []string{"Homo sapiens"}},
+		{"B. coli", nil}, // "Bacillus coli" is a synonym
+		{"S. coli", nil},
+		{"Escherichia coli", nil},
+		{"e. coli", nil},
+		{"E.", nil},
+	}
+	for _, test := range tests {
+		if names := taxdb.ExpandGenusAbbreviation(test.name); !slices.Equal(names, test.want) {
+			t.Errorf("ExpandGenusAbbreviation(%q) = %q, want %q", test.name, names, test.want)
+		}
+	}
+
+	// several genera match
+	taxdb = New(
+		map[uint32]uint32{1: 1, 561: 1, 562: 561, 547: 1, 550: 547},
+		nil,
+		map[uint32]string{1: "root", 561: "Escherichia", 562: "Escherichia coli", 547: "Enterobacter", 550: "Enterobacter coli"},
+		nil, nil)
+	want := []string{"Enterobacter coli", "Escherichia coli"}
+	if names := taxdb.ExpandGenusAbbreviation("E. coli"); !slices.Equal(names, want) {
+		t.Errorf("ExpandGenusAbbreviation(%q) = %q, want %q", "E. coli", names, want)
+	}
+	want = []string{"Escherichia coli"}
+	if names := taxdb.ExpandGenusAbbreviation("Es. coli"); !slices.Equal(names, want) {
+		t.Errorf("ExpandGenusAbbreviation(%q) = %q, want %q", "Es. coli", names, want)
+	}
+}